@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"strings"
 
 	"github.com/joho/godotenv"
@@ -22,18 +24,25 @@ func main() {
 		log.Println(".env file not found, using environment variables instead")
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
 	url := os.Getenv("S01E01_URL")
 	username := os.Getenv("S01E01_USERNAME")
 	password := os.Getenv("S01E01_PASSWORD")
 
 	container := di.NewContainer(di.Services)
+	client, ok := container.Get("http_client").(*http.Client)
+	if !ok {
+		panic("http_client factory failed")
+	}
 
 	question := getQuestion(container, url)
 	fmt.Println(question)
-	answer := answerQuestion(container, question)
+	answer := answerQuestion(ctx, container, question)
 	fmt.Println(answer)
 
-	postVariables(url, username, password, answer)
+	postVariables(client, url, username, password, answer)
 }
 
 func getQuestion(container *di.Container, url string) string {
@@ -77,12 +86,18 @@ func findHumanQuestion(n *html.Node) string {
 	return ""
 }
 
-func answerQuestion(container *di.Container, question string) string {
+func answerQuestion(ctx context.Context, container *di.Container, question string) string {
 	llm, ok := container.Get("openai").(*openai.OpenAI)
 	if !ok {
 		panic("openai factory failed")
 	}
 
+	costs, ok := container.Get("openai_costs").(*openai.CostTracker)
+	if !ok {
+		panic("openai_costs factory failed")
+	}
+	defer costs.Report(os.Stderr, os.Getenv("OPENAI_COST_REPORT"))
+
 	messages := []openai.Message{
 		{
 			Role:    "system",
@@ -93,7 +108,10 @@ func answerQuestion(container *di.Container, question string) string {
 			Content: question,
 		},
 	}
-	resp := llm.GetCompletionShort(messages, "gpt-3.5-turbo")
+	resp, err := llm.GetCompletionShort(ctx, messages, "gpt-3.5-turbo")
+	if err != nil {
+		panic(err)
+	}
 	if len(resp.Choices) == 0 {
 		panic("no choices in response from LLM")
 	}
@@ -101,7 +119,7 @@ func answerQuestion(container *di.Container, question string) string {
 	return resp.Choices[0].Message.Content
 }
 
-func postVariables(pageUrl, username, password, answer string) {
+func postVariables(client *http.Client, pageUrl, username, password, answer string) {
 	form := url.Values{}
 	form.Add("username", username)
 	form.Add("password", password)
@@ -113,7 +131,6 @@ func postVariables(pageUrl, username, password, answer string) {
 		panic(err)
 	}
 
-	var client = &http.Client{}
 	response, err := client.Do(request)
 	if err != nil {
 		panic(err)
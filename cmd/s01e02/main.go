@@ -2,12 +2,14 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 
 	"github.com/joho/godotenv"
 	"woyteck.pl/ai_devs3/internal/di"
@@ -25,25 +27,34 @@ func main() {
 		log.Println(".env file not found, using environment variables instead")
 	}
 
-	response1 := send(Message{Text: "READY", MsgID: 0})
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	container := di.NewContainer(di.Services)
+	client, ok := container.Get("http_client").(*http.Client)
+	if !ok {
+		panic("http_client factory failed")
+	}
+
+	response1 := send(client, Message{Text: "READY", MsgID: 0})
 	id := response1.MsgID
 	fmt.Println(response1.Text)
 
-	answer := askLLM(response1.Text)
+	answer := askLLM(ctx, response1.Text)
 	fmt.Println(answer)
 
-	response2 := send(Message{Text: answer, MsgID: id})
+	response2 := send(client, Message{Text: answer, MsgID: id})
 	fmt.Println(response2.Text)
 }
 
-func send(msg Message) Message {
+func send(client *http.Client, msg Message) Message {
 	url := os.Getenv("S01E02_URL")
 	body, err := json.Marshal(msg)
 	if err != nil {
 		panic(err)
 	}
 
-	response, err := http.Post(url, "application/json", bytes.NewBuffer(body))
+	response, err := client.Post(url, "application/json", bytes.NewBuffer(body))
 	if err != nil {
 		panic(err)
 	}
@@ -59,13 +70,19 @@ func send(msg Message) Message {
 	return responseBody
 }
 
-func askLLM(question string) string {
+func askLLM(ctx context.Context, question string) string {
 	container := di.NewContainer(di.Services)
 	llm, ok := container.Get("openai").(*openai.OpenAI)
 	if !ok {
 		panic("openai factory failed")
 	}
 
+	costs, ok := container.Get("openai_costs").(*openai.CostTracker)
+	if !ok {
+		panic("openai_costs factory failed")
+	}
+	defer costs.Report(os.Stderr, os.Getenv("OPENAI_COST_REPORT"))
+
 	messages := []openai.Message{
 		{
 			Role: "system",
@@ -81,7 +98,10 @@ Nie odpowiadam całym zdaniem, odpowiadam jak najmniejsza liczbą słów
 			Content: question,
 		},
 	}
-	resp := llm.GetCompletionShort(messages, "gpt-3.5-turbo")
+	resp, err := llm.GetCompletionShort(ctx, messages, "gpt-3.5-turbo")
+	if err != nil {
+		panic(err)
+	}
 	if len(resp.Choices) == 0 {
 		panic("no choices in response from LLM")
 	}
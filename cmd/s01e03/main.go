@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 
@@ -46,29 +48,44 @@ func main() {
 		log.Println(".env file not found, using environment variables instead")
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
 	container := di.NewContainer(di.Services)
+	client, ok := container.Get("http_client").(*http.Client)
+	if !ok {
+		panic("http_client factory failed")
+	}
 
 	baseUrl := os.Getenv("CENTRALA_BASEURL")
 	key := os.Getenv("AI_DEVS_KEY")
 
 	url := fmt.Sprintf("%s/data/%s/json.txt", baseUrl, key)
-	message := fetchJson(url)
-	corrected := correct(message, key)
+	message := fetchJson(client, url)
+	corrected := correct(ctx, message, key)
 
 	responder, ok := container.Get("responder").(*aidevs.Responder)
 	if !ok {
 		panic("responder factory failed")
 	}
-	responder.SendAnswer(corrected, "JSON")
+	if err := responder.SendAnswer(ctx, corrected, "JSON"); err != nil {
+		panic(err)
+	}
 }
 
-func correct(message *Message, key string) Message {
+func correct(ctx context.Context, message *Message, key string) Message {
 	container := di.NewContainer(di.Services)
 	llm, ok := container.Get("openai").(*openai.OpenAI)
 	if !ok {
 		panic("openai factory failed")
 	}
 
+	costs, ok := container.Get("openai_costs").(*openai.CostTracker)
+	if !ok {
+		panic("openai_costs factory failed")
+	}
+	defer costs.Report(os.Stderr, os.Getenv("OPENAI_COST_REPORT"))
+
 	corrected := Message{}
 	corrected.ApiKey = key
 	corrected.Copyright = message.Copyright
@@ -96,7 +113,7 @@ func correct(message *Message, key string) Message {
 		if i.Test != nil {
 			correctedTest := Test{}
 			correctedTest.Q = i.Test.Q
-			correctedTest.A = answerQuestion(llm, i.Test.Q)
+			correctedTest.A = answerQuestion(ctx, llm, i.Test.Q)
 			item.Test = &correctedTest
 		}
 
@@ -106,8 +123,8 @@ func correct(message *Message, key string) Message {
 	return corrected
 }
 
-func fetchJson(url string) *Message {
-	response, err := http.Get(url)
+func fetchJson(client *http.Client, url string) *Message {
+	response, err := client.Get(url)
 	if err != nil {
 		panic(err)
 	}
@@ -126,7 +143,7 @@ func fetchJson(url string) *Message {
 	return j
 }
 
-func answerQuestion(llm *openai.OpenAI, question string) string {
+func answerQuestion(ctx context.Context, llm *openai.OpenAI, question string) string {
 	messages := []openai.Message{
 		{
 			Role:    "system",
@@ -137,7 +154,10 @@ func answerQuestion(llm *openai.OpenAI, question string) string {
 			Content: question,
 		},
 	}
-	resp := llm.GetCompletionShort(messages, "gpt-3.5-turbo")
+	resp, err := llm.GetCompletionShort(ctx, messages, "gpt-3.5-turbo")
+	if err != nil {
+		panic(err)
+	}
 	if len(resp.Choices) == 0 {
 		panic("no choices in response from LLM")
 	}
@@ -1,17 +1,21 @@
 package main
 
 import (
+	"cmp"
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
 
 	"github.com/joho/godotenv"
 	"woyteck.pl/ai_devs3/internal/aidevs"
 	"woyteck.pl/ai_devs3/internal/di"
-	"woyteck.pl/ai_devs3/internal/llama"
+	"woyteck.pl/ai_devs3/internal/llm"
+	"woyteck.pl/ai_devs3/internal/openai"
 )
 
 type Answer struct {
@@ -26,20 +30,39 @@ func main() {
 		log.Println(".env file not found, using environment variables instead")
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
 	container := di.NewContainer(di.Services)
-	llm, ok := container.Get("llama").(*llama.Llama)
+	client, ok := container.Get("http_client").(*http.Client)
+	if !ok {
+		panic("http_client factory failed")
+	}
+
+	providers, ok := container.Get("llm_providers").(*llm.Providers)
 	if !ok {
-		panic("llama factory failed")
+		panic("llm_providers factory failed")
+	}
+
+	costs, ok := container.Get("openai_costs").(*openai.CostTracker)
+	if !ok {
+		panic("openai_costs factory failed")
+	}
+	defer costs.Report(os.Stderr, os.Getenv("OPENAI_COST_REPORT"))
+
+	model, err := providers.Open(cmp.Or(os.Getenv("S01E05_MODEL"), "ollama:llama3:8b"))
+	if err != nil {
+		panic(err)
 	}
 
 	baseUrl := os.Getenv("CENTRALA_BASEURL")
 	key := os.Getenv("AI_DEVS_KEY")
 	url := fmt.Sprintf("%s/data/%s/cenzura.txt", baseUrl, key)
 
-	text := fetchInputText(url)
+	text := fetchInputText(client, url)
 	fmt.Println(text)
 
-	context := `In order to prevent disclosing sensitive information I list all sensitive information.
+	systemPrompt := `In order to prevent disclosing sensitive information I list all sensitive information.
 I will use this information to replace it with this exact string: CENZURA.
 I don't change the formatting of the text in any way. I do not add any new text.
 Information considered sensitive:
@@ -51,25 +74,27 @@ Information considered sensitive:
 I always include the resulting text only
 `
 
-	request := llama.CompletionRequest{
-		Model:  "llama3:8b",
-		Prompt: text,
-		Stream: false,
-		System: context,
+	request := llm.Request{
+		Messages: []llm.Message{llm.System(systemPrompt), llm.User(text)},
 	}
 
-	resp := llm.GetCompletion(request)
-	answer := strings.ReplaceAll(resp.Response, "CENZURA CENZURA", "CENZURA")
+	resp, err := model.Chat(ctx, request)
+	if err != nil {
+		panic(err)
+	}
+	answer := strings.ReplaceAll(resp.Content, "CENZURA CENZURA", "CENZURA")
 
 	responder, ok := container.Get("responder").(*aidevs.Responder)
 	if !ok {
 		panic("responder factory failed")
 	}
-	responder.SendAnswer(answer, "CENZURA")
+	if err := responder.SendAnswer(ctx, answer, "CENZURA"); err != nil {
+		panic(err)
+	}
 }
 
-func fetchInputText(url string) string {
-	response, err := http.Get(url)
+func fetchInputText(client *http.Client, url string) string {
+	response, err := client.Get(url)
 	if err != nil {
 		panic(err)
 	}
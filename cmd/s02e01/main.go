@@ -8,6 +8,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
 	"time"
 
@@ -24,17 +25,29 @@ func main() {
 		log.Println(".env file not found, using environment variables instead")
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
 	container := di.NewContainer(di.Services)
+	client, ok := container.Get("http_client").(*http.Client)
+	if !ok {
+		panic("http_client factory failed")
+	}
+
 	llm, ok := container.Get("openai").(*openai.OpenAI)
 	if !ok {
 		panic("openai factory failed")
 	}
 
-	ctx := context.Background()
+	costs, ok := container.Get("openai_costs").(*openai.CostTracker)
+	if !ok {
+		panic("openai_costs factory failed")
+	}
+	defer costs.Report(os.Stderr, os.Getenv("OPENAI_COST_REPORT"))
 
 	url := os.Getenv("S02E01_URL")
 
-	zipFile := fetchZip(url)
+	zipFile := fetchZip(client, url)
 	destination := "/tmp/archive.zip"
 
 	f, err := os.Create(destination)
@@ -60,7 +73,7 @@ func main() {
 		panic("openai factory failed")
 	}
 
-	interrigations, err := cache.Get(ctx, "interrigations").Result()
+	interrigations, err := cache.Get(ctx, "interrigations:timestamps").Result()
 	if err != nil {
 		fmt.Println("cache miss")
 
@@ -76,19 +89,31 @@ func main() {
 				panic(err)
 			}
 
-			transcription := llm.GetTranscription(fileContents, "whisper-1", "m4a")
-			fmt.Println(transcription)
-			transcriptions = append(transcriptions, transcription)
+			transcription, err := llm.TranscribeChunked(ctx, openai.TranscriptionRequest{
+				File:                   fileContents,
+				FileName:               f.Name,
+				Model:                  "whisper-1",
+				Language:               "pl",
+				ResponseFormat:         openai.TranscriptionFormatVerboseJSON,
+				TimestampGranularities: []string{openai.TimestampGranularitySegment},
+			}, openai.ChunkOptions{})
+			if err != nil {
+				panic(err)
+			}
+
+			transcript := citeSegments(f.Name, transcription.Segments)
+			fmt.Println(transcript)
+			transcriptions = append(transcriptions, transcript)
 		}
 
-		interrigations = strings.Join(transcriptions, "\n")
+		interrigations = strings.Join(transcriptions, "\n\n")
 
-		cache.Set(ctx, "interrigations", interrigations, time.Hour)
+		cache.Set(ctx, "interrigations:timestamps", interrigations, time.Hour)
 	} else {
 		fmt.Println("cache hit")
 	}
 
-	context := fmt.Sprintf(
+	systemPrompt := fmt.Sprintf(
 		"%s\n\nTreści przesłuchań świadków:\n%s",
 		"Jestem detektywem, prowadzę dochodzenie w sprawie Andrzeja Maja.\nAnalizuję fakty krok po kroku, używam dedukcji, żeby wyciągnąć wnioski.",
 		interrigations,
@@ -97,14 +122,17 @@ func main() {
 	messages := []openai.Message{
 		{
 			Role:    "system",
-			Content: context,
+			Content: systemPrompt,
 		},
 		{
 			Role:    "user",
-			Content: "Wywnioskuj z treści przesłuchań na jakiej uczelni pracował Andrzej Maj, a potem daj mi adres wydziału tej uczelni, w którym pracował. Zwróć tylko adres, nic więcej.",
+			Content: "Wywnioskuj z treści przesłuchań na jakiej uczelni pracował Andrzej Maj, a potem daj mi adres wydziału tej uczelni, w którym pracował. Uzasadnij wniosek, cytując przesłuchania w formacie [plik mm:ss], a w ostatniej linii podaj sam adres.",
 		},
 	}
-	resp := llm.GetCompletionShort(messages, "gpt-4o")
+	resp, err := llm.GetCompletionShort(ctx, messages, "gpt-4o")
+	if err != nil {
+		panic(err)
+	}
 	if len(resp.Choices) == 0 {
 		panic("no choices in response from LLM")
 	}
@@ -121,7 +149,10 @@ func main() {
 			Content: resp.Choices[0].Message.Content,
 		},
 	}
-	resp = llm.GetCompletionShort(messages, "gpt-3.5-turbo")
+	resp, err = llm.GetCompletionShort(ctx, messages, "gpt-3.5-turbo")
+	if err != nil {
+		panic(err)
+	}
 	if len(resp.Choices) == 0 {
 		panic("no choices in response from LLM")
 	}
@@ -132,11 +163,24 @@ func main() {
 	if !ok {
 		panic("responder factory failed")
 	}
-	responder.SendAnswer(resp.Choices[0].Message.Content, "mp3")
+	if err := responder.SendAnswer(ctx, resp.Choices[0].Message.Content, "mp3"); err != nil {
+		panic(err)
+	}
+}
+
+// citeSegments prefixes every segment with the file name and its start
+// time, so the model can point at where a statement was made.
+func citeSegments(fileName string, segments []openai.TranscriptionSegment) string {
+	lines := []string{}
+	for _, segment := range segments {
+		lines = append(lines, fmt.Sprintf("[%s %s] %s", fileName, openai.Timestamp(segment.Start), strings.TrimSpace(segment.Text)))
+	}
+
+	return strings.Join(lines, "\n")
 }
 
-func fetchZip(url string) []byte {
-	response, err := http.Get(url)
+func fetchZip(client *http.Client, url string) []byte {
+	response, err := client.Get(url)
 	if err != nil {
 		panic(err)
 	}
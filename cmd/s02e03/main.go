@@ -1,12 +1,15 @@
 package main
 
 import (
+	"cmp"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 
 	"github.com/joho/godotenv"
 	"woyteck.pl/ai_devs3/internal/aidevs"
@@ -24,31 +27,47 @@ func main() {
 		log.Println(".env file not found, using environment variables instead")
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
 	container := di.NewContainer(di.Services)
+	client, ok := container.Get("http_client").(*http.Client)
+	if !ok {
+		panic("http_client factory failed")
+	}
+
 	llm, ok := container.Get("openai").(*openai.OpenAI)
 	if !ok {
 		panic("openai factory failed")
 	}
 
+	costs, ok := container.Get("openai_costs").(*openai.CostTracker)
+	if !ok {
+		panic("openai_costs factory failed")
+	}
+	defer costs.Report(os.Stderr, os.Getenv("OPENAI_COST_REPORT"))
+
 	url := fmt.Sprintf("%s/data/%s/robotid.json", os.Getenv("CENTRALA_BASEURL"), os.Getenv("AI_DEVS_KEY"))
-	message := fetchJson(url)
+	message := fetchJson(client, url)
 	fmt.Println(message.Description)
 
-	refinedDescription := refineDescription(llm, message.Description)
+	refinedDescription := refineDescription(ctx, llm, message.Description)
 	fmt.Println(refinedDescription)
 
-	imageUrl := generateImage(llm, refinedDescription)
+	imageUrl := generateImage(ctx, llm, refinedDescription)
 	fmt.Println(imageUrl)
 
 	responder, ok := container.Get("responder").(*aidevs.Responder)
 	if !ok {
 		panic("responder factory failed")
 	}
-	responder.SendAnswer(imageUrl, "robotid")
+	if err := responder.SendAnswer(ctx, imageUrl, "robotid"); err != nil {
+		panic(err)
+	}
 }
 
-func fetchJson(url string) *Message {
-	response, err := http.Get(url)
+func fetchJson(client *http.Client, url string) *Message {
+	response, err := client.Get(url)
 	if err != nil {
 		panic(err)
 	}
@@ -67,7 +86,7 @@ func fetchJson(url string) *Message {
 	return j
 }
 
-func refineDescription(llm *openai.OpenAI, description string) string {
+func refineDescription(ctx context.Context, llm *openai.OpenAI, description string) string {
 	messages := []openai.Message{
 		{
 			Role:    "system",
@@ -78,7 +97,10 @@ func refineDescription(llm *openai.OpenAI, description string) string {
 			Content: description,
 		},
 	}
-	completions := llm.GetCompletionShort(messages, "gpt-4")
+	completions, err := llm.GetCompletionShort(ctx, messages, "gpt-4")
+	if err != nil {
+		panic(err)
+	}
 	if len(completions.Choices) == 0 {
 		panic("no completions returned")
 	}
@@ -86,11 +108,29 @@ func refineDescription(llm *openai.OpenAI, description string) string {
 	return completions.Choices[0].Message.Content
 }
 
-func generateImage(llm *openai.OpenAI, prompt string) string {
-	result := llm.CreateImageShort(prompt)
+// generateImage returns the image URL for centrala and keeps a local copy,
+// since the URL expires after an hour.
+func generateImage(ctx context.Context, llm *openai.OpenAI, prompt string) string {
+	request := openai.CreateImageRequest{
+		Model:  "dall-e-3",
+		Prompt: prompt,
+		N:      1,
+		Size:   "1024x1024",
+	}
+	result, err := llm.CreateImage(ctx, request)
+	if err != nil {
+		panic(err)
+	}
 	if len(result.Data) == 0 {
 		panic("no images generated")
 	}
 
+	dir := cmp.Or(os.Getenv("S02E03_IMAGES_DIR"), "images")
+	paths, err := llm.SaveImages(ctx, result, dir, "robotid", request.Metadata())
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(paths[0])
+
 	return result.Data[0].Url
 }
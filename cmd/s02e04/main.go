@@ -3,15 +3,17 @@ package main
 import (
 	"archive/zip"
 	"context"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -21,6 +23,17 @@ import (
 	"woyteck.pl/ai_devs3/internal/openai"
 )
 
+// categorySeed with temperature 0 keeps categories the same across runs.
+const categorySeed = 4242
+
+// categoryConfidence is how likely a category must be to be accepted
+// without asking the next of categoryModels.
+const categoryConfidence = 0.9
+
+var categoryLabels = []string{"LUDZIE", "HARDWARE", "NIEISTOTNE"}
+
+var categoryModels = []string{"gpt-4o-mini", "gpt-4o"}
+
 type Message struct {
 	Description string `json:"description"`
 }
@@ -41,23 +54,37 @@ func main() {
 		log.Println(".env file not found, using environment variables instead")
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
 	container := di.NewContainer(di.Services)
+	client, ok := container.Get("http_client").(*http.Client)
+	if !ok {
+		panic("http_client factory failed")
+	}
+
 	llm, ok := container.Get("openai").(*openai.OpenAI)
 	if !ok {
 		panic("openai factory failed")
 	}
 
+	costs, ok := container.Get("openai_costs").(*openai.CostTracker)
+	if !ok {
+		panic("openai_costs factory failed")
+	}
+	defer costs.Report(os.Stderr, os.Getenv("OPENAI_COST_REPORT"))
+
 	cache, ok := container.Get("redis").(*redis.Client)
 	if !ok {
 		panic("openai factory failed")
 	}
 
-	notes := fetchNotes(llm, cache)
+	notes := fetchNotes(ctx, client, llm, cache)
 
 	peopleNotes := []Note{}
 	hardwareNotes := []Note{}
 	for _, note := range notes {
-		category := categorizeNote(llm, note.Contents)
+		category := categorizeNote(ctx, llm, note)
 		if category == "LUDZIE" {
 			peopleNotes = append(peopleNotes, note)
 		}
@@ -99,11 +126,13 @@ func main() {
 	if !ok {
 		panic("responder factory failed")
 	}
-	responder.SendAnswer(results, "kategorie")
+	if err := responder.SendAnswer(ctx, results, "kategorie"); err != nil {
+		panic(err)
+	}
 }
 
-func fetchZip(url string) []byte {
-	response, err := http.Get(url)
+func fetchZip(client *http.Client, url string) []byte {
+	response, err := client.Get(url)
 	if err != nil {
 		panic(err)
 	}
@@ -116,9 +145,7 @@ func fetchZip(url string) []byte {
 	return bytes
 }
 
-func fetchNotes(llm *openai.OpenAI, cache *redis.Client) []Note {
-	ctx := context.Background()
-
+func fetchNotes(ctx context.Context, client *http.Client, llm *openai.OpenAI, cache *redis.Client) []Note {
 	var notes []Note
 	cacheKey := "notes_json3"
 	cachedNotes, err := cache.Get(ctx, cacheKey).Result()
@@ -126,7 +153,7 @@ func fetchNotes(llm *openai.OpenAI, cache *redis.Client) []Note {
 		fmt.Println("cache miss")
 
 		url := fmt.Sprintf("%s/dane/pliki_z_fabryki.zip", os.Getenv("CENTRALA_BASEURL"))
-		zipFile := fetchZip(url)
+		zipFile := fetchZip(client, url)
 		destination := "/tmp/archive.zip"
 
 		f, err := os.Create(destination)
@@ -145,7 +172,7 @@ func fetchNotes(llm *openai.OpenAI, cache *redis.Client) []Note {
 		}
 		defer archive.Close()
 
-		notes = collectNotes(llm, archive.File)
+		notes = collectNotes(ctx, llm, archive.File)
 		notesJson, err := json.Marshal(notes)
 		if err != nil {
 			panic(err)
@@ -162,10 +189,12 @@ func fetchNotes(llm *openai.OpenAI, cache *redis.Client) []Note {
 	return notes
 }
 
-func collectNotes(llm *openai.OpenAI, files []*zip.File) []Note {
-	notes := []Note{}
+func collectNotes(ctx context.Context, llm *openai.OpenAI, files []*zip.File) []Note {
+	results := make([]*Note, len(files))
+	workers := make(chan struct{}, 8)
 
-	for _, f := range files {
+	var wg sync.WaitGroup
+	for i, f := range files {
 		if !strings.Contains(f.Name, ".txt") && !strings.Contains(f.Name, ".mp3") && !strings.Contains(f.Name, ".png") {
 			continue
 		}
@@ -174,61 +203,76 @@ func collectNotes(llm *openai.OpenAI, files []*zip.File) []Note {
 			continue
 		}
 
-		file, err := f.Open()
-		if err != nil {
-			panic(err)
-		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			workers <- struct{}{}
+			defer func() { <-workers }()
 
-		fileContents, err := io.ReadAll(file)
-		if err != nil {
-			panic(err)
-		}
+			results[i] = readNote(ctx, llm, f)
+		}()
+	}
+	wg.Wait()
 
-		if strings.Contains(f.Name, ".txt") {
-			notes = append(notes, Note{FileName: f.Name, Contents: string(fileContents)})
+	notes := []Note{}
+	for _, note := range results {
+		if note != nil {
+			notes = append(notes, *note)
 		}
+	}
 
-		if strings.Contains(f.Name, ".mp3") {
-			transcription := llm.GetTranscription(fileContents, "whisper-1", "mp3")
-			notes = append(notes, Note{FileName: f.Name, Contents: transcription})
-		}
+	return notes
+}
+
+func readNote(ctx context.Context, llm *openai.OpenAI, f *zip.File) *Note {
+	file, err := f.Open()
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+
+	fileContents, err := io.ReadAll(file)
+	if err != nil {
+		panic(err)
+	}
+
+	if strings.Contains(f.Name, ".txt") {
+		return &Note{FileName: f.Name, Contents: string(fileContents)}
+	}
 
-		if strings.Contains(f.Name, ".png") {
-			encodedImage := base64.StdEncoding.EncodeToString(fileContents)
-			messages := []openai.ImageMessage{
-				{
-					Role: "system",
-					Content: []openai.Content{
-						{
-							Type: "text",
-							Text: "I return text from given images. Nothing else.",
-						},
-					},
-				},
-				{
-					Role: "user",
-					Content: []openai.Content{
-						{
-							Type: "image_url",
-							ImageURL: openai.ImageURL{
-								URL: "data:image/png;base64," + encodedImage,
-							},
-						},
-					},
-				},
-			}
-			completions := llm.GetImageCompletionShort(messages, "gpt-4o")
-			if len(completions.Choices) > 0 {
-				notes = append(notes, Note{FileName: f.Name, Contents: completions.Choices[0].Message.Content})
-			}
+	if strings.Contains(f.Name, ".mp3") {
+		transcription, err := llm.GetTranscription(ctx, fileContents, "whisper-1", "mp3")
+		if err != nil {
+			panic(err)
 		}
+		return &Note{FileName: f.Name, Contents: transcription}
 	}
 
-	return notes
+	messages := []openai.Message{
+		{
+			Role:    "system",
+			Content: "I return text from given images. Nothing else.",
+		},
+		{
+			Role: "user",
+			Parts: []openai.ContentPart{
+				openai.ImageDataPart("image/png", fileContents, openai.ImageDetailAuto),
+			},
+		},
+	}
+	completions, err := llm.GetCompletionShort(ctx, messages, "gpt-4o")
+	if err != nil {
+		panic(err)
+	}
+	if len(completions.Choices) == 0 {
+		return nil
+	}
+
+	return &Note{FileName: f.Name, Contents: completions.Choices[0].Message.Content}
 }
 
-func categorizeNote(llm *openai.OpenAI, note string) string {
-	context := `Jestem klasyfikatorem notatek
+func categorizeNote(ctx context.Context, llm *openai.OpenAI, note Note) string {
+	systemPrompt := `Jestem klasyfikatorem notatek
 Zwracam w odpowiedzi konkretne słowo jeśli notatka zawiera informację o:
 - schwytanych ludziach: LUDZIE
 - śladach obecności ludzi: LUDZIE
@@ -239,23 +283,40 @@ W pozostałych przypadkach zwracam słowo NIEISTOTNE
 	messages := []openai.Message{
 		{
 			Role:    "system",
-			Content: context,
+			Content: systemPrompt,
 		},
 		{
 			Role:    "user",
-			Content: note,
+			Content: note.Contents,
 		},
 	}
-	resp := llm.GetCompletionShort(messages, "gpt-4o")
-	if len(resp.Choices) == 0 {
-		panic("no choices in response from LLM")
-	}
 
-	result := resp.Choices[0].Message.Content
+	var result openai.Classification
+	for _, model := range categoryModels {
+		request := openai.CompletionRequest{
+			Model:       model,
+			Messages:    messages,
+			Temperature: openai.Ptr(0.0),
+			Seed:        openai.Ptr(categorySeed),
+		}
 
-	if result == "LUDZIE" || result == "HARDWARE" {
-		return result
+		var err error
+		result, err = llm.Classify(ctx, request, categoryLabels)
+		var schemaErr *openai.SchemaError
+		if errors.As(err, &schemaErr) {
+			fmt.Println(schemaErr)
+			return "NIEISTOTNE"
+		}
+		if err != nil {
+			panic(err)
+		}
+		if result.Confident(categoryConfidence) {
+			return result.Label
+		}
+		log.Printf("%s: %s from %s is only %.2f likely", note.FileName, result.Label, model, result.Probability)
 	}
 
-	return "NIEISTOTNE"
+	log.Printf("%s: review needed, keeping %s", note.FileName, result.Label)
+
+	return result.Label
 }
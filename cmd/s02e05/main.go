@@ -2,12 +2,13 @@ package main
 
 import (
 	"context"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"time"
@@ -20,6 +21,10 @@ import (
 	"woyteck.pl/ai_devs3/internal/openai"
 )
 
+// factsTokenBudget leaves room in gpt-4-turbo's 128k context for the
+// question and the reply.
+const factsTokenBudget = 120000
+
 type Image struct {
 	Url     string
 	Caption string
@@ -48,25 +53,44 @@ func main() {
 		log.Println(".env file not found, using environment variables instead")
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
 	container := di.NewContainer(di.Services)
+	client, ok := container.Get("http_client").(*http.Client)
+	if !ok {
+		panic("http_client factory failed")
+	}
+
 	llm, ok := container.Get("openai").(*openai.OpenAI)
 	if !ok {
 		panic("openai factory failed")
 	}
 
+	costs, ok := container.Get("openai_costs").(*openai.CostTracker)
+	if !ok {
+		panic("openai_costs factory failed")
+	}
+	defer costs.Report(os.Stderr, os.Getenv("OPENAI_COST_REPORT"))
+
 	cache, ok := container.Get("redis").(*redis.Client)
 	if !ok {
 		panic("openai factory failed")
 	}
 
 	url := fmt.Sprintf("%s/dane/arxiv-draft.html", os.Getenv("CENTRALA_BASEURL"))
-	results := scrapePage(url)
+	results := scrapePage(client, url)
+
+	normalized := screenSections(ctx, llm, normalizeData(ctx, client, llm, cache, results))
 
-	normalized := normalizeData(llm, cache, results)
+	facts, err := openai.TruncateTokens("gpt-4-turbo", strings.Join(normalized, "\n\n"), factsTokenBudget)
+	if err != nil {
+		panic(err)
+	}
 
 	response := map[string]string{}
-	for _, question := range fetchQuestions() {
-		answer := answerQuestion(llm, question.Text, strings.Join(normalized, "\n\n"))
+	for _, question := range fetchQuestions(client) {
+		answer := answerQuestion(openai.WithLabel(ctx, "answer"), llm, question.Text, facts)
 		question.Answer = answer
 		index := fmt.Sprintf("%02d", question.Index)
 		response[index] = question.Answer
@@ -76,14 +100,16 @@ func main() {
 	if !ok {
 		panic("responder factory failed")
 	}
-	responder.SendAnswer(response, "arxiv")
+	if err := responder.SendAnswer(ctx, response, "arxiv"); err != nil {
+		panic(err)
+	}
 }
 
-func answerQuestion(llm *openai.OpenAI, question string, context string) string {
+func answerQuestion(ctx context.Context, llm *openai.OpenAI, question string, facts string) string {
 	messages := []openai.Message{
 		{
 			Role:    "system",
-			Content: context,
+			Content: facts,
 		},
 		{
 			Role:    "system",
@@ -95,7 +121,32 @@ func answerQuestion(llm *openai.OpenAI, question string, context string) string
 		},
 	}
 
-	resp := llm.GetCompletionShort(messages, "gpt-4-turbo")
+	request := openai.CompletionRequest{
+		Model:    "gpt-4-turbo",
+		Messages: messages,
+	}
+	stream, err := llm.GetCompletionStream(ctx, request)
+	if err != nil {
+		panic(err)
+	}
+	defer stream.Close()
+
+	fmt.Println(question)
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			panic(err)
+		}
+		for _, choice := range chunk.Choices {
+			fmt.Print(choice.Delta.Content)
+		}
+	}
+	fmt.Println("")
+
+	resp := stream.Response()
 	if len(resp.Choices) == 0 {
 		panic("no choices in response from LLM")
 	}
@@ -103,10 +154,10 @@ func answerQuestion(llm *openai.OpenAI, question string, context string) string
 	return resp.Choices[0].Message.Content
 }
 
-func fetchQuestions() []*Question {
+func fetchQuestions(client *http.Client) []*Question {
 	url := fmt.Sprintf("%s/data/%s/arxiv.txt", os.Getenv("CENTRALA_BASEURL"), os.Getenv("AI_DEVS_KEY"))
 
-	response, err := http.Get(url)
+	response, err := client.Get(url)
 	contents, err := io.ReadAll(response.Body)
 	if err != nil {
 		panic(err)
@@ -137,7 +188,7 @@ func fetchQuestions() []*Question {
 	return questions
 }
 
-func normalizeData(llm *openai.OpenAI, cache *redis.Client, data ScrapeResults) []string {
+func normalizeData(ctx context.Context, client *http.Client, llm *openai.OpenAI, cache *redis.Client, data ScrapeResults) []string {
 	results := []string{}
 
 	for _, section := range data.Sections {
@@ -150,12 +201,12 @@ func normalizeData(llm *openai.OpenAI, cache *redis.Client, data ScrapeResults)
 		}
 
 		for _, audio := range section.Audio {
-			transcript := transcriptAudio(llm, cache, audio)
+			transcript := transcriptAudio(openai.WithLabel(ctx, "transcribe"), client, llm, cache, audio)
 			fragments = append(fragments, transcript)
 		}
 
 		for _, image := range section.Images {
-			description := describeImage(llm, cache, image)
+			description := describeImage(openai.WithLabel(ctx, "describe"), client, llm, cache, image)
 			fragments = append(fragments, description)
 		}
 
@@ -165,48 +216,74 @@ func normalizeData(llm *openai.OpenAI, cache *redis.Client, data ScrapeResults)
 	return results
 }
 
-func describeImage(llm *openai.OpenAI, cache *redis.Client, image Image) string {
-	ctx := context.Background()
+// screenPolicy finds sections that would likely get answers refused. They
+// are only logged, since the answers may depend on them, unless
+// S02E05_DROP_FLAGGED asks to leave them out of the facts.
+var screenPolicy = openai.ModerationPolicy{Default: 0.8, Flagged: true}
 
+func screenSections(ctx context.Context, llm *openai.OpenAI, sections []string) []string {
+	violations, err := llm.Screen(ctx, screenPolicy, sections, openai.ModerationModelOmni)
+	if err != nil {
+		panic(err)
+	}
+
+	drop := false
+	if value := os.Getenv("S02E05_DROP_FLAGGED"); value != "" {
+		drop, err = strconv.ParseBool(value)
+		if err != nil {
+			panic(fmt.Sprintf("invalid S02E05_DROP_FLAGGED: %v", err))
+		}
+	}
+
+	dropped := map[int]bool{}
+	for _, violation := range violations {
+		if !drop {
+			log.Printf("flagged section kept: %s", violation)
+			continue
+		}
+		log.Printf("dropping section: %s", violation)
+		dropped[violation.Input] = true
+	}
+
+	kept := []string{}
+	for i, section := range sections {
+		if !dropped[i] {
+			kept = append(kept, section)
+		}
+	}
+
+	return kept
+}
+
+func describeImage(ctx context.Context, client *http.Client, llm *openai.OpenAI, cache *redis.Client, image Image) string {
 	imageUrl := fmt.Sprintf("%s/dane/%s", os.Getenv("CENTRALA_BASEURL"), image.Url)
 
 	var description string
 	description, err := cache.Get(ctx, imageUrl).Result()
 	if err != nil {
-		imageResponse, err := http.Get(imageUrl)
+		imageResponse, err := client.Get(imageUrl)
 		fileContents, err := io.ReadAll(imageResponse.Body)
 		if err != nil {
 			panic(err)
 		}
 
-		encodedImage := base64.StdEncoding.EncodeToString(fileContents)
-		messages := []openai.ImageMessage{
+		messages := []openai.Message{
 			{
-				Role: "system",
-				Content: []openai.Content{
-					{
-						Type: "text",
-						Text: "I describe what's on the image. I include the city name of where the photo it was taken, if I can.",
-					},
-				},
+				Role:    "system",
+				Content: "I describe what's on the image. I include the city name of where the photo it was taken, if I can.",
 			},
 			{
 				Role: "user",
-				Content: []openai.Content{
-					{
-						Type: "image_url",
-						ImageURL: openai.ImageURL{
-							URL: "data:image/png;base64," + encodedImage,
-						},
-					},
-					{
-						Type: "text",
-						Text: image.Caption,
-					},
+				Parts: []openai.ContentPart{
+					openai.ImageDataPart("image/png", fileContents, openai.ImageDetailAuto),
+					openai.TextPart(image.Caption),
 				},
 			},
 		}
-		completions := llm.GetImageCompletionShort(messages, "gpt-4o")
+		completions, err := llm.GetCompletionShort(ctx, messages, "gpt-4o")
+		if err != nil {
+			panic(err)
+		}
 
 		if len(completions.Choices) == 0 {
 			panic("no completions returned by LLM")
@@ -220,21 +297,22 @@ func describeImage(llm *openai.OpenAI, cache *redis.Client, image Image) string
 	return description
 }
 
-func transcriptAudio(llm *openai.OpenAI, cache *redis.Client, url string) string {
-	ctx := context.Background()
-
+func transcriptAudio(ctx context.Context, client *http.Client, llm *openai.OpenAI, cache *redis.Client, url string) string {
 	audioUrl := fmt.Sprintf("%s/dane/%s", os.Getenv("CENTRALA_BASEURL"), url)
 
 	var transcript string
 	transcript, err := cache.Get(ctx, audioUrl).Result()
 	if err != nil {
-		audioResponse, err := http.Get(audioUrl)
+		audioResponse, err := client.Get(audioUrl)
 		fileContents, err := io.ReadAll(audioResponse.Body)
 		if err != nil {
 			panic(err)
 		}
 
-		transcript = llm.GetTranscription(fileContents, "whisper-1", "mp3")
+		transcript, err = llm.GetTranscription(ctx, fileContents, "whisper-1", "mp3")
+		if err != nil {
+			panic(err)
+		}
 
 		cache.Set(ctx, audioUrl, transcript, time.Hour)
 	}
@@ -242,8 +320,8 @@ func transcriptAudio(llm *openai.OpenAI, cache *redis.Client, url string) string
 	return transcript
 }
 
-func scrapePage(url string) ScrapeResults {
-	resp, err := http.Get(url)
+func scrapePage(client *http.Client, url string) ScrapeResults {
+	resp, err := client.Get(url)
 	if err != nil {
 		log.Fatalf("Failed to fetch URL: %v", err)
 	}
@@ -2,13 +2,14 @@ package main
 
 import (
 	"archive/zip"
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
-	"time"
 
 	"github.com/joho/godotenv"
 	"woyteck.pl/ai_devs3/internal/aidevs"
@@ -16,48 +17,70 @@ import (
 	"woyteck.pl/ai_devs3/internal/openai"
 )
 
+// factsTokenBudget leaves room in gpt-4o's 128k context for the
+// instructions, the report and the reply.
+const factsTokenBudget = 100000
+
 type File struct {
 	Name     string
 	Contents string
 }
 
+type Keywords struct {
+	Keywords []string `json:"keywords"`
+}
+
 func main() {
 	err := godotenv.Load()
 	if err != nil {
 		log.Println(".env file not found, using environment variables instead")
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
 	container := di.NewContainer(di.Services)
+	client, ok := container.Get("http_client").(*http.Client)
+	if !ok {
+		panic("http_client factory failed")
+	}
+
 	llm, ok := container.Get("openai").(*openai.OpenAI)
 	if !ok {
 		panic("openai factory failed")
 	}
 
+	costs, ok := container.Get("openai_costs").(*openai.CostTracker)
+	if !ok {
+		panic("openai_costs factory failed")
+	}
+	defer costs.Report(os.Stderr, os.Getenv("OPENAI_COST_REPORT"))
+
 	url := fmt.Sprintf("%s/dane/pliki_z_fabryki.zip", os.Getenv("CENTRALA_BASEURL"))
-	reports, facts := fetchData(url)
+	reports, facts := fetchData(client, url)
 
-	context := []string{}
+	fragments := []string{}
 	for _, fact := range facts {
-		context = append(context, fact.Contents)
+		fragments = append(fragments, fact.Contents)
 	}
 	for _, report := range reports {
-		context = append(context, report.Contents)
+		fragments = append(fragments, report.Contents)
 	}
 
-	contextString := strings.Join(context, "\n")
+	contextString := strings.Join(fragments, "\n")
 	contextString = strings.ReplaceAll(contextString, "agorski", "agowski")
+	contextString = fitTokens(contextString, "gpt-4o", factsTokenBudget)
 
 	answer := map[string]string{}
 	for _, report := range reports {
 		fmt.Println("REPORT:")
 		fmt.Println(report)
 
-		keywords := generateKeywords(llm, contextString, report.Contents)
+		keywords := generateKeywords(ctx, llm, contextString, report.Contents)
 		keywords = append(keywords, report.Name)
 
 		answer[report.Name] = strings.Join(keywords, ", ")
 		fmt.Println("KEYWORDS: " + strings.Join(keywords, ", "))
-		time.Sleep(time.Second)
 	}
 
 	fmt.Println("")
@@ -67,10 +90,12 @@ func main() {
 	if !ok {
 		panic("responder factory failed")
 	}
-	responder.SendAnswer(answer, "dokumenty")
+	if err := responder.SendAnswer(ctx, answer, "dokumenty"); err != nil {
+		panic(err)
+	}
 }
 
-func generateKeywords(llm *openai.OpenAI, context string, report string) []string {
+func generateKeywords(ctx context.Context, llm *openai.OpenAI, facts string, report string) []string {
 	systemMessage := `<instruction>
 Dla raportu podanego przez użytkownika generuję listę słów kluczowych w formie mianownika (czyli np. "sportowiec", a nie "sportowcem", "sportowców" itp.).
 Analizuję w tym celu treści raportu i faktów, łączę fakty i na podstawie wniosków generuję słowa kluczowe.
@@ -78,11 +103,10 @@ Analizuję w tym celu treści raportu i faktów, łączę fakty i na podstawie w
 
 <rules>
 Zwracam tylko te słowa kluczowe, nic więcej.
-Każde słowo kluczowe w osobnej linii, bez myślników i numerów linii.
 </rules>
 
 <facts>
-` + context + `
+` + facts + `
 </facts>`
 
 	messages := []openai.Message{
@@ -95,13 +119,17 @@ Każde słowo kluczowe w osobnej linii, bez myślników i numerów linii.
 			Content: report,
 		},
 	}
-	completions := llm.GetCompletionShort(messages, "gpt-4o")
-	if len(completions.Choices) == 0 {
-		panic("no completions returned by LLM")
+	request := openai.CompletionRequest{
+		Model:    "gpt-4o",
+		Messages: messages,
+	}
+	completion, err := openai.CompleteInto[Keywords](ctx, llm, request)
+	if err != nil {
+		panic(err)
 	}
 
 	results := []string{}
-	for _, keyword := range strings.Split(completions.Choices[0].Message.Content, "\n") {
+	for _, keyword := range completion.Keywords {
 		word := strings.ToLower(strings.Trim(keyword, " "))
 		if word != "" {
 			results = append(results, word)
@@ -111,8 +139,20 @@ Każde słowo kluczowe w osobnej linii, bez myślników i numerów linii.
 	return results
 }
 
-func fetchData(url string) ([]File, []File) {
-	zipFile := fetchZip(url)
+func fitTokens(text string, model string, budget int) string {
+	trimmed, err := openai.TruncateTokens(model, text, budget)
+	if err != nil {
+		panic(err)
+	}
+	if len(trimmed) < len(text) {
+		log.Printf("facts trimmed to %d tokens", budget)
+	}
+
+	return trimmed
+}
+
+func fetchData(client *http.Client, url string) ([]File, []File) {
+	zipFile := fetchZip(client, url)
 	destination := "/tmp/archive.zip"
 
 	f, err := os.Create(destination)
@@ -169,8 +209,8 @@ func fetchData(url string) ([]File, []File) {
 	return reports, facts
 }
 
-func fetchZip(url string) []byte {
-	response, err := http.Get(url)
+func fetchZip(client *http.Client, url string) []byte {
+	response, err := client.Get(url)
 	if err != nil {
 		panic(err)
 	}
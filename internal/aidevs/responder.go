@@ -2,22 +2,49 @@ package aidevs
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 )
 
+const DefaultTimeout = 30 * time.Second
+
 type Responder struct {
-	url string
-	key string
+	url     string
+	key     string
+	timeout time.Duration
+	client  *http.Client
+}
+
+type Option func(*Responder)
+
+func WithTimeout(timeout time.Duration) Option {
+	return func(r *Responder) {
+		r.timeout = timeout
+	}
+}
+
+func WithHTTPClient(client *http.Client) Option {
+	return func(r *Responder) {
+		r.client = client
+	}
 }
 
-func NewResponder(url string, key string) *Responder {
-	return &Responder{
-		url: url,
-		key: key,
+func NewResponder(url string, key string, opts ...Option) *Responder {
+	r := &Responder{
+		url:     url,
+		key:     key,
+		timeout: DefaultTimeout,
+		client:  http.DefaultClient,
 	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
 }
 
 type Answer struct {
@@ -26,7 +53,7 @@ type Answer struct {
 	Answer any    `json:"answer"`
 }
 
-func (r *Responder) SendAnswer(message any, taskName string) {
+func (r *Responder) SendAnswer(ctx context.Context, message any, taskName string) error {
 	req := Answer{
 		Task:   taskName,
 		ApiKey: r.key,
@@ -35,21 +62,36 @@ func (r *Responder) SendAnswer(message any, taskName string) {
 
 	json, err := json.Marshal(req)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	fmt.Println(bytes.NewBuffer(json))
 
-	response, err := http.Post(r.url, "application/json", bytes.NewBuffer(json))
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "POST", r.url, bytes.NewBuffer(json))
 	if err != nil {
-		panic(err)
+		return err
 	}
+	request.Header.Add("Content-Type", "application/json")
+
+	response, err := r.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
 
 	body, err := io.ReadAll(response.Body)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	fmt.Println(response.StatusCode)
 	fmt.Println(string(body))
+
+	return nil
 }
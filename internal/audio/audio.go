@@ -0,0 +1,166 @@
+// Package audio splits compressed and raw audio files into smaller,
+// independently playable files on frame boundaries, without decoding them.
+package audio
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type Format string
+
+const (
+	FormatUnknown Format = ""
+	FormatMP3     Format = "mp3"
+	FormatWAV     Format = "wav"
+	FormatM4A     Format = "m4a"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported audio format")
+
+// Detect tells the container format from the first bytes of data.
+func Detect(data []byte) Format {
+	switch {
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WAVE":
+		return FormatWAV
+	case len(data) >= 8 && string(data[4:8]) == "ftyp":
+		return FormatM4A
+	case bytes.HasPrefix(data, []byte("ID3")):
+		return FormatMP3
+	case len(data) >= 4:
+		if _, ok := parseMP3Header(data); ok {
+			return FormatMP3
+		}
+	}
+
+	return FormatUnknown
+}
+
+// Chunk is a standalone file covering [Start, End) of the original audio.
+type Chunk struct {
+	Data  []byte
+	Start time.Duration
+	End   time.Duration
+}
+
+type SplitOptions struct {
+	// MaxBytes caps the size of every chunk, headers included.
+	MaxBytes int
+	// MaxDuration caps the length of every chunk; zero means no limit.
+	MaxDuration time.Duration
+	// Overlap is how much audio consecutive chunks share, so words cut at a
+	// boundary are heard whole in one of them.
+	Overlap time.Duration
+}
+
+// Split cuts data into chunks that satisfy opts. Audio that already fits is
+// returned as a single chunk with the original bytes.
+func Split(data []byte, opts SplitOptions) ([]Chunk, Format, error) {
+	format := Detect(data)
+
+	var t *track
+	var err error
+	switch format {
+	case FormatMP3:
+		t, err = mp3Track(data)
+	case FormatWAV:
+		t, err = wavTrack(data)
+	case FormatM4A:
+		t, err = mp4Track(data)
+	default:
+		return nil, format, ErrUnsupportedFormat
+	}
+	if err != nil {
+		return nil, format, fmt.Errorf("can not read %s: %w", format, err)
+	}
+	if len(t.units) == 0 {
+		return nil, format, fmt.Errorf("no audio found in %s", format)
+	}
+
+	total := t.units[len(t.units)-1].end()
+	if fits(len(data), total, opts) {
+		return []Chunk{{Data: data, End: total}}, format, nil
+	}
+
+	chunks, err := t.split(opts)
+
+	return chunks, format, err
+}
+
+func fits(size int, duration time.Duration, opts SplitOptions) bool {
+	return (opts.MaxBytes <= 0 || size <= opts.MaxBytes) &&
+		(opts.MaxDuration <= 0 || duration <= opts.MaxDuration)
+}
+
+// unit is the smallest piece of audio that can be cut out: an mp3 frame, an
+// AAC sample or a short run of PCM blocks. offset is where the unit's bytes
+// start, except for mp4, where it indexes the sample table.
+type unit struct {
+	offset   int
+	size     int
+	start    time.Duration
+	duration time.Duration
+}
+
+func (u unit) end() time.Duration {
+	return u.start + u.duration
+}
+
+// track is the list of units in a file and how to rebuild a file from a
+// range of them. A chunk costs header bytes plus, for every unit, its size
+// and perUnit bytes of index tables.
+type track struct {
+	units   []unit
+	header  int
+	perUnit int
+	build   func(units []unit) ([]byte, error)
+}
+
+func (t *track) split(opts SplitOptions) ([]Chunk, error) {
+	chunks := []Chunk{}
+
+	for from := 0; from < len(t.units); {
+		size := t.header
+		to := from
+		for to < len(t.units) {
+			next := size + t.units[to].size + t.perUnit
+			length := t.units[to].end() - t.units[from].start
+			if to > from && !fits(next, length, opts) {
+				break
+			}
+			size = next
+			to++
+		}
+		if !fits(size, t.units[to-1].end()-t.units[from].start, opts) {
+			return nil, fmt.Errorf("a single frame at %s does not fit in a chunk", t.units[from].start)
+		}
+
+		data, err := t.build(t.units[from:to])
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, Chunk{
+			Data:  data,
+			Start: t.units[from].start,
+			End:   t.units[to-1].end(),
+		})
+		if to == len(t.units) {
+			break
+		}
+
+		// Step back by the overlap, but always move forward.
+		next := to
+		for next > from+1 && t.units[next-1].start >= t.units[to].start-opts.Overlap {
+			next--
+		}
+		from = next
+	}
+
+	return chunks, nil
+}
+
+func (f Format) Extension() string {
+	return string(f)
+}
@@ -0,0 +1,369 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// wavFixture is 16-bit PCM with a counting pattern, so any cut shows up in
+// the samples.
+func wavFixture(seconds float64, rate int, channels int) []byte {
+	blockAlign := 2 * channels
+	samples := make([]byte, int(seconds*float64(rate))*blockAlign)
+	for i := range samples {
+		samples[i] = byte(i)
+	}
+
+	format := []byte("fmt ")
+	format = binary.LittleEndian.AppendUint32(format, 16)
+	format = binary.LittleEndian.AppendUint16(format, 1)
+	format = binary.LittleEndian.AppendUint16(format, uint16(channels))
+	format = binary.LittleEndian.AppendUint32(format, uint32(rate))
+	format = binary.LittleEndian.AppendUint32(format, uint32(rate*blockAlign))
+	format = binary.LittleEndian.AppendUint16(format, uint16(blockAlign))
+	format = binary.LittleEndian.AppendUint16(format, 16)
+
+	out := []byte("RIFF")
+	out = binary.LittleEndian.AppendUint32(out, uint32(4+len(format)+8+len(samples)))
+	out = append(out, "WAVE"...)
+	// A chunk the splitter must skip.
+	out = append(out, "LIST"...)
+	out = binary.LittleEndian.AppendUint32(out, 3)
+	out = append(out, 'a', 'b', 'c', 0)
+	out = append(out, format...)
+	out = append(out, "data"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(samples)))
+
+	return append(out, samples...)
+}
+
+// mp3Fixture is MPEG-1 Layer III at 44.1 kHz behind an ID3v2 tag, one frame
+// per bitrate index. Frame bodies never contain a sync byte.
+func mp3Fixture(bitrates []int) []byte {
+	out := []byte("ID3\x04\x00\x00\x00\x00\x00\x0a")
+	out = append(out, make([]byte, 10)...)
+
+	for i, index := range bitrates {
+		padding := i % 2
+		header := []byte{0xFF, 0xFB, byte(index<<4 | padding<<1), 0xC4}
+		h, ok := parseMP3Header(header)
+		if !ok {
+			panic("invalid mp3 fixture header")
+		}
+		out = append(out, header...)
+		for j := 4; j < h.size; j++ {
+			out = append(out, byte(i+j)%0x7F)
+		}
+	}
+
+	return out
+}
+
+func cbrBitrates(frames int) []int {
+	bitrates := make([]int, frames)
+	for i := range bitrates {
+		bitrates[i] = 9
+	}
+
+	return bitrates
+}
+
+func vbrBitrates(frames int) []int {
+	bitrates := make([]int, frames)
+	for i := range bitrates {
+		bitrates[i] = 1 + i*7%14
+	}
+
+	return bitrates
+}
+
+// m4aFixture lays the samples out in chunks of perChunk samples each, with
+// junk between the chunks, and indexes them with several stsc entries and
+// stco or co64 offsets, which buildMP4 itself never writes.
+func m4aFixture(sizes []int, perChunk []int, co64 bool) []byte {
+	const timescale = 44100
+
+	samples := [][]byte{}
+	for i, size := range sizes {
+		sample := make([]byte, size)
+		for j := range sample {
+			sample[j] = byte(i*31 + j)
+		}
+		samples = append(samples, sample)
+	}
+
+	stsdEntry := makeBox("mp4a", make([]byte, 28))
+	stsd := concat([]byte{0, 0, 0, 0}, u32(1), stsdEntry)
+
+	// Half the samples are 1024 ticks long and the rest 960.
+	half := len(sizes) / 2
+	stts := concat(u32(2), u32(uint32(half)), u32(1024), u32(uint32(len(sizes)-half)), u32(960))
+
+	stsz := concat(u32(0), u32(uint32(len(sizes))))
+	for _, size := range sizes {
+		stsz = append(stsz, u32(uint32(size))...)
+	}
+
+	stsc := []byte{}
+	entries := 0
+	for chunk, count := range perChunk {
+		if chunk > 0 && count == perChunk[chunk-1] {
+			continue
+		}
+		stsc = concat(stsc, u32(uint32(chunk+1)), u32(uint32(count)), u32(1))
+		entries++
+	}
+	stsc = concat(u32(uint32(entries)), stsc)
+
+	moov := func(mdatOffset int) []byte {
+		offsets := []byte{}
+		offset := mdatOffset
+		sample := 0
+		for _, count := range perChunk {
+			offset += 7 // junk
+			if co64 {
+				offsets = binary.BigEndian.AppendUint64(offsets, uint64(offset))
+			} else {
+				offsets = append(offsets, u32(uint32(offset))...)
+			}
+			for range count {
+				offset += sizes[sample]
+				sample++
+			}
+		}
+		chunkOffsets := makeFullBox("stco", 0, 0, u32(uint32(len(perChunk))), offsets)
+		if co64 {
+			chunkOffsets = makeFullBox("co64", 0, 0, u32(uint32(len(perChunk))), offsets)
+		}
+
+		stbl := concat(
+			makeBox("stsd", stsd),
+			makeFullBox("stts", 0, 0, stts),
+			makeFullBox("stsc", 0, 0, stsc),
+			makeFullBox("stsz", 0, 0, stsz),
+			chunkOffsets,
+		)
+		mdia := concat(
+			makeFullBox("mdhd", 0, 0, u32(0), u32(0), u32(timescale), u32(0), u32(0)),
+			makeFullBox("hdlr", 0, 0, u32(0), []byte("soun"), make([]byte, 12), []byte("SoundHandler\x00")),
+			makeBox("minf", makeBox("stbl", stbl)),
+		)
+		// A video track comes first and must be passed over.
+		video := makeBox("trak", makeBox("mdia", makeFullBox("hdlr", 0, 0, u32(0), []byte("vide"), make([]byte, 12))))
+
+		return makeBox("moov", concat(video, makeBox("trak", makeBox("mdia", mdia))))
+	}
+
+	head := makeBox("ftyp", []byte("M4A \x00\x00\x00\x00M4A mp42isom"))
+	head = append(head, moov(len(head)+len(moov(0))+8)...)
+
+	mdat := []byte{}
+	sample := 0
+	for _, count := range perChunk {
+		mdat = append(mdat, "garbage"...)
+		for range count {
+			mdat = append(mdat, samples[sample]...)
+			sample++
+		}
+	}
+
+	return concat(head, makeBox("mdat", mdat))
+}
+
+func m4aSizes(count int) []int {
+	sizes := make([]int, count)
+	for i := range sizes {
+		sizes[i] = 200 + i*37%300
+	}
+
+	return sizes
+}
+
+// m4aSamples reads back the bytes of every sample in an m4a file.
+func m4aSamples(t *testing.T, data []byte) [][]byte {
+	t.Helper()
+
+	moov, err := child(data, "moov")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mdia, err := soundTrack(moov)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stbl, err := child(mdia, "minf", "stbl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples, err := mp4Samples(stbl, len(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := [][]byte{}
+	for _, s := range samples {
+		out = append(out, data[s.offset:s.offset+s.size])
+	}
+
+	return out
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want Format
+	}{
+		{wavFixture(0.1, 8000, 1), FormatWAV},
+		{mp3Fixture(cbrBitrates(2)), FormatMP3},
+		{mp3Fixture(cbrBitrates(2))[20:], FormatMP3},
+		{m4aFixture(m4aSizes(4), []int{4}, false), FormatM4A},
+		{[]byte("plain text"), FormatUnknown},
+		{nil, FormatUnknown},
+	}
+	for i, test := range tests {
+		if got := Detect(test.data); got != test.want {
+			t.Errorf("%d: Detect = %q, want %q", i, got, test.want)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		format Format
+		opts   SplitOptions
+	}{
+		{"wav by size", wavFixture(3, 8000, 1), FormatWAV, SplitOptions{MaxBytes: 10000}},
+		{"wav by duration", wavFixture(3, 22050, 2), FormatWAV, SplitOptions{MaxBytes: 1 << 20, MaxDuration: 700 * time.Millisecond, Overlap: 200 * time.Millisecond}},
+		{"wav odd block", wavFixture(1.5, 11025, 3), FormatWAV, SplitOptions{MaxBytes: 40000, Overlap: 300 * time.Millisecond}},
+		{"cbr mp3", mp3Fixture(cbrBitrates(120)), FormatMP3, SplitOptions{MaxBytes: 20000, Overlap: 250 * time.Millisecond}},
+		{"vbr mp3", mp3Fixture(vbrBitrates(150)), FormatMP3, SplitOptions{MaxBytes: 15000, MaxDuration: time.Second, Overlap: 100 * time.Millisecond}},
+		{"m4a stco", m4aFixture(m4aSizes(200), []int{30, 30, 30, 25, 25, 20, 20, 20}, false), FormatM4A, SplitOptions{MaxBytes: 12000, Overlap: 300 * time.Millisecond}},
+		{"m4a co64", m4aFixture(m4aSizes(120), []int{50, 40, 30}, true), FormatM4A, SplitOptions{MaxBytes: 1 << 20, MaxDuration: 500 * time.Millisecond, Overlap: 100 * time.Millisecond}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			whole, format, err := Split(test.data, SplitOptions{})
+			if err != nil || format != test.format || len(whole) != 1 {
+				t.Fatalf("unlimited Split = %d chunks, %q, %v", len(whole), format, err)
+			}
+			if !bytes.Equal(whole[0].Data, test.data) {
+				t.Error("audio that fits was not returned as is")
+			}
+			total := whole[0].End
+
+			chunks, format, err := Split(test.data, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != test.format || len(chunks) < 2 {
+				t.Fatalf("got %d %s chunks, want several %s chunks", len(chunks), format, test.format)
+			}
+			if chunks[0].Start != 0 || chunks[len(chunks)-1].End != total {
+				t.Errorf("chunks cover %s to %s, want 0s to %s", chunks[0].Start, chunks[len(chunks)-1].End, total)
+			}
+
+			for i, chunk := range chunks {
+				if len(chunk.Data) > test.opts.MaxBytes {
+					t.Errorf("chunk %d has %d bytes, over %d", i, len(chunk.Data), test.opts.MaxBytes)
+				}
+				if test.opts.MaxDuration > 0 && chunk.End-chunk.Start > test.opts.MaxDuration {
+					t.Errorf("chunk %d lasts %s, over %s", i, chunk.End-chunk.Start, test.opts.MaxDuration)
+				}
+
+				reparsed, format, err := Split(chunk.Data, SplitOptions{})
+				if err != nil || format != test.format || len(reparsed) != 1 {
+					t.Fatalf("chunk %d does not parse again: %d chunks, %q, %v", i, len(reparsed), format, err)
+				}
+				if diff := reparsed[0].End - (chunk.End - chunk.Start); diff < -time.Millisecond || diff > time.Millisecond {
+					t.Errorf("chunk %d reparsed lasts %s, want %s", i, reparsed[0].End, chunk.End-chunk.Start)
+				}
+
+				if i == 0 {
+					continue
+				}
+				// The overlap steps back whole units, of 100ms at most.
+				overlap := chunks[i-1].End - chunk.Start
+				if overlap > test.opts.Overlap || overlap <= test.opts.Overlap-100*time.Millisecond || chunk.Start <= chunks[i-1].Start {
+					t.Errorf("chunks %d and %d overlap by %s, want up to %s", i-1, i, overlap, test.opts.Overlap)
+				}
+			}
+
+			assertSamples(t, test.format, test.data, chunks)
+		})
+	}
+}
+
+// assertSamples checks the chunks hold the original audio bytes.
+func assertSamples(t *testing.T, format Format, data []byte, chunks []Chunk) {
+	t.Helper()
+
+	switch format {
+	case FormatMP3:
+		for i, chunk := range chunks {
+			if !bytes.Contains(data, chunk.Data) {
+				t.Errorf("chunk %d is not a run of the original frames", i)
+			}
+		}
+	case FormatWAV:
+		for i, chunk := range chunks {
+			if !bytes.Contains(data, chunk.Data[44:]) {
+				t.Errorf("chunk %d holds samples that are not in the original", i)
+			}
+		}
+	case FormatM4A:
+		original := m4aSamples(t, data)
+		next := 0
+		for i, chunk := range chunks {
+			samples := m4aSamples(t, chunk.Data)
+			first := -1
+			for j := range original {
+				if bytes.Equal(original[j], samples[0]) {
+					first = j
+					break
+				}
+			}
+			if first < 0 || first > next || first+len(samples) > len(original) {
+				t.Fatalf("chunk %d starts with a sample that is not where expected", i)
+			}
+			for j, sample := range samples {
+				if !bytes.Equal(sample, original[first+j]) {
+					t.Errorf("chunk %d sample %d differs from original sample %d", i, j, first+j)
+				}
+			}
+			next = first + len(samples)
+		}
+		if next != len(original) {
+			t.Errorf("chunks end at sample %d of %d", next, len(original))
+		}
+	}
+}
+
+func TestSplitFrameTooLarge(t *testing.T) {
+	_, _, err := Split(mp3Fixture(cbrBitrates(10)), SplitOptions{MaxBytes: 100})
+	if err == nil {
+		t.Fatal("chunks smaller than a frame were accepted")
+	}
+}
+
+func FuzzSplit(f *testing.F) {
+	f.Add(wavFixture(0.5, 8000, 1))
+	f.Add(mp3Fixture(vbrBitrates(12)))
+	f.Add(m4aFixture(m4aSizes(12), []int{5, 5, 2}, false))
+	f.Add(m4aFixture(m4aSizes(12), []int{6, 6}, true))
+	f.Add([]byte("RIFF\x00\x00\x00\x00WAVEdata\xff\xff\xff\xff"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		chunks, _, err := Split(data, SplitOptions{MaxBytes: 2000, MaxDuration: 200 * time.Millisecond, Overlap: 50 * time.Millisecond})
+		if err != nil {
+			return
+		}
+		for _, chunk := range chunks {
+			Split(chunk.Data, SplitOptions{})
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package audio
+
+import (
+	"errors"
+	"time"
+)
+
+// Bitrates in kbps by [MPEG-1, MPEG-2/2.5][layer I, II, III][index].
+var mp3Bitrates = [2][3][15]int{
+	{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	},
+	{
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	},
+}
+
+// Sample rates by version bits (2.5, reserved, 2, 1) and index.
+var mp3SampleRates = [4][3]int{
+	{11025, 12000, 8000},
+	{},
+	{22050, 24000, 16000},
+	{44100, 48000, 32000},
+}
+
+type mp3Header struct {
+	size    int
+	samples int
+	rate    int
+}
+
+// parseMP3Header reads the 4-byte MPEG audio frame header at the start of
+// data. Free-format bitrates are not supported.
+func parseMP3Header(data []byte) (mp3Header, bool) {
+	if len(data) < 4 || data[0] != 0xFF || data[1]&0xE0 != 0xE0 {
+		return mp3Header{}, false
+	}
+
+	version := int(data[1]>>3) & 0x03
+	layer := int(data[1]>>1) & 0x03
+	bitrateIndex := int(data[2] >> 4)
+	rateIndex := int(data[2]>>2) & 0x03
+	padding := int(data[2]>>1) & 0x01
+	if version == 1 || layer == 0 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return mp3Header{}, false
+	}
+
+	v := 1
+	if version == 3 {
+		v = 0
+	}
+	l := 3 - layer // layer bits 3, 2, 1 are layers I, II, III
+	bitrate := mp3Bitrates[v][l][bitrateIndex] * 1000
+	rate := mp3SampleRates[version][rateIndex]
+
+	h := mp3Header{rate: rate}
+	switch {
+	case l == 0:
+		h.samples = 384
+		h.size = (12*bitrate/rate + padding) * 4
+	case l == 2 && v == 1:
+		h.samples = 576
+		h.size = 72*bitrate/rate + padding
+	default:
+		h.samples = 1152
+		h.size = 144*bitrate/rate + padding
+	}
+
+	return h, true
+}
+
+// id3v2Size returns the length of the ID3v2 tag at the start of data.
+func id3v2Size(data []byte) int {
+	if len(data) < 10 || string(data[:3]) != "ID3" {
+		return 0
+	}
+
+	size := int(data[6]&0x7F)<<21 | int(data[7]&0x7F)<<14 | int(data[8]&0x7F)<<7 | int(data[9]&0x7F)
+	size += 10
+	if data[5]&0x10 != 0 {
+		size += 10
+	}
+
+	return size
+}
+
+// mp3Track lists the frames of an MPEG audio stream. Tags and garbage
+// between frames are skipped, so chunks are plain concatenated frames.
+func mp3Track(data []byte) (*track, error) {
+	t := &track{
+		build: func(units []unit) ([]byte, error) {
+			last := units[len(units)-1]
+			out := make([]byte, 0, last.offset+last.size-units[0].offset)
+			for _, u := range units {
+				out = append(out, data[u.offset:u.offset+u.size]...)
+			}
+			return out, nil
+		},
+	}
+
+	var start time.Duration
+	synced := false
+	for pos := id3v2Size(data); pos+4 <= len(data); {
+		h, ok := parseMP3Header(data[pos:])
+		if ok && pos+h.size > len(data) {
+			break
+		}
+		// After garbage, only trust a header that is followed by another.
+		if ok && !synced && pos+h.size+4 <= len(data) {
+			_, ok = parseMP3Header(data[pos+h.size:])
+		}
+		if !ok {
+			synced = false
+			pos++
+			continue
+		}
+
+		duration := time.Duration(h.samples) * time.Second / time.Duration(h.rate)
+		t.units = append(t.units, unit{offset: pos, size: h.size, start: start, duration: duration})
+		start += duration
+		synced = true
+		pos += h.size
+	}
+
+	if len(t.units) == 0 {
+		return nil, errors.New("no mpeg audio frames")
+	}
+
+	return t, nil
+}
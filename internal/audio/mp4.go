@@ -0,0 +1,491 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// box is an ISO BMFF box: its type and payload, without the header.
+type box struct {
+	kind    string
+	payload []byte
+}
+
+func readBoxes(data []byte) ([]box, error) {
+	boxes := []box{}
+	for pos := 0; pos+8 <= len(data); {
+		size := int(binary.BigEndian.Uint32(data[pos:]))
+		kind := string(data[pos+4 : pos+8])
+		header := 8
+		switch size {
+		case 0:
+			size = len(data) - pos
+		case 1:
+			if pos+16 > len(data) {
+				return nil, fmt.Errorf("truncated %s box", kind)
+			}
+			large := binary.BigEndian.Uint64(data[pos+8:])
+			if large > uint64(len(data)-pos) {
+				return nil, fmt.Errorf("truncated %s box", kind)
+			}
+			size, header = int(large), 16
+		}
+		if size < header || pos+size > len(data) {
+			return nil, fmt.Errorf("truncated %s box", kind)
+		}
+
+		boxes = append(boxes, box{kind: kind, payload: data[pos+header : pos+size]})
+		pos += size
+	}
+
+	return boxes, nil
+}
+
+// child finds the box at path below data, e.g. child(moov, "trak", "mdia").
+func child(data []byte, path ...string) ([]byte, error) {
+	for _, kind := range path {
+		boxes, err := readBoxes(data)
+		if err != nil {
+			return nil, err
+		}
+
+		found := false
+		for _, b := range boxes {
+			if b.kind == kind {
+				data, found = b.payload, true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("missing %s box", kind)
+		}
+	}
+
+	return data, nil
+}
+
+// fullBox checks the payload of a versioned box is long enough and returns
+// its version and the rest of it.
+func fullBox(payload []byte, kind string, minLength int) (byte, []byte, error) {
+	if len(payload) < 4+minLength {
+		return 0, nil, fmt.Errorf("truncated %s box", kind)
+	}
+
+	return payload[0], payload[4:], nil
+}
+
+type mp4Sample struct {
+	offset   int
+	size     int
+	duration uint32
+}
+
+// mp4Track reads the sample tables of the first sound track. Chunks are
+// rebuilt as minimal files with one track, the original sample description
+// and the samples in a single mdat.
+func mp4Track(data []byte) (*track, error) {
+	top, err := readBoxes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var ftyp, moov []byte
+	for _, b := range top {
+		switch b.kind {
+		case "ftyp":
+			ftyp = b.payload
+		case "moov":
+			moov = b.payload
+		case "moof":
+			return nil, errors.New("fragmented mp4 is not supported")
+		}
+	}
+	if moov == nil {
+		return nil, errors.New("missing moov box")
+	}
+
+	mdia, err := soundTrack(moov)
+	if err != nil {
+		return nil, err
+	}
+
+	mdhd, err := child(mdia, "mdhd")
+	if err != nil {
+		return nil, err
+	}
+	timescale, err := mdhdTimescale(mdhd)
+	if err != nil {
+		return nil, err
+	}
+
+	stbl, err := child(mdia, "minf", "stbl")
+	if err != nil {
+		return nil, err
+	}
+	stsd, err := child(stbl, "stsd")
+	if err != nil {
+		return nil, err
+	}
+	samples, err := mp4Samples(stbl, len(data))
+	if err != nil {
+		return nil, err
+	}
+
+	t := &track{
+		// ftyp, the fixed boxes of the minimal moov and the sample
+		// description; every sample adds its size plus stsz and stts entries.
+		header:  8 + len(ftyp) + 512 + len(stsd),
+		perUnit: 12,
+		build: func(units []unit) ([]byte, error) {
+			return buildMP4(data, ftyp, stsd, timescale, samples, units)
+		},
+	}
+
+	var ticks uint64
+	for i, s := range samples {
+		t.units = append(t.units, unit{
+			offset:   i,
+			size:     s.size,
+			start:    ticksToDuration(ticks, timescale),
+			duration: ticksToDuration(ticks+uint64(s.duration), timescale) - ticksToDuration(ticks, timescale),
+		})
+		ticks += uint64(s.duration)
+	}
+
+	return t, nil
+}
+
+func ticksToDuration(ticks uint64, timescale uint32) time.Duration {
+	return time.Duration(ticks * uint64(time.Second) / uint64(timescale))
+}
+
+func soundTrack(moov []byte) ([]byte, error) {
+	boxes, err := readBoxes(moov)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, b := range boxes {
+		if b.kind != "trak" {
+			continue
+		}
+		mdia, err := child(b.payload, "mdia")
+		if err != nil {
+			return nil, err
+		}
+		hdlr, err := child(mdia, "hdlr")
+		if err != nil {
+			return nil, err
+		}
+		if len(hdlr) >= 12 && string(hdlr[8:12]) == "soun" {
+			return mdia, nil
+		}
+	}
+
+	return nil, errors.New("no sound track")
+}
+
+func mdhdTimescale(mdhd []byte) (uint32, error) {
+	version, body, err := fullBox(mdhd, "mdhd", 20)
+	if err != nil {
+		return 0, err
+	}
+
+	offset := 8
+	if version == 1 {
+		offset = 16
+	}
+	if len(body) < offset+4 {
+		return 0, errors.New("truncated mdhd box")
+	}
+	timescale := binary.BigEndian.Uint32(body[offset:])
+	if timescale == 0 {
+		return 0, errors.New("zero timescale")
+	}
+
+	return timescale, nil
+}
+
+// mp4Samples resolves the file offset, size and duration of every sample
+// from the stsz, stts, stsc and stco or co64 tables.
+func mp4Samples(stbl []byte, fileSize int) ([]mp4Sample, error) {
+	sizes, err := readStsz(stbl, fileSize)
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]mp4Sample, len(sizes))
+	for i, size := range sizes {
+		samples[i].size = size
+	}
+
+	stts, err := child(stbl, "stts")
+	if err != nil {
+		return nil, err
+	}
+	_, body, err := fullBox(stts, "stts", 4)
+	if err != nil {
+		return nil, err
+	}
+	entries := int(binary.BigEndian.Uint32(body))
+	if len(body) < 4+entries*8 {
+		return nil, errors.New("truncated stts box")
+	}
+	i := 0
+	for e := 0; e < entries; e++ {
+		count := int(binary.BigEndian.Uint32(body[4+e*8:]))
+		delta := binary.BigEndian.Uint32(body[8+e*8:])
+		for ; count > 0 && i < len(samples); count-- {
+			samples[i].duration = delta
+			i++
+		}
+	}
+
+	offsets, err := readChunkOffsets(stbl, fileSize)
+	if err != nil {
+		return nil, err
+	}
+
+	stsc, err := child(stbl, "stsc")
+	if err != nil {
+		return nil, err
+	}
+	_, body, err = fullBox(stsc, "stsc", 4)
+	if err != nil {
+		return nil, err
+	}
+	entries = int(binary.BigEndian.Uint32(body))
+	if len(body) < 4+entries*12 {
+		return nil, errors.New("truncated stsc box")
+	}
+
+	i = 0
+	for e := 0; e < entries; e++ {
+		first := int(binary.BigEndian.Uint32(body[4+e*12:]))
+		perChunk := int(binary.BigEndian.Uint32(body[8+e*12:]))
+		last := len(offsets)
+		if e+1 < entries {
+			last = int(binary.BigEndian.Uint32(body[4+(e+1)*12:])) - 1
+		}
+		if first < 1 {
+			return nil, errors.New("invalid stsc box")
+		}
+
+		for c := first; c <= last && c <= len(offsets); c++ {
+			offset := offsets[c-1]
+			for s := 0; s < perChunk && i < len(samples); s++ {
+				if offset+samples[i].size > fileSize {
+					return nil, fmt.Errorf("sample %d is past the end of the file", i)
+				}
+				samples[i].offset = offset
+				offset += samples[i].size
+				i++
+			}
+		}
+	}
+	if i < len(samples) {
+		return nil, fmt.Errorf("only %d of %d samples are in chunks", i, len(samples))
+	}
+
+	return samples, nil
+}
+
+// readStsz reads the sample sizes. A fixed size stores no table, so the
+// count is checked against what the file can hold before allocating.
+func readStsz(stbl []byte, fileSize int) ([]int, error) {
+	stsz, err := child(stbl, "stsz")
+	if err != nil {
+		return nil, err
+	}
+	_, body, err := fullBox(stsz, "stsz", 8)
+	if err != nil {
+		return nil, err
+	}
+
+	fixed := int(binary.BigEndian.Uint32(body))
+	count := int(binary.BigEndian.Uint32(body[4:]))
+	if fixed == 0 && len(body) < 8+count*4 {
+		return nil, errors.New("truncated stsz box")
+	}
+	if fixed != 0 && count > fileSize/fixed {
+		return nil, fmt.Errorf("%d samples of %d bytes do not fit in the file", count, fixed)
+	}
+
+	sizes := make([]int, count)
+	for i := range sizes {
+		if fixed != 0 {
+			sizes[i] = fixed
+			continue
+		}
+		sizes[i] = int(binary.BigEndian.Uint32(body[8+i*4:]))
+	}
+
+	return sizes, nil
+}
+
+// readChunkOffsets reads the chunk offsets, rejecting any past the end of
+// the file.
+func readChunkOffsets(stbl []byte, fileSize int) ([]int, error) {
+	if stco, err := child(stbl, "stco"); err == nil {
+		_, body, err := fullBox(stco, "stco", 4)
+		if err != nil {
+			return nil, err
+		}
+		count := int(binary.BigEndian.Uint32(body))
+		if len(body) < 4+count*4 {
+			return nil, errors.New("truncated stco box")
+		}
+		offsets := make([]int, count)
+		for i := range offsets {
+			offset := binary.BigEndian.Uint32(body[4+i*4:])
+			if uint64(offset) > uint64(fileSize) {
+				return nil, fmt.Errorf("chunk %d is past the end of the file", i)
+			}
+			offsets[i] = int(offset)
+		}
+		return offsets, nil
+	}
+
+	co64, err := child(stbl, "co64")
+	if err != nil {
+		return nil, errors.New("missing stco and co64 boxes")
+	}
+	_, body, err := fullBox(co64, "co64", 4)
+	if err != nil {
+		return nil, err
+	}
+	count := int(binary.BigEndian.Uint32(body))
+	if len(body) < 4+count*8 {
+		return nil, errors.New("truncated co64 box")
+	}
+	offsets := make([]int, count)
+	for i := range offsets {
+		// Checked before the conversion, which would turn large values
+		// negative.
+		offset := binary.BigEndian.Uint64(body[4+i*8:])
+		if offset > uint64(fileSize) {
+			return nil, fmt.Errorf("chunk %d is past the end of the file", i)
+		}
+		offsets[i] = int(offset)
+	}
+
+	return offsets, nil
+}
+
+// buildMP4 writes a minimal file holding the samples behind units: ftyp,
+// moov with a single sound track whose samples all sit in one chunk, and
+// mdat.
+func buildMP4(data []byte, ftyp []byte, stsd []byte, timescale uint32, samples []mp4Sample, units []unit) ([]byte, error) {
+	picked := samples[units[0].offset : units[len(units)-1].offset+1]
+
+	var duration uint64
+	stts := []byte{}
+	entries := 0
+	for i, s := range picked {
+		duration += uint64(s.duration)
+		if i > 0 && s.duration == picked[i-1].duration {
+			count := binary.BigEndian.Uint32(stts[len(stts)-8:])
+			binary.BigEndian.PutUint32(stts[len(stts)-8:], count+1)
+			continue
+		}
+		stts = binary.BigEndian.AppendUint32(stts, 1)
+		stts = binary.BigEndian.AppendUint32(stts, s.duration)
+		entries++
+	}
+	if duration > 0xFFFFFFFF {
+		return nil, errors.New("chunk too long for a 32-bit duration")
+	}
+
+	stsz := make([]byte, 0, 8+len(picked)*4)
+	stsz = binary.BigEndian.AppendUint32(stsz, 0)
+	stsz = binary.BigEndian.AppendUint32(stsz, uint32(len(picked)))
+	mdatSize := 0
+	for _, s := range picked {
+		stsz = binary.BigEndian.AppendUint32(stsz, uint32(s.size))
+		mdatSize += s.size
+	}
+
+	if ftyp == nil {
+		ftyp = append([]byte("M4A "), 0, 0, 0, 0)
+		ftyp = append(ftyp, "M4A mp42isom"...)
+	}
+
+	moov := func(mdatOffset int) []byte {
+		stbl := concat(
+			makeBox("stsd", stsd),
+			makeFullBox("stts", 0, 0, u32(uint32(entries)), stts),
+			makeFullBox("stsc", 0, 0, u32(1), u32(1), u32(uint32(len(picked))), u32(1)),
+			makeFullBox("stsz", 0, 0, stsz),
+			makeFullBox("stco", 0, 0, u32(1), u32(uint32(mdatOffset))),
+		)
+		minf := concat(
+			makeFullBox("smhd", 0, 0, make([]byte, 4)),
+			makeBox("dinf", makeFullBox("dref", 0, 0, u32(1), makeFullBox("url ", 0, 1))),
+			makeBox("stbl", stbl),
+		)
+		mdia := concat(
+			makeFullBox("mdhd", 0, 0, u32(0), u32(0), u32(timescale), u32(uint32(duration)), []byte{0x55, 0xC4, 0, 0}),
+			makeFullBox("hdlr", 0, 0, u32(0), []byte("soun"), make([]byte, 12), []byte("SoundHandler\x00")),
+			makeBox("minf", minf),
+		)
+		tkhd := makeFullBox("tkhd", 0, 3,
+			u32(0), u32(0), u32(1), u32(0), u32(uint32(duration)),
+			make([]byte, 8), []byte{0, 0, 0, 0, 0x01, 0x00, 0, 0}, unityMatrix(), u32(0), u32(0))
+		mvhd := makeFullBox("mvhd", 0, 0,
+			u32(0), u32(0), u32(timescale), u32(uint32(duration)),
+			u32(0x00010000), []byte{0x01, 0x00}, make([]byte, 10), unityMatrix(), make([]byte, 24), u32(2))
+
+		return makeBox("moov", concat(mvhd, makeBox("trak", concat(tkhd, makeBox("mdia", mdia)))))
+	}
+
+	head := makeBox("ftyp", ftyp)
+	// The moov size doesn't depend on the offset, so measure it first.
+	offset := len(head) + len(moov(0)) + 8
+	head = append(head, moov(offset)...)
+
+	out := make([]byte, 0, len(head)+8+mdatSize)
+	out = append(out, head...)
+	out = binary.BigEndian.AppendUint32(out, uint32(8+mdatSize))
+	out = append(out, "mdat"...)
+	for _, s := range picked {
+		out = append(out, data[s.offset:s.offset+s.size]...)
+	}
+
+	return out, nil
+}
+
+func makeBox(kind string, payload []byte) []byte {
+	out := make([]byte, 0, 8+len(payload))
+	out = binary.BigEndian.AppendUint32(out, uint32(8+len(payload)))
+	out = append(out, kind...)
+
+	return append(out, payload...)
+}
+
+func makeFullBox(kind string, version byte, flags uint32, fields ...[]byte) []byte {
+	payload := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+
+	return makeBox(kind, append(payload, concat(fields...)...))
+}
+
+func concat(parts ...[]byte) []byte {
+	out := []byte{}
+	for _, part := range parts {
+		out = append(out, part...)
+	}
+
+	return out
+}
+
+func u32(value uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, value)
+}
+
+func unityMatrix() []byte {
+	return concat(
+		u32(0x00010000), u32(0), u32(0),
+		u32(0), u32(0x00010000), u32(0),
+		u32(0), u32(0), u32(0x40000000),
+	)
+}
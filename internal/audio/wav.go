@@ -0,0 +1,89 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// wavUnit is how much PCM audio one unit holds. Cutting finer than this
+// only makes the unit list longer.
+const wavUnit = 100 * time.Millisecond
+
+// wavTrack reads the fmt and data chunks of a RIFF WAVE file. Chunks are
+// rebuilt as canonical files: RIFF header, the original fmt chunk and data.
+func wavTrack(data []byte) (*track, error) {
+	var format []byte
+	dataStart, dataSize := -1, 0
+
+	for pos := 12; pos+8 <= len(data); {
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		body := pos + 8
+		if size > len(data)-body {
+			// Streaming writers leave the data size unset.
+			size = len(data) - body
+		}
+
+		switch id {
+		case "fmt ":
+			format = data[pos : body+size]
+		case "data":
+			dataStart, dataSize = body, size
+		}
+		pos = body + size + size%2
+	}
+
+	if len(format) < 8+16 {
+		return nil, errors.New("missing fmt chunk")
+	}
+	if dataStart < 0 {
+		return nil, errors.New("missing data chunk")
+	}
+
+	byteRate := int(binary.LittleEndian.Uint32(format[8+8 : 8+12]))
+	blockAlign := int(binary.LittleEndian.Uint16(format[8+12 : 8+14]))
+	if byteRate <= 0 || blockAlign <= 0 {
+		return nil, errors.New("invalid fmt chunk")
+	}
+
+	// Keep the fmt chunk padded to an even length, as RIFF requires.
+	if len(format)%2 == 1 {
+		format = append(format[:len(format):len(format)], 0)
+	}
+
+	t := &track{
+		header: 12 + len(format) + 8,
+		build: func(units []unit) ([]byte, error) {
+			last := units[len(units)-1]
+			samples := data[units[0].offset : last.offset+last.size]
+
+			out := make([]byte, 0, 12+len(format)+8+len(samples)+1)
+			out = append(out, "RIFF"...)
+			out = binary.LittleEndian.AppendUint32(out, uint32(4+len(format)+8+len(samples)+len(samples)%2))
+			out = append(out, "WAVE"...)
+			out = append(out, format...)
+			out = append(out, "data"...)
+			out = binary.LittleEndian.AppendUint32(out, uint32(len(samples)))
+			out = append(out, samples...)
+			if len(samples)%2 == 1 {
+				out = append(out, 0)
+			}
+			return out, nil
+		},
+	}
+
+	step := max(blockAlign, int(int64(byteRate)*int64(wavUnit)/int64(time.Second))/blockAlign*blockAlign)
+	dataSize -= dataSize % blockAlign
+	for offset := 0; offset < dataSize; offset += step {
+		size := min(step, dataSize-offset)
+		t.units = append(t.units, unit{
+			offset:   dataStart + offset,
+			size:     size,
+			start:    time.Duration(int64(offset) * int64(time.Second) / int64(byteRate)),
+			duration: time.Duration(int64(size) * int64(time.Second) / int64(byteRate)),
+		})
+	}
+
+	return t, nil
+}
@@ -0,0 +1,444 @@
+// Package cassette records HTTP interactions to a JSON fixture and replays
+// them, so code that talks to remote APIs can run without a network.
+package cassette
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+type Mode string
+
+const (
+	// ModeRecord sends requests and saves every interaction, replacing what
+	// the cassette held before.
+	ModeRecord Mode = "record"
+	// ModeReplay serves recorded responses and never touches the network.
+	ModeReplay Mode = "replay"
+)
+
+// Redacted replaces secrets in recorded interactions.
+const Redacted = "REDACTED"
+
+var (
+	DefaultRedactedHeaders = []string{"Authorization", "Api-Key", "X-Api-Key", "Openai-Organization", "Openai-Project", "Cookie", "Set-Cookie"}
+	DefaultRedactedFields  = []string{"apikey", "api_key"}
+)
+
+var ErrNoInteraction = errors.New("cassette: no recorded interaction")
+
+// ParseMode reads a mode name. An empty value means replay, which never
+// reaches the network.
+func ParseMode(value string) (Mode, error) {
+	switch mode := Mode(strings.ToLower(strings.TrimSpace(value))); mode {
+	case ModeRecord, ModeReplay:
+		return mode, nil
+	case "":
+		return ModeReplay, nil
+	}
+
+	return "", fmt.Errorf("unknown cassette mode %q", value)
+}
+
+// Request is stored redacted and with a normalized body, which is what
+// requests are matched on.
+type Request struct {
+	Method   string      `json:"method"`
+	URL      string      `json:"url"`
+	Header   http.Header `json:"header,omitempty"`
+	Body     string      `json:"body,omitempty"`
+	Encoding string      `json:"encoding,omitempty"`
+}
+
+// Response bodies that aren't valid UTF-8 are stored in base64, as told by
+// Encoding.
+type Response struct {
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header,omitempty"`
+	Body     string      `json:"body,omitempty"`
+	Encoding string      `json:"encoding,omitempty"`
+}
+
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+type Options struct {
+	// Secrets are replaced by Redacted wherever they appear: URLs, headers
+	// and bodies. Use it for keys that end up in URLs or payloads.
+	Secrets []string
+	// Headers are redacted in addition to DefaultRedactedHeaders.
+	Headers []string
+	// Fields are JSON and form fields redacted in addition to
+	// DefaultRedactedFields.
+	Fields []string
+}
+
+// Transport is an http.RoundTripper that records or replays interactions.
+// Identical requests are replayed in the order they were recorded; once
+// they run out, the last one is repeated. It is safe for concurrent use.
+type Transport struct {
+	Base http.RoundTripper
+
+	mode    Mode
+	path    string
+	secrets []string
+	headers []string
+	fields  []string
+
+	mu       sync.Mutex
+	cassette Cassette
+	used     []bool
+}
+
+// New opens the cassette at path. In replay mode it must exist.
+func New(path string, mode Mode, opts Options) (*Transport, error) {
+	t := &Transport{
+		mode:    mode,
+		path:    path,
+		headers: append(slices.Clone(DefaultRedactedHeaders), opts.Headers...),
+		fields:  append(slices.Clone(DefaultRedactedFields), opts.Fields...),
+	}
+	for _, secret := range opts.Secrets {
+		if secret != "" {
+			t.secrets = append(t.secrets, secret)
+		}
+	}
+
+	switch mode {
+	case ModeRecord:
+	case ModeReplay:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("can not read cassette: %w", err)
+		}
+		if err := json.Unmarshal(data, &t.cassette); err != nil {
+			return nil, fmt.Errorf("can not unmarshal cassette %s: %w", path, err)
+		}
+		t.used = make([]bool, len(t.cassette.Interactions))
+	default:
+		return nil, fmt.Errorf("unknown cassette mode %q", mode)
+	}
+
+	return t, nil
+}
+
+// Client returns a client that records or replays through t.
+func (t *Transport) Client() *http.Client {
+	return &http.Client{Transport: t}
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base == nil {
+		return http.DefaultTransport
+	}
+
+	return t.Base
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	forward, body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	recorded := Request{
+		Method: req.Method,
+		URL:    t.redact(req.URL.String()),
+		Header: t.redactHeader(req.Header),
+	}
+	recorded.Body, recorded.Encoding = encodeBody(t.normalize(req.Header.Get("Content-Type"), body))
+
+	if t.mode == ModeReplay {
+		if forward.Body != nil {
+			forward.Body.Close()
+		}
+		return t.replay(req, recorded)
+	}
+
+	return t.record(forward, recorded)
+}
+
+// readBody returns the body of req without touching req, which a
+// RoundTripper must not modify: from GetBody when there is one, otherwise
+// read into a copy of the request that is sent in its place.
+func readBody(req *http.Request) (*http.Request, []byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, []byte{}, nil
+	}
+
+	if req.GetBody != nil {
+		reader, err := req.GetBody()
+		if err != nil {
+			req.Body.Close()
+			return nil, nil, err
+		}
+		defer reader.Close()
+
+		body, err := io.ReadAll(reader)
+		if err != nil {
+			req.Body.Close()
+			return nil, nil, err
+		}
+		return req, body, nil
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	forward := req.Clone(req.Context())
+	forward.Body = io.NopCloser(bytes.NewReader(body))
+	forward.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	return forward, body, nil
+}
+
+func (t *Transport) replay(req *http.Request, recorded Request) (*http.Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	found := -1
+	for i, interaction := range t.cassette.Interactions {
+		if !matches(interaction.Request, recorded) {
+			continue
+		}
+		found = i
+		if !t.used[i] {
+			break
+		}
+	}
+	if found < 0 {
+		return nil, fmt.Errorf("%w for %s %s", ErrNoInteraction, recorded.Method, recorded.URL)
+	}
+	t.used[found] = true
+
+	response := t.cassette.Interactions[found].Response
+	body, err := decodeBody(response.Body, response.Encoding)
+	if err != nil {
+		return nil, fmt.Errorf("can not decode cassette body: %w", err)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", response.Status, http.StatusText(response.Status)),
+		StatusCode:    response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        response.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+func (t *Transport) record(req *http.Request, recorded Request) (*http.Response, error) {
+	response, err := t.base().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	response.Body = io.NopCloser(bytes.NewReader(body))
+
+	interaction := Interaction{
+		Request: recorded,
+		Response: Response{
+			Status: response.StatusCode,
+			Header: t.redactHeader(response.Header),
+		},
+	}
+	interaction.Response.Body, interaction.Response.Encoding = encodeBody([]byte(t.redact(string(body))))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.cassette.Interactions = append(t.cassette.Interactions, interaction)
+	if err := t.save(); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// save writes the whole cassette after every interaction, so nothing is lost
+// when the program exits early. It must be called with t.mu held.
+func (t *Transport) save() error {
+	data := &bytes.Buffer{}
+	encoder := json.NewEncoder(data)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(t.cassette); err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(t.path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+
+	return os.WriteFile(t.path, data.Bytes(), 0o644)
+}
+
+func matches(recorded Request, request Request) bool {
+	return recorded.Method == request.Method &&
+		recorded.URL == request.URL &&
+		recorded.Body == request.Body &&
+		recorded.Encoding == request.Encoding
+}
+
+func (t *Transport) redact(value string) string {
+	for _, secret := range t.secrets {
+		value = strings.ReplaceAll(value, secret, Redacted)
+	}
+
+	return value
+}
+
+func (t *Transport) redactHeader(header http.Header) http.Header {
+	redacted := http.Header{}
+	for key, values := range header {
+		for _, value := range values {
+			if slices.ContainsFunc(t.headers, func(name string) bool { return strings.EqualFold(name, key) }) {
+				value = Redacted
+			}
+			redacted.Add(key, t.redact(value))
+		}
+	}
+
+	return redacted
+}
+
+// normalize makes a request body comparable across runs: JSON is
+// re-encoded with sorted keys, forms are sorted, and multipart uploads, whose
+// boundary is random, are reduced to their fields and file digests.
+func (t *Transport) normalize(contentType string, body []byte) []byte {
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+
+	switch {
+	case mediaType == "multipart/form-data":
+		if normalized, err := t.normalizeMultipart(body, params["boundary"]); err == nil {
+			return normalized
+		}
+	case mediaType == "application/x-www-form-urlencoded":
+		if values, err := url.ParseQuery(string(body)); err == nil {
+			for _, field := range t.fields {
+				if values.Has(field) {
+					values.Set(field, Redacted)
+				}
+			}
+			return []byte(t.redact(values.Encode()))
+		}
+	case len(bytes.TrimSpace(body)) > 0 && json.Valid(body):
+		var value any
+		if err := json.Unmarshal(body, &value); err == nil {
+			normalized := &bytes.Buffer{}
+			encoder := json.NewEncoder(normalized)
+			encoder.SetEscapeHTML(false)
+			if err := encoder.Encode(t.redactFields(value)); err == nil {
+				return []byte(t.redact(strings.TrimSpace(normalized.String())))
+			}
+		}
+	}
+
+	return []byte(t.redact(string(body)))
+}
+
+func (t *Transport) normalizeMultipart(body []byte, boundary string) ([]byte, error) {
+	if boundary == "" {
+		return nil, errors.New("no multipart boundary")
+	}
+
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	lines := []string{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+
+		name := part.FormName()
+		switch {
+		case part.FileName() != "":
+			lines = append(lines, fmt.Sprintf("%s: file %s, %d bytes, sha256 %x", name, part.FileName(), len(data), sha256.Sum256(data)))
+		case slices.Contains(t.fields, name):
+			lines = append(lines, name+": "+Redacted)
+		default:
+			lines = append(lines, name+": "+string(data))
+		}
+	}
+
+	return []byte(t.redact(strings.Join(lines, "\n"))), nil
+}
+
+func (t *Transport) redactFields(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, field := range v {
+			if slices.Contains(t.fields, key) {
+				v[key] = Redacted
+				continue
+			}
+			v[key] = t.redactFields(field)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = t.redactFields(item)
+		}
+	}
+
+	return value
+}
+
+func encodeBody(body []byte) (string, string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+func decodeBody(body string, encoding string) ([]byte, error) {
+	if encoding == "base64" {
+		return base64.StdEncoding.DecodeString(body)
+	}
+
+	return []byte(body), nil
+}
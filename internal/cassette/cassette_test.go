@@ -0,0 +1,242 @@
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const secret = "sk-test-secret"
+
+// newServer answers every request with a counter and echoes what it got, so
+// a replayed response can be told apart from a fresh one.
+func newServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Set-Cookie", "session="+secret)
+		fmt.Fprintf(w, "call %d: %s %s", calls.Add(1), r.Method, body)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func open(t *testing.T, path string, mode Mode) *Transport {
+	t.Helper()
+
+	transport, err := New(path, mode, Options{Secrets: []string{secret}, Fields: []string{"password"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return transport
+}
+
+func send(t *testing.T, client *http.Client, req *http.Request) string {
+	t.Helper()
+
+	response, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(body)
+}
+
+func newRequest(t *testing.T, method string, url string, contentType string, body []byte) *http.Request {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	return req
+}
+
+func TestRecordReplay(t *testing.T) {
+	server := newServer(t)
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	recorder := open(t, path, ModeRecord).Client()
+	req := newRequest(t, "POST", server.URL+"/chat?key="+secret, "application/json", []byte(`{"b":1,"a":"x"}`))
+	recorded := send(t, recorder, req)
+	if recorded != `call 1: POST {"b":1,"a":"x"}` {
+		t.Fatalf("recorded response = %q", recorded)
+	}
+
+	server.Close()
+	player := open(t, path, ModeReplay).Client()
+	// Keys in another order still match, as the body is normalized.
+	req = newRequest(t, "POST", server.URL+"/chat?key="+secret, "application/json", []byte(`{"a":"x","b":1}`))
+	if replayed := send(t, player, req); replayed != recorded {
+		t.Errorf("replayed %q, want %q", replayed, recorded)
+	}
+
+	req = newRequest(t, "POST", server.URL+"/chat?key="+secret, "application/json", []byte(`{"a":"y"}`))
+	if _, err := player.Do(req); !errors.Is(err, ErrNoInteraction) {
+		t.Errorf("unrecorded request: err = %v, want %v", err, ErrNoInteraction)
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	server := newServer(t)
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	client := open(t, path, ModeRecord).Client()
+
+	req := newRequest(t, "POST", server.URL+"/login?key="+secret, "application/json", []byte(`{"user":"me","password":"hunter2","nested":{"api_key":"k"}}`))
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Set("X-Note", "uses "+secret)
+	send(t, client, req)
+
+	req = newRequest(t, "POST", server.URL+"/form", "application/x-www-form-urlencoded", []byte("apikey=k&user=me"))
+	send(t, client, req)
+
+	// The server echoes request bodies, and only the requests are checked
+	// for redacted fields.
+	var cassette Cassette
+	data, err := os.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(data, &cassette)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := []byte(fmt.Sprintf("%+v", []Request{cassette.Interactions[0].Request, cassette.Interactions[1].Request}))
+	for _, leaked := range []string{secret, "Bearer token", "hunter2", `"k"`, "apikey=k"} {
+		if bytes.Contains(requests, []byte(leaked)) {
+			t.Errorf("recorded requests contain %q:\n%s", leaked, requests)
+		}
+	}
+	if bytes.Contains(data, []byte(secret)) {
+		t.Errorf("cassette contains the secret:\n%s", data)
+	}
+	for _, kept := range []string{`"user":"me"`, "/login?key=" + Redacted, "uses " + Redacted, "apikey=REDACTED&user=me"} {
+		if !bytes.Contains(requests, []byte(kept)) {
+			t.Errorf("recorded requests lack %q:\n%s", kept, requests)
+		}
+	}
+}
+
+func TestMultipartNormalization(t *testing.T) {
+	server := newServer(t)
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	upload := func(client *http.Client) string {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("model", "whisper-1")
+		writer.WriteField("password", "hunter2")
+		file, err := writer.CreateFormFile("file", "audio.mp3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Write([]byte{0xff, 0xfb, 0x90, 0x00})
+		writer.Close()
+
+		return send(t, client, newRequest(t, "POST", server.URL+"/audio", writer.FormDataContentType(), body.Bytes()))
+	}
+
+	recorded := upload(open(t, path, ModeRecord).Client())
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(`model: whisper-1\npassword: REDACTED\nfile: file audio.mp3, 4 bytes, sha256`)) {
+		t.Errorf("multipart body not normalized:\n%s", data)
+	}
+
+	server.Close()
+	// Every writer picks a new random boundary, and it must still match.
+	if replayed := upload(open(t, path, ModeReplay).Client()); replayed != recorded {
+		t.Errorf("replayed %q, want %q", replayed, recorded)
+	}
+}
+
+func TestReplayOrder(t *testing.T) {
+	server := newServer(t)
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	recorder := open(t, path, ModeRecord).Client()
+	for range 2 {
+		send(t, recorder, newRequest(t, "GET", server.URL+"/status", "", nil))
+	}
+	send(t, recorder, newRequest(t, "GET", server.URL+"/other", "", nil))
+
+	server.Close()
+	player := open(t, path, ModeReplay).Client()
+	got := []string{}
+	for range 3 {
+		got = append(got, send(t, player, newRequest(t, "GET", server.URL+"/status", "", nil)))
+	}
+
+	want := []string{"call 1: GET ", "call 2: GET ", "call 2: GET "}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("replayed %q, want %q", got, want)
+	}
+}
+
+func TestRequestUntouched(t *testing.T) {
+	server := newServer(t)
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	for _, mode := range []Mode{ModeRecord, ModeReplay} {
+		transport := open(t, path, mode)
+
+		req := newRequest(t, "POST", server.URL+"/chat", "application/json", []byte(`{"a":1}`))
+		body := req.Body
+		response, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if req.Body != body {
+			t.Errorf("%s: request body replaced", mode)
+		}
+
+		// Without GetBody the body can only be read once, so a copy of the
+		// request is sent instead.
+		req = newRequest(t, "POST", server.URL+"/chat", "application/json", nil)
+		req.Body = io.NopCloser(strings.NewReader(`{"a":1}`))
+		body = req.Body
+		response, err = transport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(response.Body)
+		response.Body.Close()
+		if req.Body != body || req.GetBody != nil {
+			t.Errorf("%s: request modified", mode)
+		}
+		if !strings.Contains(string(got), `{"a":1}`) {
+			t.Errorf("%s: body not sent: %q", mode, got)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package di
 
-import "fmt"
+import (
+	"fmt"
+	"sync"
+)
 
 type ServiceFactoryFn = func(*Container) any
 
@@ -22,3 +25,17 @@ func (c *Container) Get(serviceName string) any {
 
 	return fn(c)
 }
+
+// Shared wraps a factory so that every Get returns the same instance.
+func Shared(fn ServiceFactoryFn) ServiceFactoryFn {
+	var once sync.Once
+	var service any
+
+	return func(c *Container) any {
+		once.Do(func() {
+			service = fn(c)
+		})
+
+		return service
+	}
+}
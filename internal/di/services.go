@@ -1,39 +1,179 @@
 package di
 
 import (
+	"cmp"
 	"fmt"
+	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/mendableai/firecrawl-go"
 	"github.com/redis/go-redis/v9"
 	"woyteck.pl/ai_devs3/internal/aidevs"
+	"woyteck.pl/ai_devs3/internal/cassette"
 	"woyteck.pl/ai_devs3/internal/llama"
+	"woyteck.pl/ai_devs3/internal/llm"
 	"woyteck.pl/ai_devs3/internal/openai"
 	"woyteck.pl/ai_devs3/internal/qdrant"
+	"woyteck.pl/ai_devs3/internal/retry"
 )
 
 var Services = map[string]ServiceFactoryFn{
 	"responder": func(c *Container) any {
 		url := fmt.Sprintf("%s/report", os.Getenv("CENTRALA_BASEURL"))
-		return aidevs.NewResponder(url, os.Getenv("AI_DEVS_KEY"))
+		opts := []aidevs.Option{}
+		if timeout, ok := durationFromEnv("CENTRALA_TIMEOUT"); ok {
+			opts = append(opts, aidevs.WithTimeout(timeout))
+		}
+		if client, ok := c.Get("http_client").(*http.Client); ok {
+			opts = append(opts, aidevs.WithHTTPClient(client))
+		}
+
+		return aidevs.NewResponder(url, os.Getenv("AI_DEVS_KEY"), opts...)
 	},
 	"openai": func(c *Container) any {
-		return openai.NewOpenAI(os.Getenv("OPENAI_API_KEY"))
+		opts := []openai.Option{}
+		if baseURL := os.Getenv("OPENAI_BASE_URL"); baseURL != "" {
+			opts = append(opts, openai.WithBaseURL(baseURL))
+		}
+		if organization := os.Getenv("OPENAI_ORGANIZATION"); organization != "" {
+			opts = append(opts, openai.WithOrganization(organization))
+		}
+		if project := os.Getenv("OPENAI_PROJECT"); project != "" {
+			opts = append(opts, openai.WithProject(project))
+		}
+		if timeout, ok := durationFromEnv("OPENAI_TIMEOUT"); ok {
+			opts = append(opts, openai.WithTimeout(timeout))
+		}
+		if policy, ok := retryPolicyFromEnv("OPENAI"); ok {
+			opts = append(opts, openai.WithRetryPolicy(policy))
+		}
+		if client, ok := c.Get("http_client").(*http.Client); ok {
+			opts = append(opts, openai.WithHTTPClient(client))
+		}
+		if limiter, ok := c.Get("openai_rate_limiter").(*openai.RateLimiter); ok {
+			opts = append(opts, openai.WithRateLimiter(limiter))
+		}
+		if costs, ok := c.Get("openai_costs").(*openai.CostTracker); ok {
+			opts = append(opts, openai.WithCostTracker(costs))
+		}
+
+		return openai.NewOpenAI(os.Getenv("OPENAI_API_KEY"), opts...)
 	},
+	"openai_rate_limiter": Shared(func(c *Container) any {
+		defaults := openai.RateLimit{
+			RequestsPerMinute: intFromEnv("OPENAI_RPM"),
+			TokensPerMinute:   intFromEnv("OPENAI_TPM"),
+		}
+		perModel, err := openai.ParseRateLimits(os.Getenv("OPENAI_RATE_LIMITS"))
+		if err != nil {
+			panic(err)
+		}
+
+		return openai.NewRateLimiter(defaults, perModel)
+	}),
+	"openai_costs": Shared(func(c *Container) any {
+		prices := openai.DefaultPrices()
+		if path := os.Getenv("OPENAI_PRICES_FILE"); path != "" {
+			overrides, err := openai.LoadPricesFile(path)
+			if err != nil {
+				panic(err)
+			}
+			prices = prices.Merge(overrides)
+		}
+
+		costs := openai.NewCostTracker(prices)
+		costs.SetBudget(openai.Budget{
+			MaxUSD:    floatFromEnv("OPENAI_BUDGET_USD"),
+			MaxTokens: intFromEnv("OPENAI_BUDGET_TOKENS"),
+			WarnAt:    floatFromEnv("OPENAI_BUDGET_WARN_AT"),
+		})
+
+		return costs
+	}),
 	"llama": func(c *Container) any {
-		return llama.NewLlama(os.Getenv("LOCAL_LLAMA_URL"))
+		opts := []llama.Option{}
+		if timeout, ok := durationFromEnv("LOCAL_LLAMA_TIMEOUT"); ok {
+			opts = append(opts, llama.WithTimeout(timeout))
+		}
+		if policy, ok := retryPolicyFromEnv("LOCAL_LLAMA"); ok {
+			opts = append(opts, llama.WithRetryPolicy(policy))
+		}
+		if client, ok := c.Get("http_client").(*http.Client); ok {
+			opts = append(opts, llama.WithHTTPClient(client))
+		}
+
+		return llama.NewLlama(os.Getenv("LOCAL_LLAMA_URL"), opts...)
+	},
+	// llm_providers opens models by spec for tasks that pick their own; llm
+	// is the one named by LLM_MODEL.
+	"llm_providers": func(c *Container) any {
+		return &llm.Providers{
+			OpenAI:         c.Get("openai").(*openai.OpenAI),
+			Llama:          c.Get("llama").(*llama.Llama),
+			EmbeddingModel: os.Getenv("LLM_EMBEDDING_MODEL"),
+		}
+	},
+	"llm": func(c *Container) any {
+		model, err := c.Get("llm_providers").(*llm.Providers).Open(cmp.Or(os.Getenv("LLM_MODEL"), llm.DefaultModel))
+		if err != nil {
+			panic(err)
+		}
+
+		return model
 	},
 	"qdrant": func(c *Container) any {
-		return qdrant.NewClient(os.Getenv("QDRANT_HOST"))
+		opts := []qdrant.Option{}
+		if timeout, ok := durationFromEnv("QDRANT_TIMEOUT"); ok {
+			opts = append(opts, qdrant.WithTimeout(timeout))
+		}
+		if policy, ok := retryPolicyFromEnv("QDRANT"); ok {
+			opts = append(opts, qdrant.WithRetryPolicy(policy))
+		}
+		if client, ok := c.Get("http_client").(*http.Client); ok {
+			opts = append(opts, qdrant.WithHTTPClient(client))
+		}
+
+		return qdrant.NewClient(os.Getenv("QDRANT_HOST"), opts...)
 	},
 	"scraper": func(c *Container) any {
 		fc, err := firecrawl.NewFirecrawlApp(os.Getenv("FIRECRAWL_API_KEY"), "https://api.firecrawl.dev")
 		if err != nil {
 			panic(err)
 		}
+		if client, ok := c.Get("http_client").(*http.Client); ok {
+			fc.Client.Transport = client.Transport
+		}
 
 		return fc
 	},
+	// http_client records or replays every HTTP call of the API clients when
+	// HTTP_CASSETTE names a fixture file.
+	"http_client": Shared(func(c *Container) any {
+		path := os.Getenv("HTTP_CASSETTE")
+		if path == "" {
+			return http.DefaultClient
+		}
+
+		mode, err := cassette.ParseMode(os.Getenv("HTTP_CASSETTE_MODE"))
+		if err != nil {
+			panic(err)
+		}
+		transport, err := cassette.New(path, mode, cassette.Options{
+			Secrets: []string{
+				os.Getenv("OPENAI_API_KEY"),
+				os.Getenv("AI_DEVS_KEY"),
+				os.Getenv("FIRECRAWL_API_KEY"),
+				os.Getenv("S01E01_PASSWORD"),
+			},
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		return transport.Client()
+	}),
 	"redis": func(c *Container) any {
 		return redis.NewClient(&redis.Options{
 			Addr:     os.Getenv("REDIS_HOST"),
@@ -42,3 +182,61 @@ var Services = map[string]ServiceFactoryFn{
 		})
 	},
 }
+
+func durationFromEnv(name string) (time.Duration, bool) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, false
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		panic(fmt.Sprintf("invalid duration in %s: %v", name, err))
+	}
+
+	return duration, true
+}
+
+func intFromEnv(name string) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		panic(fmt.Sprintf("invalid number in %s: %v", name, err))
+	}
+
+	return number
+}
+
+func floatFromEnv(name string) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		panic(fmt.Sprintf("invalid number in %s: %v", name, err))
+	}
+
+	return number
+}
+
+func retryPolicyFromEnv(prefix string) (retry.Policy, bool) {
+	policy := retry.DefaultPolicy()
+	configured := false
+
+	if attempts := intFromEnv(prefix + "_RETRY_MAX_ATTEMPTS"); attempts > 0 {
+		policy.MaxAttempts = attempts
+		configured = true
+	}
+	if elapsed, ok := durationFromEnv(prefix + "_RETRY_MAX_ELAPSED"); ok {
+		policy.MaxElapsed = elapsed
+		configured = true
+	}
+
+	return policy, configured
+}
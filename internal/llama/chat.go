@@ -0,0 +1,122 @@
+package llama
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Images are base64 encoded, for multimodal models.
+type Message struct {
+	Role    string   `json:"role"`
+	Content string   `json:"content"`
+	Images  []string `json:"images,omitempty"`
+}
+
+// Format is "json" or a JSON schema the reply must match. Options are model
+// parameters such as temperature or num_predict.
+type ChatRequest struct {
+	Model    string         `json:"model"`
+	Messages []Message      `json:"messages"`
+	Stream   bool           `json:"stream"`
+	Format   any            `json:"format,omitempty"`
+	Options  map[string]any `json:"options,omitempty"`
+}
+
+type ChatResponse struct {
+	Model              string    `json:"model"`
+	CreatedAt          time.Time `json:"created_at"`
+	Message            Message   `json:"message"`
+	Done               bool      `json:"done"`
+	DoneReason         string    `json:"done_reason"`
+	TotalDuration      int       `json:"total_duration"`
+	LoadDuration       int       `json:"load_duration"`
+	PromptEvalCount    int       `json:"prompt_eval_count"`
+	PromptEvalDuration int       `json:"prompt_eval_duration"`
+	EvalCount          int       `json:"eval_count"`
+	EvalDuration       int       `json:"eval_duration"`
+	Error              string    `json:"error,omitempty"`
+}
+
+type EmbedRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type EmbedResponse struct {
+	Model           string      `json:"model"`
+	Embeddings      [][]float64 `json:"embeddings"`
+	PromptEvalCount int         `json:"prompt_eval_count"`
+}
+
+func (l *Llama) Chat(ctx context.Context, request ChatRequest) (ChatResponse, error) {
+	request.Stream = false
+
+	var result ChatResponse
+	err := l.post(ctx, l.endpoint("/api/chat"), request, &result)
+
+	return result, err
+}
+
+// ChatStream calls onChunk with every chunk of the reply as it arrives. The
+// response it returns holds the whole message and the stats of the final
+// chunk.
+func (l *Llama) ChatStream(ctx context.Context, request ChatRequest, onChunk func(ChatResponse) error) (ChatResponse, error) {
+	request.Stream = true
+
+	var result ChatResponse
+	if l.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.timeout)
+		defer cancel()
+	}
+
+	response, err := l.open(ctx, l.endpoint("/api/chat"), request)
+	if err != nil {
+		return result, err
+	}
+	defer response.Body.Close()
+
+	content := ""
+	scanner := bufio.NewScanner(response.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var chunk ChatResponse
+		if err := json.Unmarshal(scanner.Bytes(), &chunk); err != nil {
+			return result, fmt.Errorf("can not unmarshal chunk: %w", err)
+		}
+		if chunk.Error != "" {
+			return result, fmt.Errorf("llama: %s", chunk.Error)
+		}
+		if err := onChunk(chunk); err != nil {
+			return result, err
+		}
+
+		content += chunk.Message.Content
+		result = chunk
+		if chunk.Done {
+			result.Message.Content = content
+			return result, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return result, err
+	}
+
+	return result, errors.New("llama: stream ended before done")
+}
+
+// Embed embeds all inputs in one request, keeping their order.
+func (l *Llama) Embed(ctx context.Context, request EmbedRequest) (EmbedResponse, error) {
+	var result EmbedResponse
+	err := l.post(ctx, l.endpoint("/api/embed"), request, &result)
+
+	return result, err
+}
@@ -2,24 +2,54 @@ package llama
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
+	"strings"
 	"time"
+
+	"woyteck.pl/ai_devs3/internal/retry"
 )
 
+const DefaultTimeout = 5 * time.Minute
+
 type Llama struct {
-	url string
+	url         string
+	timeout     time.Duration
+	client      *http.Client
+	retryPolicy retry.Policy
+}
+
+type Option func(*Llama)
+
+func WithTimeout(timeout time.Duration) Option {
+	return func(l *Llama) {
+		l.timeout = timeout
+	}
+}
+
+func WithHTTPClient(client *http.Client) Option {
+	return func(l *Llama) {
+		l.client = client
+	}
+}
+
+func WithRetryPolicy(policy retry.Policy) Option {
+	return func(l *Llama) {
+		l.retryPolicy = policy
+	}
 }
 
+// Format is "json" or a JSON schema the output must match.
 type CompletionRequest struct {
-	Model  string `json:"model"`
-	Prompt string `json:"prompt"`
-	Stream bool   `json:"stream"`
-	Format string `json:"format,omitempty"`
-	System string `json:"system,omitempty"`
+	Model   string         `json:"model"`
+	Prompt  string         `json:"prompt"`
+	Stream  bool           `json:"stream"`
+	Format  any            `json:"format,omitempty"`
+	System  string         `json:"system,omitempty"`
+	Options map[string]any `json:"options,omitempty"`
 }
 
 type CompletionResponse struct {
@@ -37,47 +67,89 @@ type CompletionResponse struct {
 	EvalDuration       int       `json:"eval_duration"`
 }
 
-func NewLlama(url string) *Llama {
-	return &Llama{
-		url: url,
+func NewLlama(url string, opts ...Option) *Llama {
+	l := &Llama{
+		url:     url,
+		timeout: DefaultTimeout,
+		client:  http.DefaultClient,
 	}
+	l.retryPolicy = retry.DefaultPolicy()
+	for _, opt := range opts {
+		opt(l)
+	}
+	l.client = retry.Client(l.client, l.retryPolicy)
+
+	return l
 }
 
-func (l *Llama) GetCompletion(request CompletionRequest) CompletionResponse {
-	postBody, _ := json.Marshal(request)
-	req, err := http.NewRequest("POST", l.url, bytes.NewBuffer(postBody))
-	if err != nil {
-		log.Fatalf("Error occured %v", err)
+func (l *Llama) GetCompletion(ctx context.Context, request CompletionRequest) (CompletionResponse, error) {
+	var result CompletionResponse
+	err := l.post(ctx, l.url, request, &result)
+
+	return result, err
+}
+
+func (l *Llama) GetCompletionShort(ctx context.Context, prompt string, model string) (CompletionResponse, error) {
+	request := CompletionRequest{
+		Model:  model,
+		Prompt: prompt,
+		Stream: false,
 	}
 
-	response, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Fatalf("Error occured %v", err)
+	return l.GetCompletion(ctx, request)
+}
+
+// endpoint resolves path against the server, whose URL is configured as the
+// generate endpoint, e.g. http://localhost:11434/api/generate.
+func (l *Llama) endpoint(path string) string {
+	return strings.TrimSuffix(strings.TrimRight(l.url, "/"), "/api/generate") + path
+}
+
+func (l *Llama) post(ctx context.Context, url string, request any, result any) error {
+	if l.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.timeout)
+		defer cancel()
 	}
 
-	defer response.Body.Close()
-	if response.StatusCode >= 400 {
-		body, err := io.ReadAll(response.Body)
-		if err != nil {
-			log.Fatal("Coult not read response")
-		}
-		fmt.Println(string(body))
+	response, err := l.open(ctx, url, request)
+	if err != nil {
+		return err
 	}
+	defer response.Body.Close()
 
-	var result CompletionResponse
-	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
-		log.Fatal("Can not unmarshall JSON")
+	if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+		return fmt.Errorf("can not unmarshal response: %w", err)
 	}
 
-	return result
+	return nil
 }
 
-func (l *Llama) GetCompletionShort(prompt string, model string) CompletionResponse {
-	request := CompletionRequest{
-		Model:  model,
-		Prompt: prompt,
-		Stream: false,
+// open sends request and returns the response for the caller to read and
+// close.
+func (l *Llama) open(ctx context.Context, url string, request any) (*http.Response, error) {
+	postBody, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	// Generating and embedding have no side effects, so they can be repeated.
+	req, err := http.NewRequestWithContext(retry.Safe(ctx), "POST", url, bytes.NewBuffer(postBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/json")
+
+	response, err := l.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode >= 400 {
+		defer response.Body.Close()
+		body, _ := io.ReadAll(response.Body)
+		return nil, fmt.Errorf("llama: status %d: %s", response.StatusCode, body)
 	}
 
-	return l.GetCompletion(request)
+	return response, nil
 }
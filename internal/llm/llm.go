@@ -0,0 +1,108 @@
+// Package llm puts chat models of different providers behind one interface,
+// so a task can run on OpenAI or on a local Ollama server by changing the
+// model spec, e.g. "openai:gpt-4o" or "ollama:llama3:8b".
+package llm
+
+import (
+	"context"
+	"reflect"
+
+	"woyteck.pl/ai_devs3/internal/openai"
+	"woyteck.pl/ai_devs3/internal/schema"
+)
+
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+const (
+	FinishReasonStop   = "stop"
+	FinishReasonLength = "length"
+)
+
+type Message struct {
+	Role    string
+	Content string
+}
+
+func System(content string) Message {
+	return Message{Role: RoleSystem, Content: content}
+}
+
+func User(content string) Message {
+	return Message{Role: RoleUser, Content: content}
+}
+
+func Assistant(content string) Message {
+	return Message{Role: RoleAssistant, Content: content}
+}
+
+// Request is what every provider understands. Zero values leave the
+// provider's defaults. With Schema set the reply is JSON matching it, named
+// SchemaName where the provider asks for a name.
+type Request struct {
+	Messages    []Message
+	Temperature *float64
+	MaxTokens   int
+	Schema      *schema.Schema
+	SchemaName  string
+}
+
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+}
+
+type Response struct {
+	Model        string
+	Content      string
+	FinishReason string
+	Usage        Usage
+}
+
+// ChatModel is a chat model of some provider, with the embedding model that
+// goes with it.
+type ChatModel interface {
+	// Name is the spec the model was opened with.
+	Name() string
+	Chat(ctx context.Context, request Request) (Response, error)
+	// ChatStream calls onDelta with every piece of content as it arrives
+	// and returns the whole response.
+	ChatStream(ctx context.Context, request Request, onDelta func(string) error) (Response, error)
+	// Embed embeds inputs, keeping their order.
+	Embed(ctx context.Context, inputs []string) ([][]float64, error)
+}
+
+// Structured asks model to answer with JSON matching the schema of T and
+// decodes the reply into T. Errors are the ones of openai.CompleteInto, for
+// every provider.
+func Structured[T any](ctx context.Context, model ChatModel, request Request) (T, error) {
+	var result T
+
+	t := reflect.TypeOf(result)
+	outputSchema, err := schema.GenerateStrict(t)
+	if err != nil {
+		return result, err
+	}
+	if err := schema.CheckStrict(outputSchema); err != nil {
+		return result, err
+	}
+	request.Schema = outputSchema
+	request.SchemaName = openai.SchemaName(t)
+
+	response, err := model.Chat(ctx, request)
+	if err != nil {
+		return result, err
+	}
+	if response.FinishReason == FinishReasonLength {
+		return result, openai.ErrIncompleteOutput
+	}
+
+	if err := openai.DecodeStructured(response.Content, outputSchema, &result); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
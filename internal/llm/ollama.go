@@ -0,0 +1,97 @@
+package llm
+
+import (
+	"context"
+
+	"woyteck.pl/ai_devs3/internal/llama"
+)
+
+const DefaultOllamaEmbeddingModel = "nomic-embed-text"
+
+// OllamaModel runs a chat model on an Ollama server.
+type OllamaModel struct {
+	client         *llama.Llama
+	model          string
+	embeddingModel string
+}
+
+func NewOllama(client *llama.Llama, model string, opts ...Option) *OllamaModel {
+	o := newOptions(DefaultOllamaEmbeddingModel, opts)
+
+	return &OllamaModel{
+		client:         client,
+		model:          model,
+		embeddingModel: o.embeddingModel,
+	}
+}
+
+func (m *OllamaModel) Name() string {
+	return Spec{Provider: ProviderOllama, Model: m.model}.String()
+}
+
+func (m *OllamaModel) Chat(ctx context.Context, request Request) (Response, error) {
+	response, err := m.client.Chat(ctx, m.chatRequest(request))
+	if err != nil {
+		return Response{}, err
+	}
+
+	return ollamaResponse(response), nil
+}
+
+func (m *OllamaModel) ChatStream(ctx context.Context, request Request, onDelta func(string) error) (Response, error) {
+	response, err := m.client.ChatStream(ctx, m.chatRequest(request), func(chunk llama.ChatResponse) error {
+		if chunk.Message.Content == "" {
+			return nil
+		}
+		return onDelta(chunk.Message.Content)
+	})
+	if err != nil {
+		return Response{}, err
+	}
+
+	return ollamaResponse(response), nil
+}
+
+func (m *OllamaModel) Embed(ctx context.Context, inputs []string) ([][]float64, error) {
+	response, err := m.client.Embed(ctx, llama.EmbedRequest{Model: m.embeddingModel, Input: inputs})
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Embeddings, nil
+}
+
+func (m *OllamaModel) chatRequest(request Request) llama.ChatRequest {
+	chat := llama.ChatRequest{Model: m.model}
+	for _, message := range request.Messages {
+		chat.Messages = append(chat.Messages, llama.Message{Role: message.Role, Content: message.Content})
+	}
+	if request.Schema != nil {
+		chat.Format = request.Schema
+	}
+
+	options := map[string]any{}
+	if request.Temperature != nil {
+		options["temperature"] = *request.Temperature
+	}
+	if request.MaxTokens > 0 {
+		options["num_predict"] = request.MaxTokens
+	}
+	if len(options) > 0 {
+		chat.Options = options
+	}
+
+	return chat
+}
+
+func ollamaResponse(response llama.ChatResponse) Response {
+	return Response{
+		Model:        response.Model,
+		Content:      response.Message.Content,
+		FinishReason: response.DoneReason,
+		Usage: Usage{
+			PromptTokens:     response.PromptEvalCount,
+			CompletionTokens: response.EvalCount,
+		},
+	}
+}
@@ -0,0 +1,186 @@
+package llm_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"woyteck.pl/ai_devs3/internal/llama"
+	"woyteck.pl/ai_devs3/internal/llm"
+	"woyteck.pl/ai_devs3/internal/retry"
+)
+
+// ollamaStub answers /api/chat with reply, streamed word by word when asked
+// to, and /api/embed with the input lengths. It keeps the requests it got.
+type ollamaStub struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	reply  string
+	chats  []map[string]any
+	embeds []llama.EmbedRequest
+}
+
+func newOllamaStub(t *testing.T, reply string) *ollamaStub {
+	t.Helper()
+
+	stub := &ollamaStub{reply: reply}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/chat", stub.chat)
+	mux.HandleFunc("POST /api/embed", stub.embed)
+	stub.Server = httptest.NewServer(mux)
+	t.Cleanup(stub.Close)
+
+	return stub
+}
+
+func (s *ollamaStub) client() *llama.Llama {
+	return llama.NewLlama(s.URL+"/api/generate", llama.WithRetryPolicy(retry.NoRetry()))
+}
+
+func (s *ollamaStub) chat(w http.ResponseWriter, r *http.Request) {
+	var request map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.chats = append(s.chats, request)
+	s.mu.Unlock()
+
+	final := llama.ChatResponse{
+		Model:           request["model"].(string),
+		Message:         llama.Message{Role: "assistant", Content: s.reply},
+		Done:            true,
+		DoneReason:      "stop",
+		PromptEvalCount: 12,
+		EvalCount:       len(strings.Fields(s.reply)),
+	}
+	if request["stream"] != true {
+		json.NewEncoder(w).Encode(final)
+		return
+	}
+
+	encoder := json.NewEncoder(w)
+	for _, word := range strings.SplitAfter(s.reply, " ") {
+		encoder.Encode(llama.ChatResponse{Model: final.Model, Message: llama.Message{Role: "assistant", Content: word}})
+	}
+	final.Message.Content = ""
+	encoder.Encode(final)
+}
+
+func (s *ollamaStub) embed(w http.ResponseWriter, r *http.Request) {
+	var request llama.EmbedRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.embeds = append(s.embeds, request)
+	s.mu.Unlock()
+
+	response := llama.EmbedResponse{Model: request.Model}
+	for _, input := range request.Input {
+		response.Embeddings = append(response.Embeddings, []float64{float64(len(input))})
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func TestOllamaChat(t *testing.T) {
+	stub := newOllamaStub(t, "Warsaw.")
+	model := llm.NewOllama(stub.client(), "llama3:8b")
+
+	temperature := 0.2
+	response, err := model.Chat(context.Background(), llm.Request{
+		Messages:    []llm.Message{llm.System("Be brief."), llm.User("Capital of Poland?")},
+		Temperature: &temperature,
+		MaxTokens:   5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := llm.Response{Model: "llama3:8b", Content: "Warsaw.", FinishReason: llm.FinishReasonStop, Usage: llm.Usage{PromptTokens: 12, CompletionTokens: 1}}
+	if response != want {
+		t.Errorf("response = %+v, want %+v", response, want)
+	}
+
+	sent, _ := json.Marshal(stub.chats[0])
+	wantSent := `{"messages":[{"content":"Be brief.","role":"system"},{"content":"Capital of Poland?","role":"user"}],` +
+		`"model":"llama3:8b","options":{"num_predict":5,"temperature":0.2},"stream":false}`
+	if string(sent) != wantSent {
+		t.Errorf("request = %s, want %s", sent, wantSent)
+	}
+}
+
+func TestOllamaChatStream(t *testing.T) {
+	stub := newOllamaStub(t, "It is sunny today.")
+	model := llm.NewOllama(stub.client(), "llama3")
+
+	deltas := []string{}
+	response, err := model.ChatStream(context.Background(), llm.Request{Messages: []llm.Message{llm.User("Weather?")}}, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(deltas) != fmt.Sprint([]string{"It ", "is ", "sunny ", "today."}) {
+		t.Errorf("deltas = %q", deltas)
+	}
+	if response.Content != "It is sunny today." || response.FinishReason != llm.FinishReasonStop || response.Usage.CompletionTokens != 4 {
+		t.Errorf("response = %+v", response)
+	}
+	if stub.chats[0]["stream"] != true {
+		t.Errorf("request = %v, want a stream", stub.chats[0])
+	}
+}
+
+func TestOllamaStructured(t *testing.T) {
+	stub := newOllamaStub(t, `{"city":"Kraków"}`)
+	model := llm.NewOllama(stub.client(), "llama3")
+
+	result, err := llm.Structured[answer](context.Background(), model, llm.Request{Messages: []llm.Message{llm.User("Where is Wawel?")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.City != "Kraków" {
+		t.Errorf("result = %+v", result)
+	}
+
+	// Ollama takes the schema itself as the format, without a name.
+	format, _ := json.Marshal(stub.chats[0]["format"])
+	want := `{"additionalProperties":false,"properties":{"city":{"type":"string"}},"required":["city"],"type":"object"}`
+	if string(format) != want {
+		t.Errorf("format = %s, want %s", format, want)
+	}
+}
+
+func TestOllamaEmbed(t *testing.T) {
+	stub := newOllamaStub(t, "")
+	inputs := []string{"a", "bb", "ccc"}
+
+	for _, test := range []struct {
+		opts []llm.Option
+		want string
+	}{
+		{want: llm.DefaultOllamaEmbeddingModel},
+		{opts: []llm.Option{llm.WithEmbeddingModel("mxbai-embed-large")}, want: "mxbai-embed-large"},
+	} {
+		model := llm.NewOllama(stub.client(), "llama3", test.opts...)
+		embeddings, err := model.Embed(context.Background(), inputs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(embeddings) != "[[1] [2] [3]]" {
+			t.Errorf("embeddings = %v", embeddings)
+		}
+		if sent := stub.embeds[len(stub.embeds)-1]; sent.Model != test.want || len(sent.Input) != len(inputs) {
+			t.Errorf("embed request = %+v, want model %q", sent, test.want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"woyteck.pl/ai_devs3/internal/openai"
+)
+
+const DefaultOpenAIEmbeddingModel = "text-embedding-3-small"
+
+// OpenAIModel runs a chat model on the OpenAI API. Costs, rate limits and
+// retries are the client's.
+type OpenAIModel struct {
+	client         *openai.OpenAI
+	model          string
+	embeddingModel string
+}
+
+func NewOpenAI(client *openai.OpenAI, model string, opts ...Option) *OpenAIModel {
+	o := newOptions(DefaultOpenAIEmbeddingModel, opts)
+
+	return &OpenAIModel{
+		client:         client,
+		model:          model,
+		embeddingModel: o.embeddingModel,
+	}
+}
+
+func (m *OpenAIModel) Name() string {
+	return Spec{Provider: ProviderOpenAI, Model: m.model}.String()
+}
+
+func (m *OpenAIModel) Chat(ctx context.Context, request Request) (Response, error) {
+	response, err := m.client.GetCompletion(ctx, m.completionRequest(request))
+	if err != nil {
+		return Response{}, err
+	}
+
+	return openAIResponse(response)
+}
+
+func (m *OpenAIModel) ChatStream(ctx context.Context, request Request, onDelta func(string) error) (Response, error) {
+	stream, err := m.client.GetCompletionStream(ctx, m.completionRequest(request))
+	if err != nil {
+		return Response{}, err
+	}
+	defer stream.Close()
+
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Response{}, err
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.Index != 0 || choice.Delta.Content == "" {
+				continue
+			}
+			if err := onDelta(choice.Delta.Content); err != nil {
+				return Response{}, err
+			}
+		}
+	}
+
+	return openAIResponse(stream.Response())
+}
+
+func (m *OpenAIModel) Embed(ctx context.Context, inputs []string) ([][]float64, error) {
+	result, err := m.client.GetEmbeddings(ctx, inputs, m.embeddingModel, openai.EmbeddingOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Embeddings, nil
+}
+
+func (m *OpenAIModel) completionRequest(request Request) openai.CompletionRequest {
+	completion := openai.CompletionRequest{
+		Model:               m.model,
+		Temperature:         request.Temperature,
+		MaxCompletionTokens: request.MaxTokens,
+	}
+	for _, message := range request.Messages {
+		completion.Messages = append(completion.Messages, openai.Message{Role: message.Role, Content: message.Content})
+	}
+	if request.Schema != nil {
+		name := request.SchemaName
+		if name == "" {
+			name = openai.SchemaName(nil)
+		}
+		completion.ResponseFormat = &openai.ResponseFormat{
+			Type: openai.ResponseFormatJSONSchema,
+			JSONSchema: &openai.JSONSchema{
+				Name:   name,
+				Schema: request.Schema,
+				Strict: true,
+			},
+		}
+	}
+
+	return completion
+}
+
+func openAIResponse(response openai.CompletionResponse) (Response, error) {
+	if len(response.Choices) == 0 {
+		return Response{}, errors.New("no choices in response")
+	}
+
+	choice := response.Choices[0]
+	if choice.Message.Refusal != "" {
+		return Response{}, &openai.RefusalError{Refusal: choice.Message.Refusal}
+	}
+
+	return Response{
+		Model:        response.Model,
+		Content:      choice.Message.Content,
+		FinishReason: choice.FinishReason,
+		Usage: Usage{
+			PromptTokens:     response.Usage.PromptTokens,
+			CompletionTokens: response.Usage.CompletionTokens,
+		},
+	}, nil
+}
@@ -0,0 +1,181 @@
+package llm_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"woyteck.pl/ai_devs3/internal/llm"
+	"woyteck.pl/ai_devs3/internal/openai"
+	"woyteck.pl/ai_devs3/internal/openai/openaitest"
+	"woyteck.pl/ai_devs3/internal/schema"
+)
+
+type answer struct {
+	City string `json:"city"`
+}
+
+func TestOpenAIChat(t *testing.T) {
+	server := openaitest.NewServer()
+	defer server.Close()
+	model := llm.NewOpenAI(server.Client(), "gpt-4o-mini")
+
+	server.ScriptChat(openaitest.ChatReply{Content: "Warsaw.", FinishReason: openai.FinishReasonLength})
+
+	response, err := model.Chat(context.Background(), llm.Request{
+		Messages:    []llm.Message{llm.System("Be brief."), llm.User("Capital of Poland?")},
+		Temperature: openai.Ptr(0.2),
+		MaxTokens:   5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Model != "gpt-4o-mini" || response.Content != "Warsaw." || response.FinishReason != llm.FinishReasonLength {
+		t.Errorf("response = %+v", response)
+	}
+	if response.Usage.PromptTokens == 0 || response.Usage.CompletionTokens == 0 {
+		t.Errorf("usage = %+v", response.Usage)
+	}
+
+	sent := server.ChatRequests()[0]
+	if len(sent.Messages) != 2 || sent.Messages[0].Role != llm.RoleSystem || sent.Messages[1].Text() != "Capital of Poland?" {
+		t.Errorf("messages = %+v", sent.Messages)
+	}
+	if sent.Temperature == nil || *sent.Temperature != 0.2 || sent.MaxCompletionTokens != 5 || sent.ResponseFormat != nil {
+		t.Errorf("request = %+v", sent)
+	}
+}
+
+func TestOpenAIChatStream(t *testing.T) {
+	server := openaitest.NewServer()
+	defer server.Close()
+	model := llm.NewOpenAI(server.Client(), "gpt-4o")
+
+	server.ScriptChat(openaitest.ChatReply{Content: "It is sunny today."})
+
+	deltas := []string{}
+	response, err := model.ChatStream(context.Background(), llm.Request{Messages: []llm.Message{llm.User("Weather?")}}, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deltas) < 2 || strings.Join(deltas, "") != "It is sunny today." {
+		t.Errorf("deltas = %q", deltas)
+	}
+	if response.Content != "It is sunny today." || response.FinishReason != llm.FinishReasonStop {
+		t.Errorf("response = %+v", response)
+	}
+
+	stop := errors.New("stop")
+	server.ScriptChat(openaitest.ChatReply{Content: "It is sunny today."})
+	_, err = model.ChatStream(context.Background(), llm.Request{Messages: []llm.Message{llm.User("Weather?")}}, func(string) error {
+		return stop
+	})
+	if !errors.Is(err, stop) {
+		t.Errorf("err = %v, want the error of onDelta", err)
+	}
+}
+
+func TestOpenAIRefusal(t *testing.T) {
+	server := openaitest.NewServer()
+	defer server.Close()
+	model := llm.NewOpenAI(server.Client(), "gpt-4o")
+
+	server.ScriptChat(openaitest.ChatReply{Refusal: "I can't help with that."})
+
+	_, err := model.Chat(context.Background(), llm.Request{Messages: []llm.Message{llm.User("Help")}})
+	var refusal *openai.RefusalError
+	if !errors.As(err, &refusal) || refusal.Refusal != "I can't help with that." {
+		t.Errorf("err = %v, want a RefusalError", err)
+	}
+}
+
+func TestOpenAIStructured(t *testing.T) {
+	server := openaitest.NewServer()
+	defer server.Close()
+	model := llm.NewOpenAI(server.Client(), "gpt-4o")
+
+	server.ScriptChat(
+		openaitest.ChatReply{Content: `{"city":"Kraków"}`},
+		openaitest.ChatReply{Content: `{"city":"Kra`, FinishReason: openai.FinishReasonLength},
+		openaitest.ChatReply{Content: `{"town":"Kraków"}`},
+	)
+
+	request := llm.Request{Messages: []llm.Message{llm.User("Where is Wawel?")}}
+	result, err := llm.Structured[answer](context.Background(), model, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.City != "Kraków" {
+		t.Errorf("result = %+v", result)
+	}
+	format := server.ChatRequests()[0].ResponseFormat
+	if format == nil || format.JSONSchema == nil || format.JSONSchema.Name != "answer" || !format.JSONSchema.Strict {
+		t.Errorf("response format = %+v", format)
+	}
+
+	if _, err := llm.Structured[answer](context.Background(), model, request); !errors.Is(err, openai.ErrIncompleteOutput) {
+		t.Errorf("cut off reply: err = %v, want %v", err, openai.ErrIncompleteOutput)
+	}
+	var schemaErr *openai.SchemaError
+	if _, err := llm.Structured[answer](context.Background(), model, request); !errors.As(err, &schemaErr) {
+		t.Errorf("reply off the schema: err = %v, want a SchemaError", err)
+	}
+}
+
+func TestOpenAISchemaName(t *testing.T) {
+	server := openaitest.NewServer()
+	defer server.Close()
+	model := llm.NewOpenAI(server.Client(), "gpt-4o")
+
+	outputSchema, err := schema.GenerateStrict(answer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.Chat(context.Background(), llm.Request{Schema: outputSchema}); err != nil {
+		t.Fatal(err)
+	}
+	if name := server.ChatRequests()[0].ResponseFormat.JSONSchema.Name; name != openai.SchemaName(nil) {
+		t.Errorf("unnamed schema sent as %q, want %q", name, openai.SchemaName(nil))
+	}
+}
+
+func TestOpenAIEmbed(t *testing.T) {
+	server := openaitest.NewServer()
+	defer server.Close()
+	inputs := []string{"first", "second", "third"}
+
+	for _, test := range []struct {
+		opts []llm.Option
+		want string
+	}{
+		{want: llm.DefaultOpenAIEmbeddingModel},
+		{opts: []llm.Option{llm.WithEmbeddingModel("text-embedding-3-large")}, want: "text-embedding-3-large"},
+	} {
+		model := llm.NewOpenAI(server.Client(), "gpt-4o", test.opts...)
+		embeddings, err := model.Embed(context.Background(), inputs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, input := range inputs {
+			if !slices.Equal(embeddings[i], openaitest.HashEmbedding(input, openaitest.DefaultDimensions)) {
+				t.Errorf("embedding %d is not the one of %q", i, input)
+			}
+		}
+
+		requests := server.RequestsTo("/embeddings")
+		var sent struct {
+			Model string `json:"model"`
+		}
+		if err := requests[len(requests)-1].Decode(&sent); err != nil {
+			t.Fatal(err)
+		}
+		if sent.Model != test.want {
+			t.Errorf("embedding model = %q, want %q", sent.Model, test.want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package llm
+
+import (
+	"fmt"
+	"strings"
+
+	"woyteck.pl/ai_devs3/internal/llama"
+	"woyteck.pl/ai_devs3/internal/openai"
+)
+
+const (
+	ProviderOpenAI = "openai"
+	ProviderOllama = "ollama"
+)
+
+// DefaultModel is used when no spec is configured.
+const DefaultModel = "openai:gpt-4o-mini"
+
+// Spec names a model as "<provider>:<model>". Everything after the first
+// colon is the model, so Ollama tags survive: "ollama:llama3:8b".
+type Spec struct {
+	Provider string
+	Model    string
+}
+
+func ParseSpec(value string) (Spec, error) {
+	provider, model, ok := strings.Cut(strings.TrimSpace(value), ":")
+	if !ok || provider == "" || model == "" {
+		return Spec{}, fmt.Errorf("model spec %q is not <provider>:<model>", value)
+	}
+
+	spec := Spec{Provider: strings.ToLower(provider), Model: model}
+	switch spec.Provider {
+	case ProviderOpenAI, ProviderOllama:
+		return spec, nil
+	}
+
+	return Spec{}, fmt.Errorf("unknown model provider %q", provider)
+}
+
+func (s Spec) String() string {
+	return s.Provider + ":" + s.Model
+}
+
+// Providers holds the clients models are opened with. A nil client makes
+// its provider unavailable.
+type Providers struct {
+	OpenAI *openai.OpenAI
+	Llama  *llama.Llama
+	// EmbeddingModel overrides the provider's default embedding model.
+	EmbeddingModel string
+}
+
+// Open returns the chat model named by spec.
+func (p *Providers) Open(spec string) (ChatModel, error) {
+	parsed, err := ParseSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []Option{}
+	if p.EmbeddingModel != "" {
+		opts = append(opts, WithEmbeddingModel(p.EmbeddingModel))
+	}
+
+	switch parsed.Provider {
+	case ProviderOpenAI:
+		if p.OpenAI == nil {
+			return nil, fmt.Errorf("no client for provider %s", parsed.Provider)
+		}
+		return NewOpenAI(p.OpenAI, parsed.Model, opts...), nil
+	case ProviderOllama:
+		if p.Llama == nil {
+			return nil, fmt.Errorf("no client for provider %s", parsed.Provider)
+		}
+		return NewOllama(p.Llama, parsed.Model, opts...), nil
+	}
+
+	return nil, fmt.Errorf("unknown model provider %q", parsed.Provider)
+}
+
+type options struct {
+	embeddingModel string
+}
+
+type Option func(*options)
+
+func WithEmbeddingModel(model string) Option {
+	return func(o *options) {
+		o.embeddingModel = model
+	}
+}
+
+func newOptions(defaultEmbeddingModel string, opts []Option) options {
+	o := options{embeddingModel: defaultEmbeddingModel}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
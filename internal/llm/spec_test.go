@@ -0,0 +1,76 @@
+package llm_test
+
+import (
+	"testing"
+
+	"woyteck.pl/ai_devs3/internal/llama"
+	"woyteck.pl/ai_devs3/internal/llm"
+	"woyteck.pl/ai_devs3/internal/openai"
+)
+
+func TestParseSpec(t *testing.T) {
+	tests := []struct {
+		value string
+		want  llm.Spec
+		err   bool
+	}{
+		{value: "openai:gpt-4o", want: llm.Spec{Provider: "openai", Model: "gpt-4o"}},
+		{value: "ollama:llama3:8b", want: llm.Spec{Provider: "ollama", Model: "llama3:8b"}},
+		{value: "  OpenAI:gpt-4o-mini\n", want: llm.Spec{Provider: "openai", Model: "gpt-4o-mini"}},
+		{value: "ollama:hf.co/user/model:Q4_K_M", want: llm.Spec{Provider: "ollama", Model: "hf.co/user/model:Q4_K_M"}},
+		{value: "gpt-4o", err: true},
+		{value: "openai:", err: true},
+		{value: ":gpt-4o", err: true},
+		{value: "", err: true},
+		{value: "anthropic:claude", err: true},
+	}
+
+	for _, test := range tests {
+		spec, err := llm.ParseSpec(test.value)
+		if test.err {
+			if err == nil {
+				t.Errorf("ParseSpec(%q) = %+v, want an error", test.value, spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSpec(%q): %v", test.value, err)
+			continue
+		}
+		if spec != test.want {
+			t.Errorf("ParseSpec(%q) = %+v, want %+v", test.value, spec, test.want)
+		}
+		if again, _ := llm.ParseSpec(spec.String()); again != spec {
+			t.Errorf("%q doesn't parse back to %+v", spec.String(), spec)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	providers := llm.Providers{OpenAI: openai.NewOpenAI("test-key")}
+
+	model, err := providers.Open("openai:gpt-4o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := model.(*llm.OpenAIModel); !ok || model.Name() != "openai:gpt-4o" {
+		t.Errorf("opened %T named %q", model, model.Name())
+	}
+
+	if _, err := providers.Open("ollama:llama3"); err == nil {
+		t.Error("opened an Ollama model without a client")
+	}
+
+	providers.Llama = llama.NewLlama("http://localhost:11434/api/generate")
+	model, err = providers.Open("ollama:llama3:8b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := model.(*llm.OllamaModel); !ok || model.Name() != "ollama:llama3:8b" {
+		t.Errorf("opened %T named %q", model, model.Name())
+	}
+
+	if _, err := providers.Open("gpt-4o"); err == nil {
+		t.Error("opened a model without a provider")
+	}
+}
@@ -0,0 +1,428 @@
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"woyteck.pl/ai_devs3/internal/retry"
+)
+
+const (
+	BatchStatusValidating = "validating"
+	BatchStatusFailed     = "failed"
+	BatchStatusInProgress = "in_progress"
+	BatchStatusFinalizing = "finalizing"
+	BatchStatusCompleted  = "completed"
+	BatchStatusExpired    = "expired"
+	BatchStatusCancelling = "cancelling"
+	BatchStatusCancelled  = "cancelled"
+)
+
+const (
+	BatchEndpointChatCompletions = "/v1/chat/completions"
+	BatchEndpointEmbeddings      = "/v1/embeddings"
+)
+
+const (
+	BatchCompletionWindow    = "24h"
+	DefaultBatchPollInterval = 30 * time.Second
+	MaxBatchRequests         = 50000
+	MaxBatchInputFileSize    = 200 << 20
+)
+
+const batchMissingResultMessage = "no result in the batch output"
+
+type BatchRequestCounts struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+}
+
+type BatchError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Param   string `json:"param,omitempty"`
+	Line    int    `json:"line,omitempty"`
+}
+
+type BatchErrors struct {
+	Data []BatchError `json:"data"`
+}
+
+type Batch struct {
+	Id               string             `json:"id"`
+	Object           string             `json:"object"`
+	Endpoint         string             `json:"endpoint"`
+	Errors           *BatchErrors       `json:"errors,omitempty"`
+	InputFileId      string             `json:"input_file_id"`
+	CompletionWindow string             `json:"completion_window"`
+	Status           string             `json:"status"`
+	OutputFileId     string             `json:"output_file_id,omitempty"`
+	ErrorFileId      string             `json:"error_file_id,omitempty"`
+	CreatedAt        int64              `json:"created_at"`
+	InProgressAt     int64              `json:"in_progress_at,omitempty"`
+	ExpiresAt        int64              `json:"expires_at,omitempty"`
+	FinalizingAt     int64              `json:"finalizing_at,omitempty"`
+	CompletedAt      int64              `json:"completed_at,omitempty"`
+	FailedAt         int64              `json:"failed_at,omitempty"`
+	ExpiredAt        int64              `json:"expired_at,omitempty"`
+	CancellingAt     int64              `json:"cancelling_at,omitempty"`
+	CancelledAt      int64              `json:"cancelled_at,omitempty"`
+	RequestCounts    BatchRequestCounts `json:"request_counts"`
+	Metadata         map[string]string  `json:"metadata,omitempty"`
+}
+
+// Done reports whether the batch has stopped running. Expired and cancelled
+// batches may still have output for the requests that finished.
+func (b *Batch) Done() bool {
+	switch b.Status {
+	case BatchStatusCompleted, BatchStatusFailed, BatchStatusExpired, BatchStatusCancelled:
+		return true
+	}
+
+	return false
+}
+
+// BatchIncompleteError is returned by RunCompletionBatch when it stops
+// waiting for a batch. The batch has been asked to cancel, and its input
+// file is kept as long as the batch may still read it.
+type BatchIncompleteError struct {
+	BatchId     string
+	InputFileId string
+	Err         error
+}
+
+func (e *BatchIncompleteError) Error() string {
+	return fmt.Sprintf("batch %s did not finish: %v", e.BatchId, e.Err)
+}
+
+func (e *BatchIncompleteError) Unwrap() error {
+	return e.Err
+}
+
+type CreateBatchRequest struct {
+	InputFileId      string            `json:"input_file_id"`
+	Endpoint         string            `json:"endpoint"`
+	CompletionWindow string            `json:"completion_window"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+}
+
+// BatchCompletion is a completion request in a batch. CustomId must be
+// unique within the batch; results are matched back by it.
+type BatchCompletion struct {
+	CustomId string
+	Request  CompletionRequest
+}
+
+type BatchCompletionResult struct {
+	CustomId string
+	Response CompletionResponse
+	Err      error
+}
+
+type BatchOptions struct {
+	// PollInterval defaults to DefaultBatchPollInterval.
+	PollInterval time.Duration
+	Metadata     map[string]string
+}
+
+type batchInputLine struct {
+	CustomId string `json:"custom_id"`
+	Method   string `json:"method"`
+	Url      string `json:"url"`
+	Body     any    `json:"body"`
+}
+
+type batchOutputLine struct {
+	Id       string `json:"id"`
+	CustomId string `json:"custom_id"`
+	Response *struct {
+		StatusCode int             `json:"status_code"`
+		RequestId  string          `json:"request_id"`
+		Body       json.RawMessage `json:"body"`
+	} `json:"response"`
+	Error *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// CompletionBatchInput builds the JSONL input file for a batch of chat
+// completions.
+func CompletionBatchInput(completions []BatchCompletion) ([]byte, error) {
+	if len(completions) > MaxBatchRequests {
+		return nil, fmt.Errorf("batch of %d requests is over the limit of %d", len(completions), MaxBatchRequests)
+	}
+
+	input := &bytes.Buffer{}
+	seen := map[string]bool{}
+	for i, completion := range completions {
+		if completion.CustomId == "" {
+			return nil, fmt.Errorf("batch request %d has no custom id", i)
+		}
+		if seen[completion.CustomId] {
+			return nil, fmt.Errorf("duplicate batch custom id %q", completion.CustomId)
+		}
+		if completion.Request.Stream {
+			return nil, fmt.Errorf("batch request %q can not stream", completion.CustomId)
+		}
+		seen[completion.CustomId] = true
+
+		line, err := json.Marshal(batchInputLine{
+			CustomId: completion.CustomId,
+			Method:   "POST",
+			Url:      BatchEndpointChatCompletions,
+			Body:     completion.Request,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("can not marshal batch request %q: %w", completion.CustomId, err)
+		}
+		input.Write(line)
+		input.WriteByte('\n')
+	}
+	if input.Len() > MaxBatchInputFileSize {
+		return nil, fmt.Errorf("batch input of %d bytes is over the limit of %d", input.Len(), MaxBatchInputFileSize)
+	}
+
+	return input.Bytes(), nil
+}
+
+func (o *OpenAI) CreateBatch(ctx context.Context, request CreateBatchRequest) (*Batch, error) {
+	if request.CompletionWindow == "" {
+		request.CompletionWindow = BatchCompletionWindow
+	}
+
+	// A repeated request would start a second billed batch.
+	var result Batch
+	if err := o.postJSON(retry.Once(ctx), o.endpoint("/batches"), request, &result, nil); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (o *OpenAI) GetBatch(ctx context.Context, id string) (*Batch, error) {
+	var result Batch
+	if err := o.send(ctx, "GET", o.endpoint("/batches/"+url.PathEscape(id)), &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (o *OpenAI) CancelBatch(ctx context.Context, id string) (*Batch, error) {
+	var result Batch
+	if err := o.send(retry.Once(ctx), "POST", o.endpoint("/batches/"+url.PathEscape(id)+"/cancel"), &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// WaitBatch polls the batch every interval until it is done. When ctx ends
+// first, only the waiting stops: the batch runs on and is billed unless the
+// caller cancels it with CancelBatch.
+func (o *OpenAI) WaitBatch(ctx context.Context, id string, interval time.Duration) (*Batch, error) {
+	if interval <= 0 {
+		interval = DefaultBatchPollInterval
+	}
+
+	for {
+		batch, err := o.GetBatch(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if batch.Done() {
+			return batch, nil
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return batch, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// RunCompletionBatch uploads completions as a batch, waits for it and
+// returns the results by custom id. It can take up to the 24h completion
+// window. A request without a result, e.g. because the batch expired, gets
+// an error in its result. When waiting fails, for ctx or any other reason,
+// the batch is cancelled and a *BatchIncompleteError returned. The uploaded
+// input is deleted unless the batch may still be running.
+func (o *OpenAI) RunCompletionBatch(ctx context.Context, completions []BatchCompletion, opts BatchOptions) (map[string]BatchCompletionResult, error) {
+	input, err := CompletionBatchInput(completions)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.checkBatch(completions); err != nil {
+		return nil, err
+	}
+
+	file, err := o.UploadFile(ctx, input, "batch.jsonl", FilePurposeBatch)
+	if err != nil {
+		return nil, fmt.Errorf("can not upload batch input: %w", err)
+	}
+	keepInput := false
+	defer func() {
+		if keepInput {
+			log.Printf("openai: batch input %s kept while its batch stops", file.Id)
+			return
+		}
+		if err := o.DeleteFile(context.WithoutCancel(ctx), file.Id); err != nil {
+			log.Printf("openai: can not delete batch input %s: %v", file.Id, err)
+		}
+	}()
+
+	batch, err := o.CreateBatch(ctx, CreateBatchRequest{
+		InputFileId: file.Id,
+		Endpoint:    BatchEndpointChatCompletions,
+		Metadata:    opts.Metadata,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	id := batch.Id
+	batch, err = o.WaitBatch(ctx, id, opts.PollInterval)
+	if err != nil {
+		// Whatever stopped the waiting, the batch runs on and is billed.
+		cancelled, cancelErr := o.CancelBatch(context.WithoutCancel(ctx), id)
+		if cancelErr != nil {
+			err = errors.Join(err, fmt.Errorf("can not cancel batch %s: %w", id, cancelErr))
+		}
+		keepInput = cancelled == nil || !cancelled.Done()
+		return nil, &BatchIncompleteError{BatchId: id, InputFileId: file.Id, Err: err}
+	}
+	if batch.Status == BatchStatusFailed {
+		return nil, batchFailedError(batch)
+	}
+
+	results, err := o.CompletionBatchResults(ctx, batch)
+	if err != nil {
+		return nil, err
+	}
+	for _, completion := range completions {
+		if _, ok := results[completion.CustomId]; !ok {
+			results[completion.CustomId] = BatchCompletionResult{
+				CustomId: completion.CustomId,
+				Err:      fmt.Errorf("batch %s %s: %s", batch.Id, batch.Status, batchMissingResultMessage),
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// checkBatch refuses a batch whose prompts alone, at the batch discount,
+// would overrun the budget. Models are priced apart but checked together.
+func (o *OpenAI) checkBatch(completions []BatchCompletion) error {
+	if o.costs == nil {
+		return nil
+	}
+
+	tokens := map[string]int{}
+	for _, completion := range completions {
+		tokens[completion.Request.Model] += completionMeter(completion.Request).tokens
+	}
+	estimates := []Cost{}
+	for model, count := range tokens {
+		estimates = append(estimates, Cost{Model: model, PromptTokens: count, Batch: true})
+	}
+
+	return o.costs.check(estimates...)
+}
+
+// CompletionBatchResults downloads the output and error files of a finished
+// batch and maps the results by custom id. Successful completions are
+// recorded by the cost tracker at the batch discount.
+func (o *OpenAI) CompletionBatchResults(ctx context.Context, batch *Batch) (map[string]BatchCompletionResult, error) {
+	results := map[string]BatchCompletionResult{}
+
+	for _, id := range []string{batch.OutputFileId, batch.ErrorFileId} {
+		if id == "" {
+			continue
+		}
+
+		content, err := o.GetFileContent(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("can not download batch file %s: %w", id, err)
+		}
+
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		scanner.Buffer(make([]byte, 0, 64<<10), len(content)+1)
+		for scanner.Scan() {
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+
+			var line batchOutputLine
+			if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+				return nil, fmt.Errorf("can not unmarshal batch result: %w", err)
+			}
+
+			result := o.batchResult(ctx, line)
+			results[result.CustomId] = result
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+func (o *OpenAI) batchResult(ctx context.Context, line batchOutputLine) BatchCompletionResult {
+	result := BatchCompletionResult{CustomId: line.CustomId}
+
+	switch {
+	case line.Error != nil:
+		result.Err = &APIError{Code: line.Error.Code, Message: line.Error.Message}
+	case line.Response == nil:
+		result.Err = errors.New(batchMissingResultMessage)
+	case line.Response.StatusCode >= 400:
+		result.Err = parseAPIError(line.Response.StatusCode, line.Response.RequestId, line.Response.Body)
+	default:
+		if err := json.Unmarshal(line.Response.Body, &result.Response); err != nil {
+			result.Err = fmt.Errorf("can not unmarshal response: %w", err)
+			break
+		}
+		if o.costs != nil {
+			o.costs.Record(Cost{
+				Model:            result.Response.Model,
+				Label:            Label(ctx),
+				PromptTokens:     result.Response.Usage.PromptTokens,
+				CachedTokens:     result.Response.Usage.CachedTokens(),
+				CompletionTokens: result.Response.Usage.CompletionTokens,
+				Batch:            true,
+			})
+		}
+	}
+
+	return result
+}
+
+func batchFailedError(batch *Batch) error {
+	messages := []string{}
+	if batch.Errors != nil {
+		for _, batchErr := range batch.Errors.Data {
+			message := batchErr.Code + ": " + batchErr.Message
+			if batchErr.Line > 0 {
+				message = fmt.Sprintf("line %d: %s", batchErr.Line, message)
+			}
+			messages = append(messages, message)
+		}
+	}
+
+	return fmt.Errorf("batch %s failed: %s", batch.Id, strings.Join(messages, "; "))
+}
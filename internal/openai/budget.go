@@ -0,0 +1,110 @@
+package openai
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// DefaultBudgetWarnAt is the share of the budget after which a warning is
+// logged.
+const DefaultBudgetWarnAt = 0.8
+
+// Budget caps what a CostTracker lets its clients spend. Zero limits are
+// unlimited. Tokens count prompt and completion tokens of every model.
+type Budget struct {
+	MaxUSD    float64
+	MaxTokens int
+	WarnAt    float64
+}
+
+// BudgetExceededError is returned instead of making a call once the budget
+// is spent, or when the call's prompt alone would overrun it.
+type BudgetExceededError struct {
+	Limit string
+	Spent string
+}
+
+func (e *BudgetExceededError) Error() string {
+	return fmt.Sprintf("openai budget exceeded: spent %s of %s", e.Spent, e.Limit)
+}
+
+func IsBudgetExceeded(err error) bool {
+	var budgetErr *BudgetExceededError
+	return errors.As(err, &budgetErr)
+}
+
+// SetBudget starts enforcing budget on all clients using the tracker.
+// Calls already in flight when the limit is reached still complete, so
+// concurrent callers may overshoot it by a few calls.
+func (t *CostTracker) SetBudget(budget Budget) {
+	if budget.WarnAt <= 0 || budget.WarnAt > 1 {
+		budget.WarnAt = DefaultBudgetWarnAt
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.budget = budget
+	t.warned = false
+}
+
+// Check refuses a call to model with a prompt of about tokens when the
+// budget has no room left for it.
+func (t *CostTracker) Check(model string, tokens int) error {
+	return t.check(Cost{Model: model, PromptTokens: tokens})
+}
+
+// check refuses calls whose estimates, added up, overrun the budget.
+func (t *CostTracker) check(estimates ...Cost) error {
+	usd := 0.0
+	tokens := 0
+	for _, estimate := range estimates {
+		t.prices.price(&estimate)
+		usd += estimate.USD
+		tokens += estimate.PromptTokens
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	budget := t.budget
+	if budget.MaxUSD > 0 && t.total.USD+usd >= budget.MaxUSD {
+		return &BudgetExceededError{
+			Limit: fmt.Sprintf("$%.4f", budget.MaxUSD),
+			Spent: fmt.Sprintf("$%.4f", t.total.USD),
+		}
+	}
+	if budget.MaxTokens > 0 && t.total.PromptTokens+t.total.CompletionTokens+tokens >= budget.MaxTokens {
+		return &BudgetExceededError{
+			Limit: fmt.Sprintf("%d tokens", budget.MaxTokens),
+			Spent: fmt.Sprintf("%d tokens", t.total.PromptTokens+t.total.CompletionTokens),
+		}
+	}
+
+	return nil
+}
+
+// warn logs once when spending crosses the warning threshold. It must be
+// called with t.mu held.
+func (t *CostTracker) warn() {
+	budget := t.budget
+	if t.warned {
+		return
+	}
+
+	used := 0.0
+	if budget.MaxUSD > 0 {
+		used = t.total.USD / budget.MaxUSD
+	}
+	if budget.MaxTokens > 0 {
+		used = max(used, float64(t.total.PromptTokens+t.total.CompletionTokens)/float64(budget.MaxTokens))
+	}
+	if used < budget.WarnAt || used == 0 {
+		return
+	}
+
+	t.warned = true
+	log.Printf("openai: %.0f%% of the budget used ($%.4f, %d tokens)",
+		used*100, t.total.USD, t.total.PromptTokens+t.total.CompletionTokens)
+}
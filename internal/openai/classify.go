@@ -0,0 +1,220 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"woyteck.pl/ai_devs3/internal/schema"
+)
+
+// MaxTopLogprobs is the most alternatives the API returns per token.
+const MaxTopLogprobs = 20
+
+var ErrNoLogprobs = errors.New("no logprobs in response")
+
+// Classification is the label a model chose and how sure it was.
+// Probability is the chance of Label among the labels, from the token
+// logprobs; Scores holds it for every label seen among the alternatives of
+// the label's first token.
+type Classification struct {
+	Label       string
+	Probability float64
+	Scores      map[string]float64
+	Model       string
+}
+
+// Confident reports whether the label is at least threshold likely.
+func (c Classification) Confident(threshold float64) bool {
+	return c.Probability >= threshold
+}
+
+type classificationOutput struct {
+	Label string `json:"label"`
+}
+
+// Classify asks the model to pick one of labels for the conversation in
+// request. The answer is constrained to the labels with a strict schema, and
+// its probability is read from the logprobs of the label's tokens.
+func (o *OpenAI) Classify(ctx context.Context, request CompletionRequest, labels []string) (Classification, error) {
+	result := Classification{Model: request.Model}
+	if len(labels) == 0 {
+		return result, errors.New("no labels to classify into")
+	}
+
+	outputSchema, err := schema.GenerateStrict(classificationOutput{})
+	if err != nil {
+		return result, err
+	}
+	for _, label := range labels {
+		outputSchema.Properties["label"].Enum = append(outputSchema.Properties["label"].Enum, label)
+	}
+	if err := schema.CheckStrict(outputSchema); err != nil {
+		return result, err
+	}
+
+	request.N = 0
+	request.Logprobs = true
+	request.TopLogprobs = min(max(request.TopLogprobs, len(labels)), MaxTopLogprobs)
+	request.ResponseFormat = &ResponseFormat{
+		Type: ResponseFormatJSONSchema,
+		JSONSchema: &JSONSchema{
+			Name:   "classification",
+			Schema: outputSchema,
+			Strict: true,
+		},
+	}
+
+	response, err := o.GetCompletion(ctx, request)
+	if err != nil {
+		return result, err
+	}
+	if len(response.Choices) == 0 {
+		return result, errors.New("no choices in response")
+	}
+	result.Model = response.Model
+
+	choice := response.Choices[0]
+	if choice.Message.Refusal != "" {
+		return result, &RefusalError{Refusal: choice.Message.Refusal}
+	}
+	if choice.FinishReason == FinishReasonLength {
+		return result, ErrIncompleteOutput
+	}
+
+	content := choice.Message.Content
+	var output classificationOutput
+	if err := DecodeStructured(content, outputSchema, &output); err != nil {
+		return result, err
+	}
+	result.Label = output.Label
+
+	if choice.Logprobs == nil || len(choice.Logprobs.Content) == 0 {
+		return result, ErrNoLogprobs
+	}
+	result.Probability, result.Scores, err = labelScores(content, choice.Logprobs.Content, output.Label, labels)
+
+	return result, err
+}
+
+// labelScores finds the tokens of the label's value in content. The label's
+// probability is the product of theirs; the other labels are scored from
+// the alternatives to the first of them, assuming the rest of a label
+// follows for sure once it has started. Scores are normalized over the
+// labels found.
+func labelScores(content string, tokens []TokenLogprob, label string, labels []string) (float64, map[string]float64, error) {
+	start, end, ok := labelSpan(content)
+	if !ok {
+		return 0, nil, fmt.Errorf("no label value in %q", content)
+	}
+
+	logprob := 0.0
+	first := -1
+	firstStart := 0
+	offset := 0
+	for i, token := range tokens {
+		size := len(tokenText(token.Token, token.Bytes))
+		if offset+size > start && offset < end {
+			if first < 0 {
+				first = i
+				firstStart = offset
+			}
+			logprob += token.Logprob
+		}
+		offset += size
+	}
+	if first < 0 {
+		return 0, nil, fmt.Errorf("label %q not found in the logprobs", label)
+	}
+
+	// The first token may begin before the value, e.g. with the quote.
+	prefix := content[firstStart:start]
+	raw := map[string]float64{label: math.Exp(logprob)}
+	for _, alternative := range tokens[first].TopLogprobs {
+		text := tokenText(alternative.Token, alternative.Bytes)
+		if !strings.HasPrefix(text, prefix) {
+			continue
+		}
+		text = strings.TrimPrefix(text, prefix)
+
+		matches := []string{}
+		for _, candidate := range labels {
+			encoded := jsonStringBody(candidate)
+			if strings.HasPrefix(encoded, text) || strings.HasPrefix(text, encoded+`"`) {
+				matches = append(matches, candidate)
+			}
+		}
+		// A token shared by several labels can't tell them apart.
+		if len(matches) == 1 && matches[0] != label {
+			raw[matches[0]] += math.Exp(alternative.Logprob)
+		}
+	}
+
+	total := 0.0
+	for _, p := range raw {
+		total += p
+	}
+	scores := map[string]float64{}
+	for candidate, p := range raw {
+		scores[candidate] = p / total
+	}
+
+	return scores[label], scores, nil
+}
+
+// labelSpan returns the byte range of the label's value, inside the quotes.
+func labelSpan(content string) (int, int, bool) {
+	key := strings.Index(content, `"label"`)
+	if key < 0 {
+		return 0, 0, false
+	}
+
+	rest := content[key+len(`"label"`):]
+	colon := strings.Index(rest, ":")
+	if colon < 0 {
+		return 0, 0, false
+	}
+	open := strings.Index(rest[colon:], `"`)
+	if open < 0 {
+		return 0, 0, false
+	}
+
+	start := key + len(`"label"`) + colon + open + 1
+	for end := start; end < len(content); end++ {
+		switch content[end] {
+		case '\\':
+			end++
+		case '"':
+			return start, end, true
+		}
+	}
+
+	return 0, 0, false
+}
+
+func tokenText(token string, raw []int) string {
+	if raw == nil {
+		return token
+	}
+
+	text := make([]byte, len(raw))
+	for i, b := range raw {
+		text[i] = byte(b)
+	}
+
+	return string(text)
+}
+
+// jsonStringBody is s as it appears between the quotes of a JSON string.
+func jsonStringBody(s string) string {
+	encoded := &bytes.Buffer{}
+	encoder := json.NewEncoder(encoded)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+
+	return strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(encoded.String()), `"`), `"`)
+}
@@ -0,0 +1,124 @@
+package openai
+
+import (
+	"math"
+	"testing"
+)
+
+func token(text string, p float64, alternatives ...TopLogprob) TokenLogprob {
+	return TokenLogprob{Token: text, Logprob: math.Log(p), TopLogprobs: alternatives}
+}
+
+func alternative(text string, p float64) TopLogprob {
+	return TopLogprob{Token: text, Logprob: math.Log(p)}
+}
+
+func TestLabelScores(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		tokens  []TokenLogprob
+		label   string
+		labels  []string
+		want    map[string]float64
+	}{
+		{
+			name:    "label over several tokens",
+			content: `{"label":"positive"}`,
+			tokens: []TokenLogprob{
+				token(`{"`, 1), token("label", 1), token(`":"`, 1),
+				token("pos", 0.8, alternative("pos", 0.8), alternative("neg", 0.1)),
+				token("itive", 0.5),
+				token(`"}`, 0.5),
+			},
+			label:  "positive",
+			labels: []string{"positive", "negative"},
+			// positive is 0.8 * 0.5, negative 0.1.
+			want: map[string]float64{"positive": 0.8, "negative": 0.2},
+		},
+		{
+			name:    "first token starts with the quote",
+			content: `{"label": "positive"}`,
+			tokens: []TokenLogprob{
+				token(`{"`, 1), token("label", 1), token(`":`, 1),
+				token(` "pos`, 0.6,
+					alternative(` "neg`, 0.2),
+					alternative(` "neutral"`, 0.1),
+					// Without the quote it can't be the start of the value.
+					alternative("neg", 0.05),
+				),
+				token("itive", 1),
+				token(`"}`, 1),
+			},
+			label:  "positive",
+			labels: []string{"positive", "negative", "neutral"},
+			want:   map[string]float64{"positive": 0.6 / 0.9, "negative": 0.2 / 0.9, "neutral": 0.1 / 0.9},
+		},
+		{
+			name:    "token shared by several labels",
+			content: `{"label":"positive"}`,
+			tokens: []TokenLogprob{
+				token(`{"label":"`, 1),
+				token("pos", 0.5, alternative("pos", 0.5), alternative("neg", 0.25), alternative("poss", 0.125)),
+				token("itive", 1),
+				token(`"}`, 1),
+			},
+			label:  "positive",
+			labels: []string{"positive", "possible", "negative"},
+			want:   map[string]float64{"positive": 0.5 / 0.875, "negative": 0.25 / 0.875, "possible": 0.125 / 0.875},
+		},
+		{
+			name:    "tokens splitting a rune",
+			content: `{"label":"żółty"}`,
+			tokens: []TokenLogprob{
+				token(`{"label":"`, 1),
+				{Token: "ż�", Bytes: []int{0xc5, 0xbc, 0xc3}, Logprob: math.Log(0.7), TopLogprobs: []TopLogprob{alternative("zie", 0.3)}},
+				{Token: "�łty", Bytes: []int{0xb3, 0xc5, 0x82, 't', 'y'}, Logprob: 0},
+				token(`"}`, 0.5),
+			},
+			label:  "żółty",
+			labels: []string{"żółty", "zielony"},
+			want:   map[string]float64{"żółty": 0.7, "zielony": 0.3},
+		},
+		{
+			name:    "no alternatives",
+			content: `{"label":"yes"}`,
+			tokens:  []TokenLogprob{token(`{"label":"`, 1), token("yes", 0.9), token(`"}`, 1)},
+			label:   "yes",
+			labels:  []string{"yes", "no"},
+			want:    map[string]float64{"yes": 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			probability, scores, err := labelScores(test.content, test.tokens, test.label, test.labels)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(probability-test.want[test.label]) > 1e-9 {
+				t.Errorf("probability = %v, want %v", probability, test.want[test.label])
+			}
+			if len(scores) != len(test.want) {
+				t.Errorf("scores = %v, want %v", scores, test.want)
+			}
+			for label, want := range test.want {
+				if math.Abs(scores[label]-want) > 1e-9 {
+					t.Errorf("score of %q = %v, want %v", label, scores[label], want)
+				}
+			}
+		})
+	}
+}
+
+func TestLabelScoresErrors(t *testing.T) {
+	labels := []string{"yes", "no"}
+
+	if _, _, err := labelScores(`{"label": 1}`, []TokenLogprob{token(`{"label": 1}`, 1)}, "yes", labels); err == nil {
+		t.Error("no error for content without a label value")
+	}
+	// The logprobs end before the value does.
+	if _, _, err := labelScores(`{"label":"yes"}`, []TokenLogprob{token(`{"label":"`, 1)}, "yes", labels); err == nil {
+		t.Error("no error for logprobs without the label")
+	}
+}
@@ -0,0 +1,281 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"woyteck.pl/ai_devs3/internal/retry"
+)
+
+const (
+	DefaultBaseURL = "https://api.openai.com/v1"
+	DefaultTimeout = 5 * time.Minute
+)
+
+type OpenAI struct {
+	key          string
+	baseURL      string
+	client       *http.Client
+	timeout      time.Duration
+	organization string
+	project      string
+	headers      http.Header
+	retryPolicy  retry.Policy
+	limiter      *RateLimiter
+	costs        *CostTracker
+}
+
+type Option func(*OpenAI)
+
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *OpenAI) {
+		o.timeout = timeout
+	}
+}
+
+// WithBaseURL points the client at any OpenAI-compatible API. A query string
+// (e.g. Azure's api-version) is kept and appended to every endpoint.
+func WithBaseURL(baseURL string) Option {
+	return func(o *OpenAI) {
+		o.baseURL = strings.TrimRight(baseURL, "/")
+	}
+}
+
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *OpenAI) {
+		o.client = client
+	}
+}
+
+func WithRetryPolicy(policy retry.Policy) Option {
+	return func(o *OpenAI) {
+		o.retryPolicy = policy
+	}
+}
+
+func WithRateLimiter(limiter *RateLimiter) Option {
+	return func(o *OpenAI) {
+		o.limiter = limiter
+	}
+}
+
+// WithCostTracker records the usage and cost of every successful call.
+// Streams report usage only when the tracker is set, as that needs
+// stream_options.include_usage.
+func WithCostTracker(tracker *CostTracker) Option {
+	return func(o *OpenAI) {
+		o.costs = tracker
+	}
+}
+
+func WithOrganization(organization string) Option {
+	return func(o *OpenAI) {
+		o.organization = organization
+	}
+}
+
+func WithProject(project string) Option {
+	return func(o *OpenAI) {
+		o.project = project
+	}
+}
+
+func WithHeader(key string, value string) Option {
+	return func(o *OpenAI) {
+		o.headers.Add(key, value)
+	}
+}
+
+func NewOpenAI(key string, opts ...Option) *OpenAI {
+	o := &OpenAI{
+		key:     key,
+		baseURL: DefaultBaseURL,
+		client:  http.DefaultClient,
+		timeout: DefaultTimeout,
+		headers: http.Header{},
+	}
+	o.retryPolicy = retry.DefaultPolicy()
+	for _, opt := range opts {
+		opt(o)
+	}
+	o.client = retry.Client(o.client, o.retryPolicy)
+
+	return o
+}
+
+func (o *OpenAI) endpoint(path string) string {
+	base, err := url.Parse(o.baseURL)
+	if err != nil || base.RawQuery == "" {
+		return o.baseURL + path
+	}
+
+	base.Path = strings.TrimRight(base.Path, "/") + path
+	return base.String()
+}
+
+func (o *OpenAI) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if o.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, o.timeout)
+}
+
+func (o *OpenAI) postJSON(ctx context.Context, url string, request any, result any, m *meter) error {
+	postBody, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("can not marshal request: %w", err)
+	}
+
+	ctx, cancel := o.withTimeout(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(postBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Content-Type", "application/json")
+
+	return o.do(req, result, m)
+}
+
+// send makes a request without a body, such as a GET or DELETE.
+func (o *OpenAI) send(ctx context.Context, method string, url string, result any) error {
+	ctx, cancel := o.withTimeout(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return err
+	}
+
+	return o.do(req, result, nil)
+}
+
+// formFile is a file part of a multipart upload.
+type formFile struct {
+	field string
+	name  string
+	data  []byte
+}
+
+// postMultipart uploads files and the non-empty fields as multipart form
+// data. Repeated fields such as "timestamp_granularities[]" are allowed.
+func (o *OpenAI) postMultipart(ctx context.Context, url string, files []formFile, fields [][2]string, result any, m *meter) error {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, file := range files {
+		part, err := writer.CreateFormFile(file.field, file.name)
+		if err != nil {
+			return err
+		}
+		if _, err := part.Write(file.data); err != nil {
+			return err
+		}
+	}
+	for _, field := range fields {
+		if field[1] == "" {
+			continue
+		}
+		if err := writer.WriteField(field[0], field[1]); err != nil {
+			return err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	ctx, cancel := o.withTimeout(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Content-Type", writer.FormDataContentType())
+
+	return o.do(req, result, m)
+}
+
+// bodyDecoder is implemented by results whose body isn't always JSON.
+type bodyDecoder interface {
+	decodeBody(body io.Reader) error
+}
+
+func (o *OpenAI) do(req *http.Request, result any, m *meter) error {
+	o.setHeaders(req)
+
+	if err := o.acquire(req.Context(), m); err != nil {
+		return err
+	}
+
+	response, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 400 {
+		o.settle(m, response.Header, nil)
+		return newAPIError(response)
+	}
+
+	if decoder, ok := result.(bodyDecoder); ok {
+		if err := decoder.decodeBody(response.Body); err != nil {
+			return fmt.Errorf("can not read response: %w", err)
+		}
+	} else if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+		return fmt.Errorf("can not unmarshal response: %w", err)
+	}
+
+	var usage *Usage
+	if reporter, ok := result.(usageReporter); ok {
+		usage = reporter.usage()
+	}
+	o.settle(m, response.Header, usage)
+	o.track(req.Context(), m, result)
+
+	return nil
+}
+
+func (o *OpenAI) setHeaders(req *http.Request) {
+	if o.key != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", o.key))
+	}
+	if o.organization != "" {
+		req.Header.Set("OpenAI-Organization", o.organization)
+	}
+	if o.project != "" {
+		req.Header.Set("OpenAI-Project", o.project)
+	}
+	for key, values := range o.headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	if key, ok := req.Context().Value(idempotencyKey{}).(string); ok {
+		req.Header.Set("Idempotency-Key", key)
+	}
+}
+
+type idempotencyKey struct{}
+
+// withIdempotencyKey gives the request made with ctx a key of its own, so
+// that retries of a call with side effects can be told apart from new calls.
+// The retry transport repeats requests that carry one.
+func withIdempotencyKey(ctx context.Context) context.Context {
+	key := make([]byte, 16)
+	rand.Read(key)
+
+	return context.WithValue(ctx, idempotencyKey{}, hex.EncodeToString(key))
+}
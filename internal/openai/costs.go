@@ -0,0 +1,368 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// Price is what a model costs in USD. Token and Characters prices are per
+// million; CachedInput of zero means cached prompt tokens cost the same as
+// Input.
+// Images are priced per image, keyed by size ("1024x1024") or by quality
+// and size ("hd 1024x1024").
+type Price struct {
+	Input       float64            `json:"input,omitempty"`
+	CachedInput float64            `json:"cached_input,omitempty"`
+	Output      float64            `json:"output,omitempty"`
+	PerMinute   float64            `json:"per_minute,omitempty"`
+	Characters  float64            `json:"characters,omitempty"`
+	Images      map[string]float64 `json:"images,omitempty"`
+}
+
+// BatchDiscount is the share of the list price that Batch API calls cost.
+const BatchDiscount = 0.5
+
+// Prices maps model names to prices. Dated snapshots such as
+// gpt-4o-2024-08-06 use the price of the longest matching model name.
+type Prices map[string]Price
+
+// DefaultPrices returns list prices at the time of writing. Check them
+// against https://openai.com/api/pricing and override what changed.
+func DefaultPrices() Prices {
+	return Prices{
+		"gpt-4o":                 {Input: 2.50, CachedInput: 1.25, Output: 10.00},
+		"gpt-4o-mini":            {Input: 0.15, CachedInput: 0.075, Output: 0.60},
+		"gpt-4.1":                {Input: 2.00, CachedInput: 0.50, Output: 8.00},
+		"gpt-4.1-mini":           {Input: 0.40, CachedInput: 0.10, Output: 1.60},
+		"gpt-4.1-nano":           {Input: 0.10, CachedInput: 0.025, Output: 0.40},
+		"gpt-4-turbo":            {Input: 10.00, Output: 30.00},
+		"gpt-4":                  {Input: 30.00, Output: 60.00},
+		"gpt-3.5-turbo":          {Input: 0.50, Output: 1.50},
+		"o1":                     {Input: 15.00, CachedInput: 7.50, Output: 60.00},
+		"o1-mini":                {Input: 1.10, CachedInput: 0.55, Output: 4.40},
+		"o3":                     {Input: 2.00, CachedInput: 0.50, Output: 8.00},
+		"o3-mini":                {Input: 1.10, CachedInput: 0.55, Output: 4.40},
+		"o4-mini":                {Input: 1.10, CachedInput: 0.275, Output: 4.40},
+		"text-embedding-3-small": {Input: 0.02},
+		"text-embedding-3-large": {Input: 0.13},
+		"text-embedding-ada-002": {Input: 0.10},
+		"whisper-1":              {PerMinute: 0.006},
+		"gpt-4o-transcribe":      {PerMinute: 0.006},
+		"gpt-4o-mini-transcribe": {PerMinute: 0.003},
+		"tts-1":                  {Characters: 15.00},
+		"tts-1-hd":               {Characters: 30.00},
+		"dall-e-3": {Images: map[string]float64{
+			"1024x1024":    0.040,
+			"1024x1792":    0.080,
+			"1792x1024":    0.080,
+			"hd 1024x1024": 0.080,
+			"hd 1024x1792": 0.120,
+			"hd 1792x1024": 0.120,
+		}},
+		"dall-e-2": {Images: map[string]float64{
+			"256x256":   0.016,
+			"512x512":   0.018,
+			"1024x1024": 0.020,
+		}},
+	}
+}
+
+// LoadPrices reads a JSON object of model prices, for example
+// {"gpt-4o": {"input": 2.5, "cached_input": 1.25, "output": 10}}.
+func LoadPrices(r io.Reader) (Prices, error) {
+	prices := Prices{}
+	if err := json.NewDecoder(r).Decode(&prices); err != nil {
+		return nil, fmt.Errorf("can not read prices: %w", err)
+	}
+
+	return prices, nil
+}
+
+// LoadPricesFile reads prices from a JSON file, see LoadPrices.
+func LoadPricesFile(path string) (Prices, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return LoadPrices(file)
+}
+
+// Merge returns p with the entries of other added or replaced.
+func (p Prices) Merge(other Prices) Prices {
+	merged := Prices{}
+	for model, price := range p {
+		merged[model] = price
+	}
+	for model, price := range other {
+		merged[model] = price
+	}
+
+	return merged
+}
+
+func (p Prices) lookup(model string) (Price, bool) {
+	if price, ok := p[model]; ok {
+		return price, true
+	}
+
+	best := ""
+	for name := range p {
+		if strings.HasPrefix(model, name+"-") && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return Price{}, false
+	}
+
+	return p[best], true
+}
+
+// Cost is what a single call consumed.
+type Cost struct {
+	Model            string  `json:"model"`
+	Label            string  `json:"label,omitempty"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CachedTokens     int     `json:"cached_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	AudioSeconds     float64 `json:"audio_seconds,omitempty"`
+	Characters       int     `json:"characters,omitempty"`
+	Images           int     `json:"images,omitempty"`
+	ImageSize        string  `json:"image_size,omitempty"`
+	USD              float64 `json:"usd"`
+	Priced           bool    `json:"priced"`
+	Batch            bool    `json:"batch,omitempty"`
+}
+
+func (p Prices) price(cost *Cost) {
+	price, ok := p.lookup(cost.Model)
+	if !ok {
+		return
+	}
+
+	cached := price.CachedInput
+	if cached == 0 {
+		cached = price.Input
+	}
+	uncached := cost.PromptTokens - cost.CachedTokens
+
+	cost.USD = (float64(uncached)*price.Input +
+		float64(cost.CachedTokens)*cached +
+		float64(cost.CompletionTokens)*price.Output) / 1e6
+	cost.USD += cost.AudioSeconds / 60 * price.PerMinute
+	cost.USD += float64(cost.Characters) * price.Characters / 1e6
+
+	// A call billed by the minute whose length wasn't reported cost
+	// something, just not anything known here.
+	cost.Priced = price.PerMinute == 0 || cost.AudioSeconds > 0
+	if cost.Images > 0 {
+		perImage, ok := price.Images[cost.ImageSize]
+		cost.USD += float64(cost.Images) * perImage
+		cost.Priced = ok
+	}
+	if cost.Batch {
+		cost.USD *= BatchDiscount
+	}
+}
+
+// Totals adds up the costs of many calls. Unpriced counts calls whose model
+// or image size is missing from the price table, or whose audio length is
+// unknown, and so cost nothing here.
+type Totals struct {
+	Calls            int     `json:"calls"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CachedTokens     int     `json:"cached_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	AudioSeconds     float64 `json:"audio_seconds,omitempty"`
+	Characters       int     `json:"characters,omitempty"`
+	Images           int     `json:"images,omitempty"`
+	USD              float64 `json:"usd"`
+	Unpriced         int     `json:"unpriced,omitempty"`
+}
+
+func (t *Totals) add(cost Cost) {
+	t.Calls++
+	t.PromptTokens += cost.PromptTokens
+	t.CachedTokens += cost.CachedTokens
+	t.CompletionTokens += cost.CompletionTokens
+	t.AudioSeconds += cost.AudioSeconds
+	t.Characters += cost.Characters
+	t.Images += cost.Images
+	t.USD += cost.USD
+	if !cost.Priced {
+		t.Unpriced++
+	}
+}
+
+type CostSummary struct {
+	Total  Totals            `json:"total"`
+	Models map[string]Totals `json:"models"`
+	Labels map[string]Totals `json:"labels"`
+}
+
+// CostTracker records the usage of every call made through the clients it
+// is attached to. It is safe for concurrent use.
+type CostTracker struct {
+	mu     sync.Mutex
+	prices Prices
+	total  Totals
+	models map[string]*Totals
+	labels map[string]*Totals
+	budget Budget
+	warned bool
+}
+
+func NewCostTracker(prices Prices) *CostTracker {
+	return &CostTracker{
+		prices: prices,
+		models: map[string]*Totals{},
+		labels: map[string]*Totals{},
+	}
+}
+
+// Record prices cost and adds it to the totals.
+func (t *CostTracker) Record(cost Cost) Cost {
+	t.prices.price(&cost)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.total.add(cost)
+	totalsFor(t.models, cost.Model).add(cost)
+	label := cost.Label
+	if label == "" {
+		label = "unlabeled"
+	}
+	totalsFor(t.labels, label).add(cost)
+	t.warn()
+
+	return cost
+}
+
+func totalsFor(totals map[string]*Totals, key string) *Totals {
+	if _, ok := totals[key]; !ok {
+		totals[key] = &Totals{}
+	}
+
+	return totals[key]
+}
+
+func (t *CostTracker) Summary() CostSummary {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	summary := CostSummary{
+		Total:  t.total,
+		Models: map[string]Totals{},
+		Labels: map[string]Totals{},
+	}
+	for model, totals := range t.models {
+		summary.Models[model] = *totals
+	}
+	for label, totals := range t.labels {
+		summary.Labels[label] = *totals
+	}
+
+	return summary
+}
+
+// Report writes the summary to w as a table, or as JSON when format is
+// "json". Format "none" writes nothing.
+func (t *CostTracker) Report(w io.Writer, format string) error {
+	summary := t.Summary()
+
+	switch format {
+	case "none":
+		return nil
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(summary)
+	case "", "text":
+		return summary.Print(w)
+	}
+
+	return fmt.Errorf("unknown cost report format %q", format)
+}
+
+func (s CostSummary) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	printTotals(tw, "MODEL", s.Models)
+	fmt.Fprintln(tw)
+	printTotals(tw, "LABEL", s.Labels)
+	fmt.Fprintln(tw)
+	printRow(tw, "TOTAL", s.Total)
+	if s.Total.Unpriced > 0 {
+		fmt.Fprintf(tw, "%d calls had no price and are not included in the cost\n", s.Total.Unpriced)
+	}
+
+	return tw.Flush()
+}
+
+func printTotals(w io.Writer, title string, totals map[string]Totals) {
+	fmt.Fprintf(w, "%s\tcalls\tprompt\tcached\tcompletion\taudio min\tchars\timages\tUSD\t\n", title)
+
+	keys := make([]string, 0, len(totals))
+	for key := range totals {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		printRow(w, key, totals[key])
+	}
+}
+
+func printRow(w io.Writer, name string, t Totals) {
+	fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%.2f\t%d\t%d\t%.4f\t\n",
+		name, t.Calls, t.PromptTokens, t.CachedTokens, t.CompletionTokens, t.AudioSeconds/60, t.Characters, t.Images, t.USD)
+}
+
+type labelKey struct{}
+
+// WithLabel tags the calls made with ctx, so costs can be told apart per
+// caller, for example "transcribe" and "answer" within one task.
+func WithLabel(ctx context.Context, label string) context.Context {
+	return context.WithValue(ctx, labelKey{}, label)
+}
+
+// Label returns the label set with WithLabel.
+func Label(ctx context.Context) string {
+	label, _ := ctx.Value(labelKey{}).(string)
+
+	return label
+}
+
+// track records what a successful call consumed, read from its meter and
+// decoded response.
+func (o *OpenAI) track(ctx context.Context, m *meter, result any) {
+	if o.costs == nil || m == nil {
+		return
+	}
+
+	cost := Cost{Model: m.model, Label: Label(ctx), ImageSize: m.imageSize, Characters: m.characters}
+	switch r := result.(type) {
+	case usageReporter:
+		usage := r.usage()
+		cost.PromptTokens = usage.PromptTokens
+		cost.CachedTokens = usage.CachedTokens()
+		cost.CompletionTokens = usage.CompletionTokens
+	case *TranscriptionResponse:
+		cost.AudioSeconds = r.Duration
+		if r.Usage != nil && r.Usage.Seconds > 0 {
+			cost.AudioSeconds = r.Usage.Seconds
+		}
+	case *CreateImageResponse:
+		cost.Images = len(r.Data)
+	}
+
+	o.costs.Record(cost)
+}
@@ -0,0 +1,131 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"woyteck.pl/ai_devs3/internal/retry"
+)
+
+const (
+	MaxEmbeddingInputs           = 2048
+	MaxEmbeddingTokensPerInput   = 8191
+	MaxEmbeddingTokensPerRequest = 300000
+)
+
+type EmbeddingOptions struct {
+	Dimensions          int
+	User                string
+	BatchSize           int
+	MaxTokensPerRequest int
+}
+
+type EmbeddingsResult struct {
+	Model      string
+	Embeddings [][]float64
+	Usage      Usage
+}
+
+// GetEmbeddings embeds all inputs, splitting them into as few requests as the
+// API's input-count and token limits allow. Embeddings keep the input order.
+func (o *OpenAI) GetEmbeddings(ctx context.Context, inputs []string, model string, opts EmbeddingOptions) (*EmbeddingsResult, error) {
+	batchSize := opts.BatchSize
+	if batchSize <= 0 || batchSize > MaxEmbeddingInputs {
+		batchSize = MaxEmbeddingInputs
+	}
+	maxTokens := opts.MaxTokensPerRequest
+	if maxTokens <= 0 {
+		maxTokens = MaxEmbeddingTokensPerRequest
+	}
+
+	batches, err := embeddingBatches(inputs, tokenCounter(model), batchSize, maxTokens)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &EmbeddingsResult{
+		Model:      model,
+		Embeddings: make([][]float64, len(inputs)),
+	}
+
+	for _, batch := range batches {
+		request := EmbeddingRequest{
+			Input:          inputs[batch.start:batch.end],
+			Model:          model,
+			EncodingFormat: "float",
+			Dimensions:     opts.Dimensions,
+			User:           opts.User,
+		}
+
+		var response EmbeddingResponse
+		m := &meter{model: model, tokens: batch.tokens}
+		if err := o.postJSON(retry.Safe(ctx), o.endpoint("/embeddings"), request, &response, m); err != nil {
+			return nil, err
+		}
+		if len(response.Data) != batch.end-batch.start {
+			return nil, fmt.Errorf("expected %d embeddings, got %d", batch.end-batch.start, len(response.Data))
+		}
+
+		for _, data := range response.Data {
+			if data.Index < 0 || batch.start+data.Index >= batch.end {
+				return nil, fmt.Errorf("embedding index %d out of range", data.Index)
+			}
+			result.Embeddings[batch.start+data.Index] = data.Embedding
+		}
+
+		if response.Model != "" {
+			result.Model = response.Model
+		}
+		result.Usage.PromptTokens += response.Usage.PromptTokens
+		result.Usage.TotalTokens += response.Usage.TotalTokens
+	}
+
+	return result, nil
+}
+
+type embeddingBatch struct {
+	start  int
+	end    int
+	tokens int
+}
+
+func embeddingBatches(inputs []string, count func(string) int, batchSize int, maxTokens int) ([]embeddingBatch, error) {
+	batches := []embeddingBatch{}
+	current := embeddingBatch{}
+
+	for i, input := range inputs {
+		if input == "" {
+			return nil, fmt.Errorf("input %d is empty", i)
+		}
+
+		inputTokens := count(input)
+		if inputTokens > MaxEmbeddingTokensPerInput {
+			return nil, fmt.Errorf("input %d has %d tokens, the limit is %d", i, inputTokens, MaxEmbeddingTokensPerInput)
+		}
+
+		if current.end > current.start && (current.end-current.start >= batchSize || current.tokens+inputTokens > maxTokens) {
+			batches = append(batches, current)
+			current = embeddingBatch{start: i, end: i}
+		}
+
+		current.end = i + 1
+		current.tokens += inputTokens
+	}
+
+	if current.end > current.start {
+		batches = append(batches, current)
+	}
+	if len(batches) == 0 {
+		return nil, errors.New("no inputs to embed")
+	}
+
+	return batches, nil
+}
+
+// estimateTokens is the fallback for models the tokenizer doesn't know. It
+// errs on the high side: English averages about four bytes per token, but
+// Polish and other non-ASCII text packs far fewer.
+func estimateTokens(text string) int {
+	return len(text)/3 + 1
+}
@@ -0,0 +1,126 @@
+package openai
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+type APIError struct {
+	StatusCode int
+	Type       string
+	Code       string
+	Param      string
+	Message    string
+	RequestId  string
+	Body       string
+}
+
+type errorResponse struct {
+	Error struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+		Param   any    `json:"param"`
+		Code    any    `json:"code"`
+	} `json:"error"`
+}
+
+func newAPIError(response *http.Response) *APIError {
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return &APIError{
+			StatusCode: response.StatusCode,
+			RequestId:  response.Header.Get("x-request-id"),
+			Message:    fmt.Sprintf("could not read error response: %v", err),
+		}
+	}
+
+	return parseAPIError(response.StatusCode, response.Header.Get("x-request-id"), body)
+}
+
+func parseAPIError(statusCode int, requestID string, body []byte) *APIError {
+	apiErr := &APIError{
+		StatusCode: statusCode,
+		RequestId:  requestID,
+		Body:       string(body),
+	}
+
+	var result errorResponse
+	if err := json.Unmarshal(body, &result); err != nil || result.Error.Message == "" {
+		apiErr.Message = http.StatusText(statusCode)
+		return apiErr
+	}
+
+	apiErr.Message = result.Error.Message
+	apiErr.Type = result.Error.Type
+	if result.Error.Param != nil {
+		apiErr.Param = fmt.Sprint(result.Error.Param)
+	}
+	if result.Error.Code != nil {
+		apiErr.Code = fmt.Sprint(result.Error.Code)
+	}
+
+	return apiErr
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("openai: status %d", e.StatusCode)
+	if e.Type != "" {
+		msg += fmt.Sprintf(" type=%s", e.Type)
+	}
+	if e.Code != "" {
+		msg += fmt.Sprintf(" code=%s", e.Code)
+	}
+	if e.RequestId != "" {
+		msg += fmt.Sprintf(" request_id=%s", e.RequestId)
+	}
+
+	return msg + ": " + e.Message
+}
+
+func (e *APIError) IsRateLimit() bool {
+	return e.StatusCode == http.StatusTooManyRequests && !e.IsQuotaExceeded()
+}
+
+func (e *APIError) IsQuotaExceeded() bool {
+	return e.Code == "insufficient_quota"
+}
+
+func (e *APIError) IsContextLengthExceeded() bool {
+	return e.Code == "context_length_exceeded"
+}
+
+func (e *APIError) IsInvalidKey() bool {
+	return e.StatusCode == http.StatusUnauthorized || e.Code == "invalid_api_key"
+}
+
+func (e *APIError) IsServerError() bool {
+	return e.StatusCode >= 500
+}
+
+func IsRateLimit(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.IsRateLimit()
+}
+
+func IsQuotaExceeded(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.IsQuotaExceeded()
+}
+
+func IsContextLengthExceeded(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.IsContextLengthExceeded()
+}
+
+func IsInvalidKey(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.IsInvalidKey()
+}
+
+func IsServerError(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.IsServerError()
+}
@@ -0,0 +1,80 @@
+package openai
+
+import (
+	"context"
+	"io"
+	"net/url"
+
+	"woyteck.pl/ai_devs3/internal/retry"
+)
+
+const (
+	FilePurposeBatch      = "batch"
+	FilePurposeAssistants = "assistants"
+	FilePurposeFineTune   = "fine-tune"
+	FilePurposeVision     = "vision"
+	FilePurposeUserData   = "user_data"
+)
+
+type File struct {
+	Id        string `json:"id"`
+	Object    string `json:"object"`
+	Bytes     int    `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"`
+}
+
+type DeletedFile struct {
+	Id      string `json:"id"`
+	Object  string `json:"object"`
+	Deleted bool   `json:"deleted"`
+}
+
+// fileContent is the raw body of a downloaded file.
+type fileContent []byte
+
+func (c *fileContent) decodeBody(body io.Reader) error {
+	data, err := io.ReadAll(body)
+	*c = data
+
+	return err
+}
+
+func (o *OpenAI) UploadFile(ctx context.Context, data []byte, name string, purpose string) (*File, error) {
+	files := []formFile{{field: "file", name: name, data: data}}
+	fields := [][2]string{{"purpose", purpose}}
+
+	// A repeated upload would leave a second copy of the file.
+	var result File
+	if err := o.postMultipart(retry.Once(ctx), o.endpoint("/files"), files, fields, &result, nil); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (o *OpenAI) GetFile(ctx context.Context, id string) (*File, error) {
+	var result File
+	if err := o.send(ctx, "GET", o.endpoint("/files/"+url.PathEscape(id)), &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (o *OpenAI) GetFileContent(ctx context.Context, id string) ([]byte, error) {
+	var result fileContent
+	if err := o.send(ctx, "GET", o.endpoint("/files/"+url.PathEscape(id)+"/content"), &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (o *OpenAI) DeleteFile(ctx context.Context, id string) error {
+	var result DeletedFile
+
+	return o.send(ctx, "DELETE", o.endpoint("/files/"+url.PathEscape(id)), &result)
+}
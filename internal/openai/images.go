@@ -0,0 +1,262 @@
+package openai
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	ImageFormatURL     = "url"
+	ImageFormatB64JSON = "b64_json"
+)
+
+const (
+	ImageQualityStandard = "standard"
+	ImageQualityHD       = "hd"
+)
+
+const (
+	ImageStyleVivid   = "vivid"
+	ImageStyleNatural = "natural"
+)
+
+type CreateImageRequest struct {
+	Model          string `json:"model,omitempty"`
+	Prompt         string `json:"prompt"`
+	N              int    `json:"n,omitempty"`
+	Quality        string `json:"quality,omitempty"`
+	ResponseFormat string `json:"response_format,omitempty"`
+	Size           string `json:"size,omitempty"`
+	Style          string `json:"style,omitempty"`
+	User           string `json:"user,omitempty"`
+}
+
+// EditImageRequest edits Image where Mask is transparent, or where Image
+// itself is transparent without a mask. Both must be square PNGs under 4MB.
+type EditImageRequest struct {
+	Image          []byte
+	ImageName      string
+	Mask           []byte
+	MaskName       string
+	Prompt         string
+	Model          string
+	N              int
+	Size           string
+	ResponseFormat string
+	User           string
+}
+
+type ImageVariationRequest struct {
+	Image          []byte
+	ImageName      string
+	Model          string
+	N              int
+	Size           string
+	ResponseFormat string
+	User           string
+}
+
+// ImageResult holds either a URL, valid for an hour, or the base64 image,
+// depending on the response format. dall-e-3 also returns the prompt it
+// actually used.
+type ImageResult struct {
+	Url           string `json:"url,omitempty"`
+	B64JSON       string `json:"b64_json,omitempty"`
+	RevisedPrompt string `json:"revised_prompt,omitempty"`
+}
+
+type CreateImageResponse struct {
+	Created int           `json:"created"`
+	Data    []ImageResult `json:"data"`
+}
+
+func (o *OpenAI) CreateImageShort(ctx context.Context, prompt string, model string, size string) (*CreateImageResponse, error) {
+	request := CreateImageRequest{
+		Model:  model,
+		Prompt: prompt,
+		N:      1,
+		Size:   size,
+	}
+
+	return o.CreateImage(ctx, request)
+}
+
+func (o *OpenAI) CreateImage(ctx context.Context, request CreateImageRequest) (*CreateImageResponse, error) {
+	url := o.endpoint("/images/generations")
+
+	var result CreateImageResponse
+	m := imageMeter(request.Model, request.Quality, request.Size)
+	if err := o.postJSON(withIdempotencyKey(ctx), url, request, &result, m); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (o *OpenAI) EditImage(ctx context.Context, request EditImageRequest) (*CreateImageResponse, error) {
+	files := []formFile{{field: "image", name: defaultName(request.ImageName, "image.png"), data: request.Image}}
+	if request.Mask != nil {
+		files = append(files, formFile{field: "mask", name: defaultName(request.MaskName, "mask.png"), data: request.Mask})
+	}
+	fields := [][2]string{
+		{"prompt", request.Prompt},
+		{"model", request.Model},
+		{"size", request.Size},
+		{"response_format", request.ResponseFormat},
+		{"user", request.User},
+	}
+	if request.N > 0 {
+		fields = append(fields, [2]string{"n", strconv.Itoa(request.N)})
+	}
+
+	var result CreateImageResponse
+	m := imageMeter(request.Model, "", request.Size)
+	if err := o.postMultipart(withIdempotencyKey(ctx), o.endpoint("/images/edits"), files, fields, &result, m); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (o *OpenAI) CreateImageVariation(ctx context.Context, request ImageVariationRequest) (*CreateImageResponse, error) {
+	files := []formFile{{field: "image", name: defaultName(request.ImageName, "image.png"), data: request.Image}}
+	fields := [][2]string{
+		{"model", request.Model},
+		{"size", request.Size},
+		{"response_format", request.ResponseFormat},
+		{"user", request.User},
+	}
+	if request.N > 0 {
+		fields = append(fields, [2]string{"n", strconv.Itoa(request.N)})
+	}
+
+	var result CreateImageResponse
+	m := imageMeter(request.Model, "", request.Size)
+	if err := o.postMultipart(withIdempotencyKey(ctx), o.endpoint("/images/variations"), files, fields, &result, m); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func defaultName(name string, fallback string) string {
+	if name == "" {
+		return fallback
+	}
+
+	return name
+}
+
+// ImageBytes returns the image, decoding it or downloading it from its URL.
+func (o *OpenAI) ImageBytes(ctx context.Context, image ImageResult) ([]byte, error) {
+	if image.B64JSON != "" {
+		return base64.StdEncoding.DecodeString(image.B64JSON)
+	}
+	if image.Url == "" {
+		return nil, errors.New("image has neither data nor url")
+	}
+
+	ctx, cancel := o.withTimeout(ctx)
+	defer cancel()
+
+	// The URL is signed storage, so no API headers are sent.
+	req, err := http.NewRequestWithContext(ctx, "GET", image.Url, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 400 {
+		return nil, fmt.Errorf("can not download image: status %d", response.StatusCode)
+	}
+
+	return io.ReadAll(response.Body)
+}
+
+// ImageMetadata is saved as JSON next to every image.
+type ImageMetadata struct {
+	File          string `json:"file"`
+	Model         string `json:"model,omitempty"`
+	Prompt        string `json:"prompt,omitempty"`
+	RevisedPrompt string `json:"revised_prompt,omitempty"`
+	Size          string `json:"size,omitempty"`
+	Quality       string `json:"quality,omitempty"`
+	Style         string `json:"style,omitempty"`
+	Created       int    `json:"created"`
+	Url           string `json:"url,omitempty"`
+}
+
+func (r CreateImageRequest) Metadata() ImageMetadata {
+	return ImageMetadata{Model: r.Model, Prompt: r.Prompt, Size: r.Size, Quality: r.Quality, Style: r.Style}
+}
+
+func (r EditImageRequest) Metadata() ImageMetadata {
+	return ImageMetadata{Model: r.Model, Prompt: r.Prompt, Size: r.Size}
+}
+
+func (r ImageVariationRequest) Metadata() ImageMetadata {
+	return ImageMetadata{Model: r.Model, Size: r.Size}
+}
+
+// SaveImages writes every image in response to dir as <name>-<n>.<ext>,
+// each with a <name>-<n>.json sidecar built from metadata, usually the
+// request's Metadata(). It returns the image paths.
+func (o *OpenAI) SaveImages(ctx context.Context, response *CreateImageResponse, dir string, name string, metadata ImageMetadata) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	for i, image := range response.Data {
+		data, err := o.ImageBytes(ctx, image)
+		if err != nil {
+			return paths, fmt.Errorf("image %d: %w", i, err)
+		}
+
+		base := filepath.Join(dir, fmt.Sprintf("%s-%d", name, i+1))
+		path := base + imageExtension(data)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			return paths, err
+		}
+
+		meta := metadata
+		meta.File = filepath.Base(path)
+		meta.RevisedPrompt = image.RevisedPrompt
+		meta.Created = response.Created
+		meta.Url = image.Url
+		sidecar, err := json.MarshalIndent(meta, "", "  ")
+		if err != nil {
+			return paths, err
+		}
+		if err := os.WriteFile(base+".json", sidecar, 0o644); err != nil {
+			return paths, err
+		}
+
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
+
+func imageExtension(data []byte) string {
+	switch http.DetectContentType(data) {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/webp":
+		return ".webp"
+	}
+
+	return ".png"
+}
@@ -0,0 +1,140 @@
+package openai
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	ImageDetailAuto = "auto"
+	ImageDetailLow  = "low"
+	ImageDetailHigh = "high"
+)
+
+// Message is a chat turn. Its content is either the plain Content string or,
+// when Parts is set, an ordered list of text, image and audio parts.
+type Message struct {
+	Role       string
+	Content    string
+	Parts      []ContentPart
+	Refusal    string
+	Name       string
+	ToolCalls  []ToolCall
+	ToolCallId string
+}
+
+type ImageURL struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type InputAudio struct {
+	Data   string `json:"data"`
+	Format string `json:"format"`
+}
+
+type ContentPart struct {
+	Type       string      `json:"type"`
+	Text       string      `json:"text,omitempty"`
+	ImageURL   *ImageURL   `json:"image_url,omitempty"`
+	InputAudio *InputAudio `json:"input_audio,omitempty"`
+}
+
+func TextPart(text string) ContentPart {
+	return ContentPart{Type: "text", Text: text}
+}
+
+func ImagePart(url string, detail string) ContentPart {
+	return ContentPart{Type: "image_url", ImageURL: &ImageURL{URL: url, Detail: detail}}
+}
+
+func ImageDataPart(mimeType string, data []byte, detail string) ContentPart {
+	url := fmt.Sprintf("data:%s;base64,%s", mimeType, base64.StdEncoding.EncodeToString(data))
+	return ImagePart(url, detail)
+}
+
+func AudioPart(data []byte, format string) ContentPart {
+	return ContentPart{
+		Type: "input_audio",
+		InputAudio: &InputAudio{
+			Data:   base64.StdEncoding.EncodeToString(data),
+			Format: format,
+		},
+	}
+}
+
+// Text returns the string content or the text parts joined by newlines.
+func (m Message) Text() string {
+	if len(m.Parts) == 0 {
+		return m.Content
+	}
+
+	texts := []string{}
+	for _, part := range m.Parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+
+	return strings.Join(texts, "\n")
+}
+
+type messageJSON struct {
+	Role       string          `json:"role"`
+	Content    json.RawMessage `json:"content"`
+	Refusal    string          `json:"refusal,omitempty"`
+	Name       string          `json:"name,omitempty"`
+	ToolCalls  []ToolCall      `json:"tool_calls,omitempty"`
+	ToolCallId string          `json:"tool_call_id,omitempty"`
+}
+
+func (m Message) MarshalJSON() ([]byte, error) {
+	var content any = m.Content
+	switch {
+	case len(m.Parts) > 0:
+		content = m.Parts
+	case m.Content == "" && (len(m.ToolCalls) > 0 || m.Refusal != ""):
+		content = nil
+	}
+
+	raw, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(messageJSON{
+		Role:       m.Role,
+		Content:    raw,
+		Refusal:    m.Refusal,
+		Name:       m.Name,
+		ToolCalls:  m.ToolCalls,
+		ToolCallId: m.ToolCallId,
+	})
+}
+
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var decoded messageJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	*m = Message{
+		Role:       decoded.Role,
+		Refusal:    decoded.Refusal,
+		Name:       decoded.Name,
+		ToolCalls:  decoded.ToolCalls,
+		ToolCallId: decoded.ToolCallId,
+	}
+
+	content := strings.TrimSpace(string(decoded.Content))
+	switch {
+	case content == "" || content == "null":
+		return nil
+	case strings.HasPrefix(content, "["):
+		return json.Unmarshal(decoded.Content, &m.Parts)
+	default:
+		return json.Unmarshal(decoded.Content, &m.Content)
+	}
+}
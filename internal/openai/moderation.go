@@ -0,0 +1,191 @@
+package openai
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+
+	"woyteck.pl/ai_devs3/internal/retry"
+)
+
+const (
+	ModerationModelOmni = "omni-moderation-latest"
+	ModerationModelText = "text-moderation-latest"
+)
+
+const (
+	ModerationSexual                = "sexual"
+	ModerationSexualMinors          = "sexual/minors"
+	ModerationHarassment            = "harassment"
+	ModerationHarassmentThreatening = "harassment/threatening"
+	ModerationHate                  = "hate"
+	ModerationHateThreatening       = "hate/threatening"
+	ModerationIllicit               = "illicit"
+	ModerationIllicitViolent        = "illicit/violent"
+	ModerationSelfHarm              = "self-harm"
+	ModerationSelfHarmIntent        = "self-harm/intent"
+	ModerationSelfHarmInstructions  = "self-harm/instructions"
+	ModerationViolence              = "violence"
+	ModerationViolenceGraphic       = "violence/graphic"
+)
+
+// ModerationRequest takes a string, a []string with one result per text, or
+// a []ContentPart of text and image_url parts, which omni-moderation judges
+// together as a single input.
+type ModerationRequest struct {
+	Input any    `json:"input"`
+	Model string `json:"model,omitempty"`
+}
+
+// ModerationResult keeps categories as maps, so categories added to the API
+// later are not dropped.
+type ModerationResult struct {
+	Flagged                   bool                `json:"flagged"`
+	Categories                map[string]bool     `json:"categories"`
+	CategoryScores            map[string]float64  `json:"category_scores"`
+	CategoryAppliedInputTypes map[string][]string `json:"category_applied_input_types,omitempty"`
+}
+
+type ModerationResponse struct {
+	Id      string             `json:"id"`
+	Model   string             `json:"model"`
+	Results []ModerationResult `json:"results"`
+}
+
+// FlaggedCategories returns the categories the API flagged, sorted.
+func (r ModerationResult) FlaggedCategories() []string {
+	categories := []string{}
+	for category, flagged := range r.Categories {
+		if flagged {
+			categories = append(categories, category)
+		}
+	}
+	slices.Sort(categories)
+
+	return categories
+}
+
+// Flagged reports whether any result was flagged.
+func (r ModerationResponse) Flagged() bool {
+	for _, result := range r.Results {
+		if result.Flagged {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (o *OpenAI) Moderate(ctx context.Context, request ModerationRequest) (ModerationResponse, error) {
+	url := o.endpoint("/moderations")
+
+	var result ModerationResponse
+	err := o.postJSON(retry.Safe(ctx), url, request, &result, nil)
+
+	return result, err
+}
+
+func (o *OpenAI) GetModeration(ctx context.Context, input string) (bool, ModerationResponse, error) {
+	request := ModerationRequest{
+		Input: input,
+	}
+
+	result, err := o.Moderate(ctx, request)
+	if err != nil {
+		return false, result, err
+	}
+
+	return result.Flagged(), result, nil
+}
+
+// ModerateImage moderates an image, optionally with the text that goes with
+// it. Images are only supported by omni-moderation models.
+func (o *OpenAI) ModerateImage(ctx context.Context, imageURL string, text string, model string) (ModerationResponse, error) {
+	parts := []ContentPart{}
+	if text != "" {
+		parts = append(parts, TextPart(text))
+	}
+	parts = append(parts, ContentPart{Type: "image_url", ImageURL: &ImageURL{URL: imageURL}})
+
+	return o.Moderate(ctx, ModerationRequest{Input: parts, Model: cmp.Or(model, ModerationModelOmni)})
+}
+
+// Screen moderates texts in a single call and returns the rules of policy
+// they trip.
+func (o *OpenAI) Screen(ctx context.Context, policy ModerationPolicy, texts []string, model string) ([]Violation, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+
+	result, err := o.Moderate(ctx, ModerationRequest{Input: texts, Model: model})
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Results) != len(texts) {
+		return nil, fmt.Errorf("moderated %d texts, got %d results", len(texts), len(result.Results))
+	}
+
+	return policy.Evaluate(result), nil
+}
+
+// ModerationPolicy trips a rule when a category scores at or above its
+// threshold. Categories without a threshold use Default, where zero ignores
+// them. With Flagged set, whatever the API flagged trips as well.
+type ModerationPolicy struct {
+	Thresholds map[string]float64
+	Default    float64
+	Flagged    bool
+}
+
+// Violation is a tripped rule. Input is the index of the moderated input.
+type Violation struct {
+	Input     int
+	Category  string
+	Score     float64
+	Threshold float64
+}
+
+func (v Violation) String() string {
+	if v.Threshold == 0 {
+		return fmt.Sprintf("input %d: %s flagged (%.3f)", v.Input, v.Category, v.Score)
+	}
+
+	return fmt.Sprintf("input %d: %s %.3f >= %.3f", v.Input, v.Category, v.Score, v.Threshold)
+}
+
+// Check returns the rules result trips, sorted by category.
+func (p ModerationPolicy) Check(result ModerationResult) []Violation {
+	violations := []Violation{}
+	for category, score := range result.CategoryScores {
+		threshold, ok := p.Thresholds[category]
+		if !ok {
+			threshold = p.Default
+		}
+
+		switch {
+		case threshold > 0 && score >= threshold:
+			violations = append(violations, Violation{Category: category, Score: score, Threshold: threshold})
+		case p.Flagged && result.Categories[category]:
+			violations = append(violations, Violation{Category: category, Score: score})
+		}
+	}
+	slices.SortFunc(violations, func(a, b Violation) int {
+		return cmp.Compare(a.Category, b.Category)
+	})
+
+	return violations
+}
+
+// Evaluate checks every result in response, numbering violations by input.
+func (p ModerationPolicy) Evaluate(response ModerationResponse) []Violation {
+	violations := []Violation{}
+	for i, result := range response.Results {
+		for _, violation := range p.Check(result) {
+			violation.Input = i
+			violations = append(violations, violation)
+		}
+	}
+
+	return violations
+}
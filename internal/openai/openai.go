@@ -1,51 +1,78 @@
 package openai
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io"
-	"log"
-	"mime/multipart"
-	"net/http"
+	"context"
+	"errors"
+
+	"woyteck.pl/ai_devs3/internal/retry"
 )
 
-type ImageMessage struct {
-	Role    string    `json:"role"`
-	Content []Content `json:"content"`
-}
+const (
+	FinishReasonStop          = "stop"
+	FinishReasonLength        = "length"
+	FinishReasonToolCalls     = "tool_calls"
+	FinishReasonContentFilter = "content_filter"
+)
 
-type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+type Choice struct {
+	Index        int       `json:"index"`
+	Message      Message   `json:"message"`
+	FinishReason string    `json:"finish_reason"`
+	Logprobs     *Logprobs `json:"logprobs,omitempty"`
 }
 
-type ImageURL struct {
-	URL string `json:"url"`
+// Logprobs holds the log probability of every generated token, in order.
+type Logprobs struct {
+	Content []TokenLogprob `json:"content"`
+	Refusal []TokenLogprob `json:"refusal,omitempty"`
 }
 
-type Content struct {
-	Type     string   `json:"type"`
-	Text     string   `json:"text,omitempty"`
-	ImageURL ImageURL `json:"image_url,omitempty"`
+type TokenLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+	// Bytes are the UTF-8 bytes of the token, which may be part of a rune.
+	Bytes       []int        `json:"bytes"`
+	TopLogprobs []TopLogprob `json:"top_logprobs"`
 }
 
-type Choice struct {
-	Index        int     `json:"index"`
-	Message      Message `json:"message"`
-	FinishReason string  `json:"finish_reason"`
+type TopLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+	Bytes   []int   `json:"bytes"`
 }
 
 type Usage struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-	TotalTokens      int `json:"total_tokens"`
+	PromptTokens            int                      `json:"prompt_tokens"`
+	CompletionTokens        int                      `json:"completion_tokens"`
+	TotalTokens             int                      `json:"total_tokens"`
+	PromptTokensDetails     *PromptTokensDetails     `json:"prompt_tokens_details,omitempty"`
+	CompletionTokensDetails *CompletionTokensDetails `json:"completion_tokens_details,omitempty"`
+}
+
+type PromptTokensDetails struct {
+	CachedTokens int `json:"cached_tokens"`
+	AudioTokens  int `json:"audio_tokens"`
+}
+
+type CompletionTokensDetails struct {
+	ReasoningTokens int `json:"reasoning_tokens"`
+	AudioTokens     int `json:"audio_tokens"`
+}
+
+// CachedTokens is the part of the prompt served from the prompt cache.
+func (u Usage) CachedTokens() int {
+	if u.PromptTokensDetails == nil {
+		return 0
+	}
+
+	return u.PromptTokensDetails.CachedTokens
 }
 
 type Function struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	Parameters  any    `json:"parameters"`
+	Strict      bool   `json:"strict,omitempty"`
 }
 
 type Tool struct {
@@ -59,81 +86,72 @@ type Param struct {
 	Enum        []string `json:"enum,omitempty"`
 }
 
-type CompletionRequest struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
-	N        int       `json:"n,omitempty"`
-	Stream   bool      `json:"stream,omitempty"`
-	User     string    `json:"user,omitempty"`
-	Tools    []Tool    `json:"tools"`
-}
-
-type ImageCompletionRequest struct {
-	Model    string         `json:"model"`
-	Messages []ImageMessage `json:"messages"`
-	N        int            `json:"n,omitempty"`
-	Stream   bool           `json:"stream,omitempty"`
-	User     string         `json:"user,omitempty"`
-	Tools    []Tool         `json:"tools"`
-}
-
-type CompletionResponse struct {
-	Id      string   `json:"id"`
-	Object  string   `json:"object"`
-	Created int64    `json:"created"`
-	Model   string   `json:"model"`
-	Choices []Choice `json:"choices"`
-	Usage   Usage    `json:"usage"`
-}
-
-type ModerationCategories struct {
-	Sexual                bool `json:"sexual"`
-	Hate                  bool `json:"hate"`
-	Harassment            bool `json:"harassment"`
-	SelfHarm              bool `json:"self-harm"`
-	SexualMinors          bool `json:"sexual/minors"`
-	HateThreatening       bool `json:"hate/threatening"`
-	ViolenceGraphic       bool `json:"violence/graphic"`
-	SelfHarmIntent        bool `json:"self-harm/intent"`
-	SelfHarmInstructions  bool `json:"self-harm/instructions"`
-	HarassmentThreatening bool `json:"harassment/threatening"`
-	Violence              bool `json:"violence"`
-}
-
-type ModerationCategoryScores struct {
-	Sexual                float64 `json:"sexual"`
-	Hate                  float64 `json:"hate"`
-	Harassment            float64 `json:"harassment"`
-	SelfHarm              float64 `json:"self-harm"`
-	SexualMinors          float64 `json:"sexual/minors"`
-	HateThreatening       float64 `json:"hate/threatening"`
-	ViolenceGraphic       float64 `json:"violence/graphic"`
-	SelfHarmIntent        float64 `json:"self-harm/intent"`
-	SelfHarmInstructions  float64 `json:"self-harm/instructions"`
-	HarassmentThreatening float64 `json:"harassment/threatening"`
-	Violence              float64 `json:"violence"`
-}
+const (
+	ReasoningEffortMinimal = "minimal"
+	ReasoningEffortLow     = "low"
+	ReasoningEffortMedium  = "medium"
+	ReasoningEffortHigh    = "high"
+)
 
-type ModerationResult struct {
-	Flagged        bool                     `json:"flagged"`
-	Categories     ModerationCategories     `json:"categories"`
-	CategoryScores ModerationCategoryScores `json:"category_scores"`
-}
+const (
+	ServiceTierAuto     = "auto"
+	ServiceTierDefault  = "default"
+	ServiceTierFlex     = "flex"
+	ServiceTierPriority = "priority"
+)
 
-type ModerationRequest struct {
-	Input string `json:"input"`
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
-type ModerationResponse struct {
-	Id      string             `json:"id"`
-	Model   string             `json:"model"`
-	Results []ModerationResult `json:"results"`
+// CompletionRequest leaves out what isn't set. Temperature, TopP and Seed
+// are pointers, as zero is a meaningful value for them; see Ptr. LogitBias
+// maps token ids, as strings, to a bias from -100 to 100. TopLogprobs, up to
+// 20 alternatives per token, needs Logprobs.
+type CompletionRequest struct {
+	Model               string          `json:"model"`
+	Messages            []Message       `json:"messages"`
+	N                   int             `json:"n,omitempty"`
+	Stream              bool            `json:"stream,omitempty"`
+	StreamOptions       *StreamOptions  `json:"stream_options,omitempty"`
+	User                string          `json:"user,omitempty"`
+	Tools               []Tool          `json:"tools,omitempty"`
+	ToolChoice          any             `json:"tool_choice,omitempty"`
+	ParallelToolCalls   *bool           `json:"parallel_tool_calls,omitempty"`
+	ResponseFormat      *ResponseFormat `json:"response_format,omitempty"`
+	Temperature         *float64        `json:"temperature,omitempty"`
+	TopP                *float64        `json:"top_p,omitempty"`
+	MaxCompletionTokens int             `json:"max_completion_tokens,omitempty"`
+	Stop                []string        `json:"stop,omitempty"`
+	Seed                *int            `json:"seed,omitempty"`
+	PresencePenalty     float64         `json:"presence_penalty,omitempty"`
+	FrequencyPenalty    float64         `json:"frequency_penalty,omitempty"`
+	LogitBias           map[string]int  `json:"logit_bias,omitempty"`
+	ReasoningEffort     string          `json:"reasoning_effort,omitempty"`
+	ServiceTier         string          `json:"service_tier,omitempty"`
+	Logprobs            bool            `json:"logprobs,omitempty"`
+	TopLogprobs         int             `json:"top_logprobs,omitempty"`
+}
+
+// CompletionResponse carries the SystemFingerprint of the backend that served
+// it; seeded requests are only reproducible while it stays the same.
+type CompletionResponse struct {
+	Id                string   `json:"id"`
+	Object            string   `json:"object"`
+	Created           int64    `json:"created"`
+	Model             string   `json:"model"`
+	SystemFingerprint string   `json:"system_fingerprint,omitempty"`
+	ServiceTier       string   `json:"service_tier,omitempty"`
+	Choices           []Choice `json:"choices"`
+	Usage             Usage    `json:"usage"`
 }
 
 type EmbeddingRequest struct {
-	Input          string `json:"input"`
+	Input          any    `json:"input"`
 	Model          string `json:"model"`
 	EncodingFormat string `json:"encoding_format"`
+	Dimensions     int    `json:"dimensions,omitempty"`
+	User           string `json:"user,omitempty"`
 }
 
 type EmbeddingData struct {
@@ -149,143 +167,35 @@ type EmbeddingResponse struct {
 	Usage  Usage           `json:"usage"`
 }
 
-type TranscriptionResponse struct {
-	Text string `json:"text"`
-}
-
-type OpenAI struct {
-	key string
-}
-
-func NewOpenAI(key string) *OpenAI {
-	return &OpenAI{
-		key: key,
-	}
+// Ptr returns a pointer to v, for optional fields such as Temperature.
+func Ptr[T any](v T) *T {
+	return &v
 }
 
-func (o *OpenAI) GetCompletion(request CompletionRequest) CompletionResponse {
-	url := "https://api.openai.com/v1/chat/completions"
-
-	postBody, _ := json.Marshal(request)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(postBody))
-	if err != nil {
-		log.Fatalf("Error occured %v", err)
-	}
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %v", o.key))
-	req.Header.Add("Content-Type", "application/json")
-	response, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Fatalf("Error occured %v", err)
+func (o *OpenAI) GetCompletion(ctx context.Context, request CompletionRequest) (CompletionResponse, error) {
+	if request.Stream {
+		return o.collectStream(ctx, request)
 	}
 
-	defer response.Body.Close()
-	if response.StatusCode >= 400 {
-		body, err := io.ReadAll(response.Body)
-		if err != nil {
-			log.Fatal("Coult not read response")
-		}
-		fmt.Println(string(body))
-	}
+	url := o.endpoint("/chat/completions")
 
 	var result CompletionResponse
-	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
-		log.Fatal("Can not unmarshall JSON")
-	}
-
-	return result
-}
-
-func (o *OpenAI) GetImageCompletion(request ImageCompletionRequest) CompletionResponse {
-	url := "https://api.openai.com/v1/chat/completions"
-
-	postBody, _ := json.Marshal(request)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(postBody))
-	if err != nil {
-		log.Fatalf("Error occured %v", err)
-	}
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %v", o.key))
-	req.Header.Add("Content-Type", "application/json")
-	response, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Fatalf("Error occured %v", err)
-	}
-
-	defer response.Body.Close()
-	if response.StatusCode >= 400 {
-		body, err := io.ReadAll(response.Body)
-		if err != nil {
-			log.Fatal("Coult not read response")
-		}
-		fmt.Println(string(body))
-	}
-
-	var result CompletionResponse
-	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
-		log.Fatal("Can not unmarshall JSON")
-	}
-
-	return result
-}
-
-func (o *OpenAI) GetImageCompletionShort(messages []ImageMessage, model string) CompletionResponse {
-	request := ImageCompletionRequest{
-		Model:    model,
-		Messages: messages,
-	}
+	err := o.postJSON(retry.Safe(ctx), url, request, &result, completionMeter(request))
 
-	return o.GetImageCompletion(request)
+	return result, err
 }
 
-func (o *OpenAI) GetCompletionShort(messages []Message, model string) CompletionResponse {
+func (o *OpenAI) GetCompletionShort(ctx context.Context, messages []Message, model string) (CompletionResponse, error) {
 	request := CompletionRequest{
 		Model:    model,
 		Messages: messages,
 	}
 
-	return o.GetCompletion(request)
-}
-
-func (o *OpenAI) GetModeration(input string) (bool, ModerationResponse) {
-	url := "https://api.openai.com/v1/moderations"
-
-	request := ModerationRequest{
-		Input: input,
-	}
-
-	postBody, _ := json.Marshal(request)
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(postBody))
-	if err != nil {
-		log.Fatalf("Error occured %v", err)
-	}
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %v", o.key))
-	req.Header.Add("Content-Type", "application/json")
-
-	response, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Fatalf("Error occured %v", err)
-	}
-
-	defer response.Body.Close()
-
-	var result ModerationResponse
-	err = json.NewDecoder(response.Body).Decode(&result)
-	if err != nil {
-		log.Fatal("Can not unmarshall JSON")
-	}
-
-	isFlagged := false
-	for _, result := range result.Results {
-		if result.Flagged {
-			isFlagged = true
-		}
-	}
-
-	return isFlagged, result
+	return o.GetCompletion(ctx, request)
 }
 
-func (o *OpenAI) GetEmbedding(input string, model string) []float64 {
-	url := "https://api.openai.com/v1/embeddings"
+func (o *OpenAI) GetEmbedding(ctx context.Context, input string, model string) ([]float64, error) {
+	url := o.endpoint("/embeddings")
 
 	request := EmbeddingRequest{
 		Input:          input,
@@ -293,133 +203,14 @@ func (o *OpenAI) GetEmbedding(input string, model string) []float64 {
 		EncodingFormat: "float",
 	}
 
-	postBody, _ := json.Marshal(request)
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(postBody))
-	if err != nil {
-		log.Fatalf("Error occured %v", err)
-	}
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %v", o.key))
-	req.Header.Add("Content-Type", "application/json")
-
-	response, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Fatalf("Error occured %v", err)
-	}
-
-	defer response.Body.Close()
-
 	var result EmbeddingResponse
-	err = json.NewDecoder(response.Body).Decode(&result)
-	if err != nil {
-		log.Fatal("Can not unmarshall JSON")
+	if err := o.postJSON(retry.Safe(ctx), url, request, &result, &meter{model: model, tokens: tokenCounter(model)(input)}); err != nil {
+		return nil, err
 	}
 
 	if len(result.Data) == 0 {
-		log.Fatal("No embeddings returned")
-	}
-
-	return result.Data[0].Embedding
-}
-
-func (o *OpenAI) GetTranscription(file []byte, model string, format string) string {
-	url := "https://api.openai.com/v1/audio/transcriptions"
-
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-	filePart, _ := writer.CreateFormFile("file", "file."+format)
-	filePart.Write(file)
-	writer.WriteField("model", model)
-	writer.Close()
-
-	req, err := http.NewRequest("POST", url, body)
-	if err != nil {
-		log.Fatalf("Error occured %v", err)
-	}
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %v", o.key))
-	req.Header.Add("Content-Type", writer.FormDataContentType())
-
-	response, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Fatalf("Error occured %v", err)
-	}
-
-	if response.StatusCode >= 400 {
-		responseBody, err := io.ReadAll(response.Body)
-		if err != nil {
-			panic(err)
-		}
-		fmt.Println(string(responseBody))
-	}
-
-	defer response.Body.Close()
-
-	var result TranscriptionResponse
-	err = json.NewDecoder(response.Body).Decode(&result)
-	if err != nil {
-		log.Fatal("Can not unmarshall JSON")
-	}
-
-	return result.Text
-}
-
-type CreateImageRequest struct {
-	Model          string `json:"model,omitempty"`
-	Prompt         string `json:"prompt"`
-	N              int    `json:"n,omitempty"`
-	Quality        string `json:"quality,omitempty"`
-	ResponseFormat string `json:"response_format,omitempty"`
-	Size           string `json:"size,omitempty"`
-}
-
-type ImageResult struct {
-	Url string `json:"url"`
-}
-
-type CreateImageResponse struct {
-	Created int           `json:"created"`
-	Data    []ImageResult `json:"data"`
-}
-
-func (o *OpenAI) CreateImageShort(prompt string) *CreateImageResponse {
-	request := CreateImageRequest{
-		Model:  "dall-e-3",
-		Prompt: prompt,
-		N:      1,
-		Size:   "1024x1024",
-	}
-
-	return o.CreateImage(request)
-}
-
-func (o *OpenAI) CreateImage(request CreateImageRequest) *CreateImageResponse {
-	url := "https://api.openai.com/v1/images/generations"
-
-	postBody, _ := json.Marshal(request)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(postBody))
-	if err != nil {
-		log.Fatalf("Error occured %v", err)
-	}
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %v", o.key))
-	req.Header.Add("Content-Type", "application/json")
-	response, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Fatalf("Error occured %v", err)
-	}
-
-	defer response.Body.Close()
-	if response.StatusCode >= 400 {
-		body, err := io.ReadAll(response.Body)
-		if err != nil {
-			log.Fatal("Coult not read response")
-		}
-		fmt.Println(string(body))
-	}
-
-	var result *CreateImageResponse
-	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
-		log.Fatal("Can not unmarshall JSON")
+		return nil, errors.New("no embeddings returned")
 	}
 
-	return result
+	return result.Data[0].Embedding, nil
 }
@@ -0,0 +1,275 @@
+package openaitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"woyteck.pl/ai_devs3/internal/openai"
+)
+
+// DefaultContent answers chat completions that no script or rule handles.
+const DefaultContent = "OK"
+
+// ChatReply is how the server answers a chat completion. With Err set it
+// answers with that API error instead; with StreamErr set a streamed reply
+// breaks off with that error event after its content. FinishReason defaults
+// to tool_calls when there are ToolCalls and to stop otherwise.
+type ChatReply struct {
+	Content      string
+	ToolCalls    []openai.ToolCall
+	Refusal      string
+	FinishReason string
+	Logprobs     *openai.Logprobs
+	Err          *openai.APIError
+	StreamErr    *openai.APIError
+}
+
+type chatRule struct {
+	match func(openai.CompletionRequest) bool
+	reply func(openai.CompletionRequest) ChatReply
+}
+
+// ScriptChat queues replies for the next chat completions. They are served
+// in order, before any rule.
+func (s *Server) ScriptChat(replies ...ChatReply) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.chatScript = append(s.chatScript, replies...)
+}
+
+// OnChat answers the chat completions that match with reply. Rules are
+// tried in the order they were added; a nil match matches everything.
+func (s *Server) OnChat(match func(openai.CompletionRequest) bool, reply func(openai.CompletionRequest) ChatReply) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.chatRules = append(s.chatRules, chatRule{match: match, reply: reply})
+}
+
+// Contains matches requests where any message contains text.
+func Contains(text string) func(openai.CompletionRequest) bool {
+	return func(request openai.CompletionRequest) bool {
+		for _, message := range request.Messages {
+			if strings.Contains(message.Text(), text) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// Model matches requests for model.
+func Model(model string) func(openai.CompletionRequest) bool {
+	return func(request openai.CompletionRequest) bool {
+		return request.Model == model
+	}
+}
+
+// Reply answers every request with reply.
+func Reply(reply ChatReply) func(openai.CompletionRequest) ChatReply {
+	return func(openai.CompletionRequest) ChatReply {
+		return reply
+	}
+}
+
+// Call builds a tool call of function name with arguments marshalled to
+// JSON.
+func Call(id string, name string, arguments any) openai.ToolCall {
+	encoded, err := json.Marshal(arguments)
+	if err != nil {
+		panic(fmt.Sprintf("openaitest: can not marshal arguments: %v", err))
+	}
+
+	return openai.ToolCall{
+		Id:       id,
+		Type:     "function",
+		Function: openai.FunctionCall{Name: name, Arguments: string(encoded)},
+	}
+}
+
+// ChatRequests returns the chat completion requests received so far.
+func (s *Server) ChatRequests() []openai.CompletionRequest {
+	requests := []openai.CompletionRequest{}
+	for _, received := range s.RequestsTo("/chat/completions") {
+		var request openai.CompletionRequest
+		if err := received.Decode(&request); err == nil {
+			requests = append(requests, request)
+		}
+	}
+
+	return requests
+}
+
+func (s *Server) chatReply(request openai.CompletionRequest) ChatReply {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.chatScript) > 0 {
+		reply := s.chatScript[0]
+		s.chatScript = s.chatScript[1:]
+		return reply
+	}
+	for _, rule := range s.chatRules {
+		if rule.match == nil || rule.match(request) {
+			return rule.reply(request)
+		}
+	}
+
+	return ChatReply{Content: DefaultContent}
+}
+
+func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
+	var request openai.CompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, &openai.APIError{StatusCode: http.StatusBadRequest, Type: "invalid_request_error", Message: err.Error()})
+		return
+	}
+
+	reply := s.chatReply(request)
+	if reply.Err != nil {
+		writeError(w, reply.Err)
+		return
+	}
+
+	response := s.completion(request, reply)
+	if request.Stream {
+		streamCompletion(w, request, response, reply.StreamErr)
+		return
+	}
+
+	writeJSON(w, response)
+}
+
+func (s *Server) completion(request openai.CompletionRequest, reply ChatReply) openai.CompletionResponse {
+	s.mu.Lock()
+	s.completionSeq++
+	id := fmt.Sprintf("chatcmpl-test%d", s.completionSeq)
+	s.mu.Unlock()
+
+	finishReason := reply.FinishReason
+	if finishReason == "" {
+		finishReason = openai.FinishReasonStop
+		if len(reply.ToolCalls) > 0 {
+			finishReason = openai.FinishReasonToolCalls
+		}
+	}
+
+	response := openai.CompletionResponse{
+		Id:                id,
+		Object:            "chat.completion",
+		Created:           time.Now().Unix(),
+		Model:             request.Model,
+		SystemFingerprint: "fp_openaitest",
+	}
+	completionTokens := countTokens(request.Model, reply.Content+reply.Refusal)
+	for _, call := range reply.ToolCalls {
+		completionTokens += countTokens(request.Model, call.Function.Name+call.Function.Arguments)
+	}
+	for i := range max(request.N, 1) {
+		response.Choices = append(response.Choices, openai.Choice{
+			Index: i,
+			Message: openai.Message{
+				Role:      "assistant",
+				Content:   reply.Content,
+				Refusal:   reply.Refusal,
+				ToolCalls: reply.ToolCalls,
+			},
+			FinishReason: finishReason,
+			Logprobs:     reply.Logprobs,
+		})
+	}
+
+	promptTokens, err := openai.CountTokens(request.Model, request.Messages)
+	if err != nil {
+		for _, message := range request.Messages {
+			promptTokens += countTokens(request.Model, message.Text())
+		}
+	}
+	response.Usage = openai.Usage{
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens * len(response.Choices),
+		TotalTokens:      promptTokens + completionTokens*len(response.Choices),
+	}
+
+	return response
+}
+
+// streamCompletion sends response as server-sent events: content word by
+// word and tool call arguments in two halves, like the API does in pieces.
+// With streamErr set the stream ends with it instead of a finish reason.
+func streamCompletion(w http.ResponseWriter, request openai.CompletionRequest, response openai.CompletionResponse, streamErr *openai.APIError) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+
+	send := func(choices []openai.ChunkChoice, usage *openai.Usage) {
+		data, _ := json.Marshal(openai.CompletionChunk{
+			Id:                response.Id,
+			Object:            "chat.completion.chunk",
+			Created:           response.Created,
+			Model:             response.Model,
+			SystemFingerprint: response.SystemFingerprint,
+			Choices:           choices,
+			Usage:             usage,
+		})
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	delta := func(index int, delta openai.Delta) {
+		send([]openai.ChunkChoice{{Index: index, Delta: delta}}, nil)
+	}
+
+	for _, choice := range response.Choices {
+		message := choice.Message
+		delta(choice.Index, openai.Delta{Role: "assistant"})
+		if message.Content != "" {
+			for _, piece := range strings.SplitAfter(message.Content, " ") {
+				delta(choice.Index, openai.Delta{Content: piece})
+			}
+		}
+		if message.Refusal != "" {
+			delta(choice.Index, openai.Delta{Refusal: message.Refusal})
+		}
+		for i, call := range message.ToolCalls {
+			delta(choice.Index, openai.Delta{ToolCalls: []openai.ToolCallDelta{{
+				Index:    i,
+				Id:       call.Id,
+				Type:     call.Type,
+				Function: openai.FunctionCall{Name: call.Function.Name},
+			}}})
+			arguments := call.Function.Arguments
+			half := len(arguments) / 2
+			for half > 0 && !utf8.RuneStart(arguments[half]) {
+				half--
+			}
+			for _, piece := range []string{arguments[:half], arguments[half:]} {
+				delta(choice.Index, openai.Delta{ToolCalls: []openai.ToolCallDelta{{
+					Index:    i,
+					Function: openai.FunctionCall{Arguments: piece},
+				}}})
+			}
+		}
+		if streamErr != nil {
+			data, _ := json.Marshal(errorBody(streamErr))
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			return
+		}
+		send([]openai.ChunkChoice{{
+			Index:        choice.Index,
+			FinishReason: choice.FinishReason,
+			Logprobs:     choice.Logprobs,
+		}}, nil)
+	}
+
+	if request.StreamOptions != nil && request.StreamOptions.IncludeUsage {
+		send([]openai.ChunkChoice{}, &response.Usage)
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
@@ -0,0 +1,334 @@
+package openaitest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"woyteck.pl/ai_devs3/internal/openai"
+)
+
+// DefaultDimensions is the length of embeddings when the request doesn't
+// ask for one.
+const DefaultDimensions = 8
+
+// secondsPerWord paces the timestamps of fake transcriptions.
+const secondsPerWord = 0.5
+
+// OnEmbedding replaces the default embeddings, unit vectors derived from a
+// hash of the input: equal inputs get equal vectors, but similar ones are
+// not close.
+func (s *Server) OnEmbedding(embed func(input string, dimensions int) []float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.embedding = embed
+}
+
+// OnModeration decides the moderation result of every input. By default
+// nothing is flagged.
+func (s *Server) OnModeration(moderate func(input string) openai.ModerationResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.moderation = moderate
+}
+
+// OnTranscription returns the text of an uploaded audio file. By default
+// it is "transcription of <file name>".
+func (s *Server) OnTranscription(transcribe func(file File, form url.Values) string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.transcription = transcribe
+}
+
+// OnImage returns the image generated for prompt, which is empty for
+// variations. By default it is a 1x1 PNG.
+func (s *Server) OnImage(generate func(prompt string) []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.image = generate
+}
+
+// Flagged is a moderation result with categories flagged at a score of 0.99.
+func Flagged(categories ...string) openai.ModerationResult {
+	result := notFlagged()
+	for _, category := range categories {
+		result.Flagged = true
+		result.Categories[category] = true
+		result.CategoryScores[category] = 0.99
+	}
+
+	return result
+}
+
+func notFlagged() openai.ModerationResult {
+	result := openai.ModerationResult{
+		Categories:     map[string]bool{},
+		CategoryScores: map[string]float64{},
+	}
+	for _, category := range []string{
+		openai.ModerationSexual, openai.ModerationSexualMinors,
+		openai.ModerationHarassment, openai.ModerationHarassmentThreatening,
+		openai.ModerationHate, openai.ModerationHateThreatening,
+		openai.ModerationIllicit, openai.ModerationIllicitViolent,
+		openai.ModerationSelfHarm, openai.ModerationSelfHarmIntent, openai.ModerationSelfHarmInstructions,
+		openai.ModerationViolence, openai.ModerationViolenceGraphic,
+	} {
+		result.Categories[category] = false
+		result.CategoryScores[category] = 0
+	}
+
+	return result
+}
+
+// HashEmbedding is the default embedding.
+func HashEmbedding(input string, dimensions int) []float64 {
+	vector := make([]float64, dimensions)
+	norm := 0.0
+	for i := range vector {
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%d:%s", i, input)))
+		vector[i] = float64(binary.BigEndian.Uint32(sum[:4]))/math.MaxUint32*2 - 1
+		norm += vector[i] * vector[i]
+	}
+	for i := range vector {
+		vector[i] /= math.Sqrt(norm)
+	}
+
+	return vector
+}
+
+// textInputs reads an input that is a string or a list of strings.
+func textInputs(raw json.RawMessage) ([]string, error) {
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return []string{single}, nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(raw, &many); err != nil {
+		return nil, fmt.Errorf("input must be a string or a list of strings")
+	}
+
+	return many, nil
+}
+
+func (s *Server) handleEmbeddings(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Input      json.RawMessage `json:"input"`
+		Model      string          `json:"model"`
+		Dimensions int             `json:"dimensions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, &openai.APIError{StatusCode: http.StatusBadRequest, Type: "invalid_request_error", Message: err.Error()})
+		return
+	}
+	inputs, err := textInputs(request.Input)
+	if err != nil {
+		writeError(w, &openai.APIError{StatusCode: http.StatusBadRequest, Type: "invalid_request_error", Message: err.Error()})
+		return
+	}
+
+	dimensions := request.Dimensions
+	if dimensions <= 0 {
+		dimensions = DefaultDimensions
+	}
+	s.mu.Lock()
+	embed := s.embedding
+	s.mu.Unlock()
+	if embed == nil {
+		embed = HashEmbedding
+	}
+
+	response := openai.EmbeddingResponse{Object: "list", Model: request.Model}
+	for i, input := range inputs {
+		response.Data = append(response.Data, openai.EmbeddingData{
+			Object:    "embedding",
+			Embedding: embed(input, dimensions),
+			Index:     i,
+		})
+		response.Usage.PromptTokens += countTokens(request.Model, input)
+	}
+	response.Usage.TotalTokens = response.Usage.PromptTokens
+
+	writeJSON(w, response)
+}
+
+func (s *Server) handleModerations(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Input json.RawMessage `json:"input"`
+		Model string          `json:"model"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, &openai.APIError{StatusCode: http.StatusBadRequest, Type: "invalid_request_error", Message: err.Error()})
+		return
+	}
+
+	inputs, err := textInputs(request.Input)
+	if err != nil {
+		// Text and image parts are moderated together as one input.
+		var parts []openai.ContentPart
+		if err := json.Unmarshal(request.Input, &parts); err != nil {
+			writeError(w, &openai.APIError{StatusCode: http.StatusBadRequest, Type: "invalid_request_error", Message: err.Error()})
+			return
+		}
+		texts := []string{}
+		for _, part := range parts {
+			switch {
+			case part.ImageURL != nil:
+				texts = append(texts, part.ImageURL.URL)
+			default:
+				texts = append(texts, part.Text)
+			}
+		}
+		inputs = []string{strings.Join(texts, "\n")}
+	}
+
+	s.mu.Lock()
+	moderate := s.moderation
+	s.mu.Unlock()
+
+	response := openai.ModerationResponse{Id: "modr-test", Model: request.Model}
+	for _, input := range inputs {
+		result := notFlagged()
+		if moderate != nil {
+			result = moderate(input)
+		}
+		response.Results = append(response.Results, result)
+	}
+
+	writeJSON(w, response)
+}
+
+func (s *Server) handleTranscription(w http.ResponseWriter, r *http.Request) {
+	request := received(r)
+	file, ok := request.Files["file"]
+	if !ok {
+		writeError(w, &openai.APIError{StatusCode: http.StatusBadRequest, Type: "invalid_request_error", Message: "no file uploaded"})
+		return
+	}
+
+	s.mu.Lock()
+	transcribe := s.transcription
+	s.mu.Unlock()
+	text := "transcription of " + file.Name
+	if transcribe != nil {
+		text = transcribe(file, request.Form)
+	}
+
+	duration := float64(len(words(text))) * secondsPerWord
+	switch request.Form.Get("response_format") {
+	case openai.TranscriptionFormatText:
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintln(w, text)
+	case openai.TranscriptionFormatSRT:
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "1\n00:00:00,000 --> %s\n%s\n\n", subtitleTime(duration, ","), text)
+	case openai.TranscriptionFormatVTT:
+		w.Header().Set("Content-Type", "text/vtt")
+		fmt.Fprintf(w, "WEBVTT\n\n00:00:00.000 --> %s\n%s\n\n", subtitleTime(duration, "."), text)
+	case openai.TranscriptionFormatVerboseJSON:
+		response := openai.TranscriptionResponse{
+			Text:     text,
+			Language: request.Form.Get("language"),
+			Duration: duration,
+			Segments: []openai.TranscriptionSegment{{Text: text, End: duration}},
+		}
+		for _, granularity := range request.Form["timestamp_granularities[]"] {
+			if granularity != openai.TimestampGranularityWord {
+				continue
+			}
+			for i, word := range words(text) {
+				start := float64(i) * secondsPerWord
+				response.Words = append(response.Words, openai.TranscriptionWord{Word: word, Start: start, End: start + secondsPerWord})
+			}
+		}
+		writeJSON(w, response)
+	default:
+		writeJSON(w, map[string]string{"text": text})
+	}
+}
+
+func subtitleTime(seconds float64, separator string) string {
+	duration := time.Duration(seconds * float64(time.Second))
+
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d",
+		int(duration.Hours()), int(duration.Minutes())%60, int(duration.Seconds())%60,
+		separator, duration.Milliseconds()%1000)
+}
+
+func (s *Server) handleImages(w http.ResponseWriter, r *http.Request) {
+	var request openai.CreateImageRequest
+	if form := received(r).Form; form != nil {
+		request.Prompt = form.Get("prompt")
+		request.Model = form.Get("model")
+		request.ResponseFormat = form.Get("response_format")
+		request.N, _ = strconv.Atoi(form.Get("n"))
+	} else if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, &openai.APIError{StatusCode: http.StatusBadRequest, Type: "invalid_request_error", Message: err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	generate := s.image
+	s.mu.Unlock()
+	if generate == nil {
+		generate = func(string) []byte { return pixel }
+	}
+
+	response := openai.CreateImageResponse{Created: int(time.Now().Unix())}
+	for range max(request.N, 1) {
+		data := generate(request.Prompt)
+		result := openai.ImageResult{}
+		if request.Model == "dall-e-3" {
+			result.RevisedPrompt = request.Prompt
+		}
+		if request.ResponseFormat == openai.ImageFormatB64JSON {
+			result.B64JSON = base64.StdEncoding.EncodeToString(data)
+		} else {
+			s.mu.Lock()
+			s.imageCount++
+			name := fmt.Sprintf("img-%d.png", s.imageCount)
+			s.images[name] = data
+			s.mu.Unlock()
+			result.Url = s.URL + "/files/images/" + name
+		}
+		response.Data = append(response.Data, result)
+	}
+
+	writeJSON(w, response)
+}
+
+func (s *Server) handleImageFile(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	data, ok := s.images[r.PathValue("name")]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", http.DetectContentType(data))
+	w.Write(data)
+}
+
+// pixel is a transparent 1x1 PNG.
+var pixel = func() []byte {
+	data := &bytes.Buffer{}
+	png.Encode(data, image.NewNRGBA(image.Rect(0, 0, 1, 1)))
+
+	return data.Bytes()
+}()
@@ -0,0 +1,219 @@
+// Package openaitest runs a fake OpenAI API in process, so code built on the
+// openai package can be tested without a network. Replies come from scripts
+// and rules set per endpoint, with deterministic defaults, and every request
+// is kept for assertions.
+package openaitest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+
+	"woyteck.pl/ai_devs3/internal/openai"
+	"woyteck.pl/ai_devs3/internal/retry"
+	"woyteck.pl/ai_devs3/internal/tokenizer"
+)
+
+// Request is a request the server received. Form and Files are set for
+// multipart uploads.
+type Request struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   []byte
+	Form   url.Values
+	Files  map[string]File
+}
+
+type File struct {
+	Name string
+	Data []byte
+}
+
+// Decode unmarshals the JSON body into v, e.g. an openai.CompletionRequest.
+func (r Request) Decode(v any) error {
+	return json.Unmarshal(r.Body, v)
+}
+
+type Server struct {
+	*httptest.Server
+
+	mu            sync.Mutex
+	requests      []Request
+	chatScript    []ChatReply
+	chatRules     []chatRule
+	embedding     func(input string, dimensions int) []float64
+	moderation    func(input string) openai.ModerationResult
+	transcription func(file File, form url.Values) string
+	image         func(prompt string) []byte
+	images        map[string][]byte
+	imageCount    int
+	completionSeq int
+}
+
+// NewServer starts a server; Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		images: map[string][]byte{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /chat/completions", s.handleChat)
+	mux.HandleFunc("POST /embeddings", s.handleEmbeddings)
+	mux.HandleFunc("POST /moderations", s.handleModerations)
+	mux.HandleFunc("POST /audio/transcriptions", s.handleTranscription)
+	mux.HandleFunc("POST /images/generations", s.handleImages)
+	mux.HandleFunc("POST /images/edits", s.handleImages)
+	mux.HandleFunc("POST /images/variations", s.handleImages)
+	mux.HandleFunc("GET /files/images/{name}", s.handleImageFile)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, &openai.APIError{StatusCode: http.StatusNotFound, Type: "invalid_request_error", Message: "unknown endpoint " + r.URL.Path})
+	})
+	s.Server = httptest.NewServer(s.record(mux))
+
+	return s
+}
+
+// Client returns an openai client pointed at the server, without retries.
+func (s *Server) Client(opts ...openai.Option) *openai.OpenAI {
+	defaults := []openai.Option{
+		openai.WithBaseURL(s.URL),
+		openai.WithRetryPolicy(retry.NoRetry()),
+	}
+
+	return openai.NewOpenAI("test-key", append(defaults, opts...)...)
+}
+
+// Requests returns the requests received so far, in order.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Request{}, s.requests...)
+}
+
+// RequestsTo returns the requests received for path, e.g. "/embeddings".
+func (s *Server) RequestsTo(path string) []Request {
+	requests := []Request{}
+	for _, request := range s.Requests() {
+		if request.Path == path {
+			requests = append(requests, request)
+		}
+	}
+
+	return requests
+}
+
+func (s *Server) record(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, &openai.APIError{StatusCode: http.StatusBadRequest, Message: err.Error()})
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		request := Request{
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Header: r.Header.Clone(),
+			Body:   body,
+		}
+		if form, files, ok := parseMultipart(r.Header.Get("Content-Type"), body); ok {
+			request.Form = form
+			request.Files = files
+		}
+
+		s.mu.Lock()
+		s.requests = append(s.requests, request)
+		s.mu.Unlock()
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestKey{}, request)))
+	})
+}
+
+type requestKey struct{}
+
+// received returns the recorded form of r.
+func received(r *http.Request) Request {
+	request, _ := r.Context().Value(requestKey{}).(Request)
+
+	return request
+}
+
+func parseMultipart(contentType string, body []byte) (url.Values, map[string]File, bool) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/form-data" {
+		return nil, nil, false
+	}
+
+	form := url.Values{}
+	files := map[string]File{}
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			break
+		}
+		if part.FileName() != "" {
+			files[part.FormName()] = File{Name: part.FileName(), Data: data}
+			continue
+		}
+		form.Add(part.FormName(), string(data))
+	}
+
+	return form, files, true
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError answers in the API's error format.
+func writeError(w http.ResponseWriter, apiErr *openai.APIError) {
+	status := apiErr.StatusCode
+	if status == 0 {
+		status = http.StatusBadRequest
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorBody(apiErr))
+}
+
+func errorBody(apiErr *openai.APIError) map[string]any {
+	return map[string]any{
+		"error": map[string]any{
+			"message": apiErr.Message,
+			"type":    apiErr.Type,
+			"code":    apiErr.Code,
+			"param":   apiErr.Param,
+		},
+	}
+}
+
+// countTokens counts like the real API where the model's encoding is known.
+func countTokens(model string, text string) int {
+	encoding, err := tokenizer.ForModel(model)
+	if err != nil {
+		return (len(text) + 3) / 4
+	}
+
+	return encoding.Count(text)
+}
+
+func words(text string) []string {
+	return strings.Fields(text)
+}
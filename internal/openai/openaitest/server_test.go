@@ -0,0 +1,311 @@
+package openaitest_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"testing"
+
+	"woyteck.pl/ai_devs3/internal/openai"
+	"woyteck.pl/ai_devs3/internal/openai/openaitest"
+)
+
+func TestChat(t *testing.T) {
+	server := openaitest.NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	server.OnChat(openaitest.Contains("weather"), openaitest.Reply(openaitest.ChatReply{Content: "Sunny."}))
+
+	response, err := client.GetCompletionShort(context.Background(), []openai.Message{
+		{Role: "user", Content: "What's the weather?"},
+	}, "gpt-4o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := response.Choices[0].Message.Content; got != "Sunny." {
+		t.Errorf("content = %q, want %q", got, "Sunny.")
+	}
+	if response.Usage.PromptTokens == 0 || response.Usage.CompletionTokens == 0 {
+		t.Errorf("usage not counted: %+v", response.Usage)
+	}
+
+	response, err = client.GetCompletionShort(context.Background(), []openai.Message{
+		{Role: "user", Content: "Hello"},
+	}, "gpt-4o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := response.Choices[0].Message.Content; got != openaitest.DefaultContent {
+		t.Errorf("unmatched request answered %q, want %q", got, openaitest.DefaultContent)
+	}
+
+	requests := server.ChatRequests()
+	if len(requests) != 2 || requests[0].Model != "gpt-4o" {
+		t.Errorf("recorded requests = %+v", requests)
+	}
+}
+
+func TestChatError(t *testing.T) {
+	server := openaitest.NewServer()
+	defer server.Close()
+
+	server.ScriptChat(openaitest.ChatReply{Err: &openai.APIError{StatusCode: http.StatusBadRequest, Type: "invalid_request_error", Message: "bad request"}})
+
+	_, err := server.Client().GetCompletionShort(context.Background(), nil, "gpt-4o")
+	var apiErr *openai.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest || apiErr.Message != "bad request" {
+		t.Fatalf("err = %v, want the scripted API error", err)
+	}
+}
+
+func TestStreamToolCall(t *testing.T) {
+	server := openaitest.NewServer()
+	defer server.Close()
+
+	// The arguments are not ASCII, so splitting them must respect runes.
+	call := openaitest.Call("call_1", "find_city", map[string]string{"name": "Zażółć gęślą jaźń"})
+	server.ScriptChat(openaitest.ChatReply{Content: "Looking it up", ToolCalls: []openai.ToolCall{call}})
+
+	stream, err := server.Client().GetCompletionStream(context.Background(), openai.CompletionRequest{
+		Model:         "gpt-4o",
+		Messages:      []openai.Message{{Role: "user", Content: "Where?"}},
+		StreamOptions: &openai.StreamOptions{IncludeUsage: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	chunks := 0
+	for {
+		if _, err := stream.Recv(); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			t.Fatal(err)
+		}
+		chunks++
+	}
+	if chunks < 5 {
+		t.Errorf("got %d chunks, want the reply in pieces", chunks)
+	}
+
+	response := stream.Response()
+	choice := response.Choices[0]
+	if choice.Message.Content != "Looking it up" {
+		t.Errorf("content = %q", choice.Message.Content)
+	}
+	if choice.FinishReason != openai.FinishReasonToolCalls {
+		t.Errorf("finish reason = %q, want %q", choice.FinishReason, openai.FinishReasonToolCalls)
+	}
+	if len(choice.Message.ToolCalls) != 1 || choice.Message.ToolCalls[0] != call {
+		t.Errorf("tool calls = %+v, want %+v", choice.Message.ToolCalls, call)
+	}
+	if response.Usage.TotalTokens == 0 {
+		t.Error("no usage chunk")
+	}
+}
+
+func TestStreamError(t *testing.T) {
+	server := openaitest.NewServer()
+	defer server.Close()
+
+	costs := openai.NewCostTracker(openai.DefaultPrices())
+	server.ScriptChat(openaitest.ChatReply{
+		Content:   "Partial answer that never ends",
+		StreamErr: &openai.APIError{Type: "server_error", Message: "overloaded"},
+	})
+
+	stream, err := server.Client(openai.WithCostTracker(costs)).GetCompletionStream(context.Background(), openai.CompletionRequest{
+		Model:    "gpt-4o",
+		Messages: []openai.Message{{Role: "user", Content: "Go on"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for err == nil {
+		_, err = stream.Recv()
+	}
+	var apiErr *openai.APIError
+	if !errors.As(err, &apiErr) || apiErr.Message != "overloaded" {
+		t.Fatalf("err = %v, want the error event", err)
+	}
+	if _, again := stream.Recv(); again != err {
+		t.Errorf("Recv after the error returned %v, want %v", again, err)
+	}
+	stream.Close()
+
+	total := costs.Summary().Total
+	if total.Calls != 1 || total.PromptTokens == 0 || total.CompletionTokens == 0 {
+		t.Errorf("streamed tokens tracked as %+v, want one call with its tokens", total)
+	}
+}
+
+func TestStreamClosedEarly(t *testing.T) {
+	server := openaitest.NewServer()
+	defer server.Close()
+
+	costs := openai.NewCostTracker(openai.DefaultPrices())
+	server.ScriptChat(openaitest.ChatReply{Content: "one two three four five"})
+
+	stream, err := server.Client(openai.WithCostTracker(costs)).GetCompletionStream(context.Background(), openai.CompletionRequest{
+		Model:    "gpt-4o",
+		Messages: []openai.Message{{Role: "user", Content: "Count"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if _, err := stream.Recv(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stream.Close()
+	stream.Close()
+
+	total := costs.Summary().Total
+	if total.Calls != 1 || total.PromptTokens == 0 || total.CompletionTokens == 0 {
+		t.Errorf("dropped stream tracked as %+v, want one call with its tokens", total)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	server := openaitest.NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	server.ScriptChat(
+		openaitest.ChatReply{
+			Content: `{"label":"spam"}`,
+			Logprobs: &openai.Logprobs{Content: []openai.TokenLogprob{
+				{Token: `{"label":"`},
+				{Token: "spam", Logprob: math.Log(0.75), TopLogprobs: []openai.TopLogprob{{Token: "ham", Logprob: math.Log(0.25)}}},
+				{Token: `"}`},
+			}},
+		},
+		openaitest.ChatReply{Content: `{"label":"eggs"}`},
+	)
+
+	request := openai.CompletionRequest{Model: "gpt-4o", Messages: []openai.Message{{Role: "user", Content: "Buy now!"}}}
+	result, err := client.Classify(context.Background(), request, []string{"spam", "ham"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Label != "spam" || math.Abs(result.Probability-0.75) > 1e-9 || math.Abs(result.Scores["ham"]-0.25) > 1e-9 {
+		t.Errorf("result = %+v", result)
+	}
+
+	var sent struct {
+		ResponseFormat openai.ResponseFormat `json:"response_format"`
+	}
+	if err := json.Unmarshal(server.RequestsTo("/chat/completions")[0].Body, &sent); err != nil {
+		t.Fatal(err)
+	}
+	schema, _ := json.Marshal(sent.ResponseFormat.JSONSchema.Schema)
+	want := `{"additionalProperties":false,"properties":{"label":{"enum":["spam","ham"],"type":"string"}},"required":["label"],"type":"object"}`
+	if !sent.ResponseFormat.JSONSchema.Strict || string(schema) != want {
+		t.Errorf("schema = %s, want %s", schema, want)
+	}
+
+	_, err = client.Classify(context.Background(), request, []string{"spam", "ham"})
+	var schemaErr *openai.SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Errorf("label outside the labels: err = %v, want a SchemaError", err)
+	}
+}
+
+func TestTranscriptionCosts(t *testing.T) {
+	server := openaitest.NewServer()
+	defer server.Close()
+
+	for _, format := range []string{"", openai.TranscriptionFormatJSON, openai.TranscriptionFormatText} {
+		costs := openai.NewCostTracker(openai.DefaultPrices())
+		response, err := server.Client(openai.WithCostTracker(costs)).Transcribe(context.Background(), openai.TranscriptionRequest{
+			File:           []byte("audio"),
+			FileName:       "a.mp3",
+			Model:          "whisper-1",
+			ResponseFormat: format,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.Text != "transcription of a.mp3" || response.Segments != nil {
+			t.Errorf("%q: response = %+v, want only the text", format, response)
+		}
+
+		requests := server.RequestsTo("/audio/transcriptions")
+		if got := requests[len(requests)-1].Form.Get("response_format"); got != openai.TranscriptionFormatVerboseJSON {
+			t.Errorf("%q: asked for %q, want verbose_json to learn the duration", format, got)
+		}
+		if total := costs.Summary().Total; total.AudioSeconds == 0 || total.USD == 0 || total.Unpriced != 0 {
+			t.Errorf("%q: tracked %+v, want the priced duration", format, total)
+		}
+	}
+
+	// Without a tracker the requested format is sent as is.
+	if _, err := server.Client().Transcribe(context.Background(), openai.TranscriptionRequest{File: []byte("audio"), FileName: "a.mp3", Model: "whisper-1"}); err != nil {
+		t.Fatal(err)
+	}
+	requests := server.RequestsTo("/audio/transcriptions")
+	if got := requests[len(requests)-1].Form.Get("response_format"); got != "" {
+		t.Errorf("untracked call asked for %q", got)
+	}
+
+	// Other models report no duration in json, so the call can't be priced.
+	costs := openai.NewCostTracker(openai.DefaultPrices())
+	if _, err := server.Client(openai.WithCostTracker(costs)).Transcribe(context.Background(), openai.TranscriptionRequest{File: []byte("audio"), FileName: "a.mp3", Model: "gpt-4o-transcribe"}); err != nil {
+		t.Fatal(err)
+	}
+	if total := costs.Summary().Total; total.Calls != 1 || total.Unpriced != 1 {
+		t.Errorf("call without a duration tracked as %+v, want it unpriced", total)
+	}
+}
+
+func TestEmbeddings(t *testing.T) {
+	server := openaitest.NewServer()
+	defer server.Close()
+
+	result, err := server.Client().GetEmbeddings(context.Background(), []string{"a", "b", "a"}, "text-embedding-3-small", openai.EmbeddingOptions{Dimensions: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Embeddings) != 3 || len(result.Embeddings[0]) != 4 {
+		t.Fatalf("embeddings = %v", result.Embeddings)
+	}
+	for i := range result.Embeddings[0] {
+		if result.Embeddings[0][i] != result.Embeddings[2][i] {
+			t.Fatal("equal inputs got different embeddings")
+		}
+	}
+	if len(server.RequestsTo("/embeddings")) != 1 {
+		t.Errorf("inputs not sent in one request")
+	}
+}
+
+func TestModeration(t *testing.T) {
+	server := openaitest.NewServer()
+	defer server.Close()
+
+	server.OnModeration(func(input string) openai.ModerationResult {
+		if input == "threat" {
+			return openaitest.Flagged(openai.ModerationViolence)
+		}
+		return openaitest.Flagged()
+	})
+
+	response, err := server.Client().Moderate(context.Background(), openai.ModerationRequest{Input: []string{"hello", "threat"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Results) != 2 || response.Results[0].Flagged || !response.Results[1].Flagged {
+		t.Fatalf("results = %+v", response.Results)
+	}
+	if got := response.Results[1].FlaggedCategories(); len(got) != 1 || got[0] != openai.ModerationViolence {
+		t.Errorf("flagged categories = %v", got)
+	}
+}
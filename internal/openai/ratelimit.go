@@ -0,0 +1,286 @@
+package openai
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"woyteck.pl/ai_devs3/internal/retry"
+)
+
+// RateLimit is a per-minute budget. Zero means unlimited.
+type RateLimit struct {
+	RequestsPerMinute int
+	TokensPerMinute   int
+}
+
+// ParseRateLimits reads limits in the form "gpt-4o=500/30000,whisper-1=50/0",
+// where each value is requests/tokens per minute.
+func ParseRateLimits(value string) (map[string]RateLimit, error) {
+	limits := map[string]RateLimit{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		model, budget, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q", entry)
+		}
+		requests, tokens, _ := strings.Cut(budget, "/")
+
+		limit := RateLimit{}
+		var err error
+		if limit.RequestsPerMinute, err = strconv.Atoi(strings.TrimSpace(requests)); err != nil {
+			return nil, fmt.Errorf("invalid requests per minute in %q: %w", entry, err)
+		}
+		if tokens != "" {
+			if limit.TokensPerMinute, err = strconv.Atoi(strings.TrimSpace(tokens)); err != nil {
+				return nil, fmt.Errorf("invalid tokens per minute in %q: %w", entry, err)
+			}
+		}
+		limits[strings.TrimSpace(model)] = limit
+	}
+
+	return limits, nil
+}
+
+type bucket struct {
+	limit    int
+	level    float64
+	updated  time.Time
+	explicit bool
+}
+
+func (b *bucket) refill(now time.Time) {
+	if b.limit <= 0 {
+		return
+	}
+
+	b.level += now.Sub(b.updated).Minutes() * float64(b.limit)
+	if b.level > float64(b.limit) {
+		b.level = float64(b.limit)
+	}
+	b.updated = now
+}
+
+func (b *bucket) setLimit(limit int, now time.Time) {
+	b.refill(now)
+	if b.limit <= 0 {
+		b.level = float64(limit)
+	}
+	b.limit = limit
+	b.updated = now
+}
+
+// wait returns how long until amount is available; amounts above the limit
+// only need a full bucket.
+func (b *bucket) wait(amount float64) time.Duration {
+	if b.limit <= 0 {
+		return 0
+	}
+	if amount > float64(b.limit) {
+		amount = float64(b.limit)
+	}
+	if b.level >= amount {
+		return 0
+	}
+
+	return time.Duration((amount - b.level) / float64(b.limit) * float64(time.Minute))
+}
+
+type modelBuckets struct {
+	requests bucket
+	tokens   bucket
+}
+
+// RateLimiter enforces requests-per-minute and tokens-per-minute budgets per
+// model. Tokens are reserved from an estimate before sending and corrected
+// from the reported usage. Limits learned from x-ratelimit-* headers replace
+// the defaults unless a model was configured explicitly. It is safe for
+// concurrent use.
+type RateLimiter struct {
+	mu       sync.Mutex
+	defaults RateLimit
+	models   map[string]*modelBuckets
+	now      func() time.Time
+}
+
+func NewRateLimiter(defaults RateLimit, perModel map[string]RateLimit) *RateLimiter {
+	l := &RateLimiter{
+		defaults: defaults,
+		models:   map[string]*modelBuckets{},
+		now:      time.Now,
+	}
+
+	now := l.now()
+	for model, limit := range perModel {
+		buckets := l.bucketsFor(model, now)
+		buckets.requests.setLimit(limit.RequestsPerMinute, now)
+		buckets.requests.explicit = true
+		buckets.tokens.setLimit(limit.TokensPerMinute, now)
+		buckets.tokens.explicit = true
+	}
+
+	return l
+}
+
+func (l *RateLimiter) bucketsFor(model string, now time.Time) *modelBuckets {
+	buckets, ok := l.models[model]
+	if !ok {
+		buckets = &modelBuckets{}
+		buckets.requests.setLimit(l.defaults.RequestsPerMinute, now)
+		buckets.tokens.setLimit(l.defaults.TokensPerMinute, now)
+		l.models[model] = buckets
+	}
+
+	return buckets
+}
+
+// Wait blocks until one request and the estimated tokens fit in the model's
+// budget, then reserves them.
+func (l *RateLimiter) Wait(ctx context.Context, model string, tokens int) error {
+	for {
+		l.mu.Lock()
+		now := l.now()
+		buckets := l.bucketsFor(model, now)
+		buckets.requests.refill(now)
+		buckets.tokens.refill(now)
+
+		delay := max(buckets.requests.wait(1), buckets.tokens.wait(float64(tokens)))
+		if delay == 0 {
+			buckets.requests.level--
+			buckets.tokens.level -= float64(tokens)
+			l.mu.Unlock()
+			return nil
+		}
+		l.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Adjust corrects a reservation once the actual token usage is known.
+func (l *RateLimiter) Adjust(model string, estimated int, actual int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	buckets := l.bucketsFor(model, l.now())
+	buckets.tokens.level += float64(estimated - actual)
+}
+
+// Update tunes the model's budget from x-ratelimit-* response headers.
+func (l *RateLimiter) Update(model string, header http.Header) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	buckets := l.bucketsFor(model, now)
+	tune(&buckets.requests, header, "requests", now)
+	tune(&buckets.tokens, header, "tokens", now)
+}
+
+func tune(b *bucket, header http.Header, name string, now time.Time) {
+	if limit, err := strconv.Atoi(header.Get("x-ratelimit-limit-" + name)); err == nil && limit > 0 && !b.explicit {
+		b.setLimit(limit, now)
+	}
+
+	remaining, err := strconv.Atoi(header.Get("x-ratelimit-remaining-" + name))
+	if err != nil || b.limit <= 0 {
+		return
+	}
+	b.refill(now)
+	if float64(remaining) < b.level {
+		b.level = float64(remaining)
+	}
+	if remaining == 0 {
+		if reset := retry.ParseReset(header.Get("x-ratelimit-reset-" + name)); reset > 0 {
+			b.level = -reset.Minutes() * float64(b.limit)
+		}
+	}
+}
+
+// meter describes what a single API call consumes.
+type meter struct {
+	model      string
+	tokens     int
+	completion int
+	imageSize  string
+	characters int
+}
+
+// reserved is what the call counts against the tokens-per-minute budget,
+// which includes the requested completion limit.
+func (m *meter) reserved() int {
+	return m.tokens + m.completion
+}
+
+type usageReporter interface {
+	usage() *Usage
+}
+
+func (r *CompletionResponse) usage() *Usage {
+	return &r.Usage
+}
+
+func (r *EmbeddingResponse) usage() *Usage {
+	return &r.Usage
+}
+
+func (o *OpenAI) acquire(ctx context.Context, m *meter) error {
+	if o.costs != nil && m != nil {
+		if err := o.costs.check(Cost{Model: m.model, PromptTokens: m.tokens, Characters: m.characters}); err != nil {
+			return err
+		}
+	}
+	if o.limiter == nil || m == nil {
+		return nil
+	}
+
+	return o.limiter.Wait(ctx, m.model, m.reserved())
+}
+
+func (o *OpenAI) settle(m *meter, header http.Header, usage *Usage) {
+	if o.limiter == nil || m == nil {
+		return
+	}
+
+	if header != nil {
+		o.limiter.Update(m.model, header)
+	}
+	if usage != nil && usage.TotalTokens > 0 {
+		o.limiter.Adjust(m.model, m.reserved(), usage.TotalTokens)
+	}
+}
+
+func completionMeter(request CompletionRequest) *meter {
+	return &meter{
+		model:      request.Model,
+		tokens:     countMessages(tokenCounter(request.Model), request.Messages),
+		completion: request.MaxCompletionTokens * max(request.N, 1),
+	}
+}
+
+// imageMeter keys the image price by quality and size, filling in the API
+// defaults.
+func imageMeter(model string, quality string, size string) *meter {
+	model = cmp.Or(model, "dall-e-2")
+	size = cmp.Or(size, "1024x1024")
+	if quality != "" && quality != ImageQualityStandard {
+		size = quality + " " + size
+	}
+
+	return &meter{model: model, imageSize: size}
+}
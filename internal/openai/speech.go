@@ -0,0 +1,123 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"unicode/utf8"
+)
+
+const (
+	SpeechFormatMP3  = "mp3"
+	SpeechFormatOpus = "opus"
+	SpeechFormatAAC  = "aac"
+	SpeechFormatFLAC = "flac"
+	SpeechFormatWAV  = "wav"
+	// SpeechFormatPCM is raw 24kHz 16-bit signed little-endian mono.
+	SpeechFormatPCM = "pcm"
+)
+
+const (
+	VoiceAlloy   = "alloy"
+	VoiceAsh     = "ash"
+	VoiceCoral   = "coral"
+	VoiceEcho    = "echo"
+	VoiceFable   = "fable"
+	VoiceOnyx    = "onyx"
+	VoiceNova    = "nova"
+	VoiceSage    = "sage"
+	VoiceShimmer = "shimmer"
+)
+
+type SpeechRequest struct {
+	Model string `json:"model"`
+	Input string `json:"input"`
+	Voice string `json:"voice"`
+	// Instructions steer tone and delivery; tts-1 and tts-1-hd ignore them.
+	Instructions   string `json:"instructions,omitempty"`
+	ResponseFormat string `json:"response_format,omitempty"`
+	// Speed ranges from 0.25 to 4.0; zero means the default of 1.0.
+	Speed float64 `json:"speed,omitempty"`
+}
+
+// CreateSpeech turns text into audio. The audio is streamed as it's
+// generated; the caller must Close the reader, which also ends the request.
+func (o *OpenAI) CreateSpeech(ctx context.Context, request SpeechRequest) (io.ReadCloser, error) {
+	postBody, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("can not marshal request: %w", err)
+	}
+
+	ctx, cancel := o.withTimeout(withIdempotencyKey(ctx))
+
+	req, err := http.NewRequestWithContext(ctx, "POST", o.endpoint("/audio/speech"), bytes.NewBuffer(postBody))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	o.setHeaders(req)
+
+	m := &meter{model: request.Model, characters: utf8.RuneCountInString(request.Input)}
+	if err := o.acquire(ctx, m); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	response, err := o.client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	o.settle(m, response.Header, nil)
+
+	if response.StatusCode >= 400 {
+		defer cancel()
+		defer response.Body.Close()
+		return nil, newAPIError(response)
+	}
+	o.track(ctx, m, nil)
+
+	return &cancelReadCloser{ReadCloser: response.Body, cancel: cancel}, nil
+}
+
+// SaveSpeech writes the audio for request to path. A partly written file is
+// removed on error.
+func (o *OpenAI) SaveSpeech(ctx context.Context, request SpeechRequest, path string) error {
+	audio, err := o.CreateSpeech(ctx, request)
+	if err != nil {
+		return err
+	}
+	defer audio.Close()
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(file, audio)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("can not save speech to %s: %w", path, err)
+	}
+
+	return nil
+}
+
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r *cancelReadCloser) Close() error {
+	defer r.cancel()
+
+	return r.ReadCloser.Close()
+}
@@ -0,0 +1,317 @@
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"woyteck.pl/ai_devs3/internal/retry"
+)
+
+type Delta struct {
+	Role      string          `json:"role,omitempty"`
+	Content   string          `json:"content,omitempty"`
+	Refusal   string          `json:"refusal,omitempty"`
+	ToolCalls []ToolCallDelta `json:"tool_calls,omitempty"`
+}
+
+type ToolCallDelta struct {
+	Index    int          `json:"index"`
+	Id       string       `json:"id,omitempty"`
+	Type     string       `json:"type,omitempty"`
+	Function FunctionCall `json:"function"`
+}
+
+type ChunkChoice struct {
+	Index        int       `json:"index"`
+	Delta        Delta     `json:"delta"`
+	FinishReason string    `json:"finish_reason"`
+	Logprobs     *Logprobs `json:"logprobs,omitempty"`
+}
+
+type CompletionChunk struct {
+	Id                string        `json:"id"`
+	Object            string        `json:"object"`
+	Created           int64         `json:"created"`
+	Model             string        `json:"model"`
+	SystemFingerprint string        `json:"system_fingerprint,omitempty"`
+	ServiceTier       string        `json:"service_tier,omitempty"`
+	Choices           []ChunkChoice `json:"choices"`
+	Usage             *Usage        `json:"usage,omitempty"`
+}
+
+// CompletionStream reads server-sent events of a streamed chat completion.
+// Call Recv until it returns io.EOF, then Response for the assembled result.
+type CompletionStream struct {
+	client   *OpenAI
+	ctx      context.Context
+	meter    *meter
+	response *http.Response
+	reader   *bufio.Reader
+	cancel   context.CancelFunc
+	result   CompletionResponse
+	choices  map[int]*Choice
+	done     bool
+	err      error
+	finished bool
+}
+
+func (o *OpenAI) GetCompletionStream(ctx context.Context, request CompletionRequest) (*CompletionStream, error) {
+	request.Stream = true
+	if o.costs != nil && request.StreamOptions == nil {
+		request.StreamOptions = &StreamOptions{IncludeUsage: true}
+	}
+
+	postBody, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("can not marshal request: %w", err)
+	}
+
+	ctx, cancel := o.withTimeout(retry.Safe(ctx))
+
+	req, err := http.NewRequestWithContext(ctx, "POST", o.endpoint("/chat/completions"), bytes.NewBuffer(postBody))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Accept", "text/event-stream")
+	o.setHeaders(req)
+
+	m := completionMeter(request)
+	if err := o.acquire(ctx, m); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	response, err := o.client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	o.settle(m, response.Header, nil)
+
+	if response.StatusCode >= 400 {
+		defer cancel()
+		defer response.Body.Close()
+		return nil, newAPIError(response)
+	}
+
+	return &CompletionStream{
+		client:   o,
+		ctx:      ctx,
+		meter:    m,
+		response: response,
+		reader:   bufio.NewReader(response.Body),
+		cancel:   cancel,
+		choices:  map[int]*Choice{},
+	}, nil
+}
+
+func (s *CompletionStream) Recv() (CompletionChunk, error) {
+	var chunk CompletionChunk
+	if s.done {
+		return chunk, cmp.Or(s.err, io.EOF)
+	}
+
+	data, err := s.readEvent()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = fmt.Errorf("stream ended before [DONE]: %w", io.ErrUnexpectedEOF)
+		}
+		return chunk, s.fail(err)
+	}
+
+	if data == "[DONE]" {
+		s.done = true
+		s.finish(s.result)
+		return chunk, io.EOF
+	}
+
+	var streamErr errorResponse
+	if err := json.Unmarshal([]byte(data), &streamErr); err == nil && streamErr.Error.Message != "" {
+		return chunk, s.fail(parseAPIError(s.response.StatusCode, s.response.Header.Get("x-request-id"), []byte(data)))
+	}
+
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return chunk, fmt.Errorf("can not unmarshal chunk: %w", err)
+	}
+	s.accumulate(chunk)
+
+	return chunk, nil
+}
+
+func (s *CompletionStream) readEvent() (string, error) {
+	var data []string
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil && (line == "" || !errors.Is(err, io.EOF)) {
+			if errors.Is(err, io.EOF) && len(data) > 0 {
+				return strings.Join(data, "\n"), nil
+			}
+			return "", err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if len(data) > 0 {
+				return strings.Join(data, "\n"), nil
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, "data:") {
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+}
+
+func (s *CompletionStream) accumulate(chunk CompletionChunk) {
+	s.result.Id = chunk.Id
+	s.result.Object = "chat.completion"
+	s.result.Created = chunk.Created
+	s.result.Model = chunk.Model
+	if chunk.SystemFingerprint != "" {
+		s.result.SystemFingerprint = chunk.SystemFingerprint
+	}
+	if chunk.ServiceTier != "" {
+		s.result.ServiceTier = chunk.ServiceTier
+	}
+	if chunk.Usage != nil {
+		s.result.Usage = *chunk.Usage
+	}
+
+	for _, c := range chunk.Choices {
+		choice, ok := s.choices[c.Index]
+		if !ok {
+			choice = &Choice{Index: c.Index}
+			s.choices[c.Index] = choice
+		}
+		if c.Delta.Role != "" {
+			choice.Message.Role = c.Delta.Role
+		}
+		choice.Message.Content += c.Delta.Content
+		choice.Message.Refusal += c.Delta.Refusal
+		for _, call := range c.Delta.ToolCalls {
+			for len(choice.Message.ToolCalls) <= call.Index {
+				choice.Message.ToolCalls = append(choice.Message.ToolCalls, ToolCall{})
+			}
+			toolCall := &choice.Message.ToolCalls[call.Index]
+			if call.Id != "" {
+				toolCall.Id = call.Id
+			}
+			if call.Type != "" {
+				toolCall.Type = call.Type
+			}
+			toolCall.Function.Name += call.Function.Name
+			toolCall.Function.Arguments += call.Function.Arguments
+		}
+		if c.Logprobs != nil {
+			if choice.Logprobs == nil {
+				choice.Logprobs = &Logprobs{}
+			}
+			choice.Logprobs.Content = append(choice.Logprobs.Content, c.Logprobs.Content...)
+			choice.Logprobs.Refusal = append(choice.Logprobs.Refusal, c.Logprobs.Refusal...)
+		}
+		if c.FinishReason != "" {
+			choice.FinishReason = c.FinishReason
+		}
+	}
+}
+
+// fail ends the stream with err. The tokens generated so far are billed even
+// though the stream broke off, so they are settled and tracked all the same.
+func (s *CompletionStream) fail(err error) error {
+	s.done = true
+	s.err = err
+	s.finish(s.estimatedResponse())
+
+	return err
+}
+
+// finish settles and tracks the usage of the stream, once.
+func (s *CompletionStream) finish(result CompletionResponse) {
+	if s.finished {
+		return
+	}
+	s.finished = true
+
+	s.client.settle(s.meter, nil, &result.Usage)
+	s.client.track(s.ctx, s.meter, &result)
+}
+
+// estimatedResponse is the response received so far with its usage counted
+// locally when the stream ended before the API reported it.
+func (s *CompletionStream) estimatedResponse() CompletionResponse {
+	result := s.Response()
+	if result.Usage.TotalTokens > 0 {
+		return result
+	}
+
+	count := tokenCounter(s.meter.model)
+	completion := 0
+	for _, choice := range result.Choices {
+		completion += count(choice.Message.Content + choice.Message.Refusal)
+		for _, call := range choice.Message.ToolCalls {
+			completion += count(call.Function.Name + call.Function.Arguments)
+		}
+	}
+	result.Usage = Usage{
+		PromptTokens:     s.meter.tokens,
+		CompletionTokens: completion,
+		TotalTokens:      s.meter.tokens + completion,
+	}
+
+	return result
+}
+
+// Response returns the completion assembled from all chunks received so far.
+func (s *CompletionStream) Response() CompletionResponse {
+	result := s.result
+	result.Choices = make([]Choice, 0, len(s.choices))
+	for _, choice := range s.choices {
+		result.Choices = append(result.Choices, *choice)
+	}
+	sort.Slice(result.Choices, func(i, j int) bool {
+		return result.Choices[i].Index < result.Choices[j].Index
+	})
+
+	return result
+}
+
+// Close ends the stream. Usage of a stream dropped before [DONE] is settled
+// and tracked from what was received.
+func (s *CompletionStream) Close() error {
+	defer s.cancel()
+	if !s.finished {
+		s.finish(s.estimatedResponse())
+	}
+	return s.response.Body.Close()
+}
+
+func (o *OpenAI) collectStream(ctx context.Context, request CompletionRequest) (CompletionResponse, error) {
+	stream, err := o.GetCompletionStream(ctx, request)
+	if err != nil {
+		return CompletionResponse{}, err
+	}
+	defer stream.Close()
+
+	for {
+		if _, err := stream.Recv(); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return stream.Response(), err
+		}
+	}
+
+	return stream.Response(), nil
+}
@@ -0,0 +1,153 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"woyteck.pl/ai_devs3/internal/schema"
+)
+
+const (
+	ResponseFormatText       = "text"
+	ResponseFormatJSONObject = "json_object"
+	ResponseFormatJSONSchema = "json_schema"
+)
+
+type JSONSchema struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Schema      any    `json:"schema"`
+	Strict      bool   `json:"strict"`
+}
+
+type ResponseFormat struct {
+	Type       string      `json:"type"`
+	JSONSchema *JSONSchema `json:"json_schema,omitempty"`
+}
+
+type RefusalError struct {
+	Refusal string
+}
+
+func (e *RefusalError) Error() string {
+	return "model refused to answer: " + e.Refusal
+}
+
+type SchemaError struct {
+	Content string
+	Err     error
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("response does not match schema: %v", e.Err)
+}
+
+func (e *SchemaError) Unwrap() error {
+	return e.Err
+}
+
+var ErrIncompleteOutput = errors.New("structured output was cut off before completion")
+
+// Validator lets a structured output type enforce rules the schema can't express.
+type Validator interface {
+	Validate() error
+}
+
+// CompleteInto asks the model to answer with JSON matching the schema of T in
+// strict mode and decodes the reply into T.
+func CompleteInto[T any](ctx context.Context, o *OpenAI, request CompletionRequest) (T, error) {
+	var result T
+
+	outputSchema, err := schema.GenerateStrict(reflect.TypeOf(result))
+	if err != nil {
+		return result, err
+	}
+	if err := schema.CheckStrict(outputSchema); err != nil {
+		return result, err
+	}
+
+	request.ResponseFormat = &ResponseFormat{
+		Type: ResponseFormatJSONSchema,
+		JSONSchema: &JSONSchema{
+			Name:   SchemaName(reflect.TypeOf(result)),
+			Schema: outputSchema,
+			Strict: true,
+		},
+	}
+
+	response, err := o.GetCompletion(ctx, request)
+	if err != nil {
+		return result, err
+	}
+	if len(response.Choices) == 0 {
+		return result, errors.New("no choices in response")
+	}
+
+	choice := response.Choices[0]
+	if choice.Message.Refusal != "" {
+		return result, &RefusalError{Refusal: choice.Message.Refusal}
+	}
+	if choice.FinishReason == FinishReasonLength {
+		return result, ErrIncompleteOutput
+	}
+
+	if err := DecodeStructured(choice.Message.Content, outputSchema, &result); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// DecodeStructured decodes content into result, which must be a pointer, and
+// checks it against outputSchema and the Validator of the decoded value.
+// Mismatches are reported as *SchemaError.
+func DecodeStructured(content string, outputSchema *schema.Schema, result any) error {
+	if err := decodeStrict(content, result); err != nil {
+		return &SchemaError{Content: content, Err: err}
+	}
+	if err := schema.ValidateJSON(outputSchema, []byte(content)); err != nil {
+		return &SchemaError{Content: content, Err: err}
+	}
+	if validator, ok := reflect.ValueOf(result).Elem().Interface().(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			return &SchemaError{Content: content, Err: err}
+		}
+	}
+
+	return nil
+}
+
+func decodeStrict(content string, result any) error {
+	decoder := json.NewDecoder(strings.NewReader(content))
+	decoder.DisallowUnknownFields()
+
+	return decoder.Decode(result)
+}
+
+// maxSchemaNameLength is the longest name the API accepts for a schema.
+const maxSchemaNameLength = 64
+
+// SchemaName names the JSON schema of t after the type, or "response" for
+// unnamed types. Names may only hold letters, digits, _ and -, so the rest,
+// such as the brackets and package paths of generic types, become _.
+func SchemaName(t reflect.Type) string {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Name() == "" {
+		return "response"
+	}
+
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, t.Name())
+
+	return name[:min(len(name), maxSchemaNameLength)]
+}
@@ -0,0 +1,76 @@
+package openai_test
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"woyteck.pl/ai_devs3/internal/openai"
+	"woyteck.pl/ai_devs3/internal/openai/openaitest"
+)
+
+type item struct {
+	Name string `json:"name"`
+}
+
+type result[T any] struct {
+	Value T `json:"value"`
+}
+
+type pair[A any, B any] struct {
+	First  A `json:"first"`
+	Second B `json:"second"`
+}
+
+var validSchemaName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+func TestSchemaName(t *testing.T) {
+	tests := []struct {
+		t    reflect.Type
+		want string
+	}{
+		{t: reflect.TypeOf(item{}), want: "item"},
+		{t: reflect.TypeOf(&item{}), want: "item"},
+		{t: reflect.TypeOf(struct{ Name string }{}), want: "response"},
+		{t: reflect.TypeOf([]item{}), want: "response"},
+		{t: nil, want: "response"},
+		{t: reflect.TypeOf(result[item]{}), want: "result_woyteck_pl_ai_devs3_internal_openai_test_item_"},
+		{t: reflect.TypeOf(result[string]{}), want: "result_string_"},
+		{
+			t:    reflect.TypeOf(pair[result[item], []item]{}),
+			want: "pair_woyteck_pl_ai_devs3_internal_openai_test_result_woyteck_pl_",
+		},
+	}
+
+	for _, test := range tests {
+		name := openai.SchemaName(test.t)
+		if name != test.want {
+			t.Errorf("SchemaName(%v) = %q, want %q", test.t, name, test.want)
+		}
+		if !validSchemaName.MatchString(name) {
+			t.Errorf("SchemaName(%v) = %q, which the API rejects", test.t, name)
+		}
+	}
+}
+
+func TestCompleteIntoGeneric(t *testing.T) {
+	server := openaitest.NewServer()
+	defer server.Close()
+
+	server.ScriptChat(openaitest.ChatReply{Content: `{"value":{"name":"apple"}}`})
+
+	got, err := openai.CompleteInto[result[item]](context.Background(), server.Client(), openai.CompletionRequest{
+		Model:    "gpt-4o",
+		Messages: []openai.Message{{Role: "user", Content: "Name a fruit."}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Value.Name != "apple" {
+		t.Errorf("result = %+v", got)
+	}
+	if name := server.ChatRequests()[0].ResponseFormat.JSONSchema.Name; !validSchemaName.MatchString(name) {
+		t.Errorf("schema sent as %q, which the API rejects", name)
+	}
+}
@@ -0,0 +1,77 @@
+package openai
+
+import (
+	"woyteck.pl/ai_devs3/internal/tokenizer"
+)
+
+// Chat formatting overhead, as documented in the OpenAI cookbook: every
+// message is wrapped in a few tokens, a name adds one more and the reply is
+// primed with three.
+const (
+	TokensPerMessage = 3
+	TokensPerName    = 1
+	TokensPerReply   = 3
+)
+
+// Image costs for a single 512px tile at low detail and a typical
+// high-detail image. The real cost depends on the image size.
+const (
+	ImageTokensLow  = 85
+	ImageTokensHigh = 765
+)
+
+// CountTokens returns the prompt tokens messages take up for model,
+// including the per-message overhead.
+func CountTokens(model string, messages []Message) (int, error) {
+	encoding, err := tokenizer.ForModel(model)
+	if err != nil {
+		return 0, err
+	}
+
+	return countMessages(encoding.Count, messages), nil
+}
+
+func countMessages(count func(string) int, messages []Message) int {
+	tokens := TokensPerReply
+	for _, message := range messages {
+		tokens += TokensPerMessage + count(message.Role) + count(message.Content)
+		if message.Name != "" {
+			tokens += TokensPerName + count(message.Name)
+		}
+		for _, call := range message.ToolCalls {
+			tokens += count(call.Function.Name) + count(call.Function.Arguments)
+		}
+		for _, part := range message.Parts {
+			switch {
+			case part.ImageURL != nil && part.ImageURL.Detail == ImageDetailLow:
+				tokens += ImageTokensLow
+			case part.ImageURL != nil:
+				tokens += ImageTokensHigh
+			default:
+				tokens += count(part.Text)
+			}
+		}
+	}
+
+	return tokens
+}
+
+// TruncateTokens keeps at most n tokens of text as model would encode it.
+func TruncateTokens(model string, text string, n int) (string, error) {
+	encoding, err := tokenizer.ForModel(model)
+	if err != nil {
+		return "", err
+	}
+
+	return encoding.Truncate(text, n), nil
+}
+
+// tokenCounter counts with the model's encoding, or estimates when the
+// model is unknown to the tokenizer (local or third-party models).
+func tokenCounter(model string) func(string) int {
+	if encoding, err := tokenizer.ForModel(model); err == nil {
+		return encoding.Count
+	}
+
+	return estimateTokens
+}
@@ -0,0 +1,165 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"woyteck.pl/ai_devs3/internal/schema"
+)
+
+const (
+	ToolChoiceAuto     = "auto"
+	ToolChoiceNone     = "none"
+	ToolChoiceRequired = "required"
+)
+
+var ErrMaxIterations = errors.New("tool loop reached the iteration cap without a final answer")
+
+type FunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+type ToolCall struct {
+	Id       string       `json:"id"`
+	Type     string       `json:"type"`
+	Function FunctionCall `json:"function"`
+}
+
+type ToolChoiceFunction struct {
+	Name string `json:"name"`
+}
+
+type ToolChoice struct {
+	Type     string             `json:"type"`
+	Function ToolChoiceFunction `json:"function"`
+}
+
+func ForceFunction(name string) ToolChoice {
+	return ToolChoice{
+		Type:     "function",
+		Function: ToolChoiceFunction{Name: name},
+	}
+}
+
+// FunctionFor describes a function whose strict parameters schema is
+// generated from the struct T.
+func FunctionFor[T any](name string, description string) (Function, error) {
+	var params T
+	parameters, err := schema.GenerateStrict(reflect.TypeOf(params))
+	if err != nil {
+		return Function{}, err
+	}
+	if err := schema.CheckStrict(parameters); err != nil {
+		return Function{}, err
+	}
+
+	return Function{
+		Name:        name,
+		Description: description,
+		Parameters:  parameters,
+		Strict:      true,
+	}, nil
+}
+
+// HandleJSON adapts a typed function to a ToolHandler by decoding the
+// arguments into T.
+func HandleJSON[T any](fn func(ctx context.Context, params T) (string, error)) ToolHandler {
+	return func(ctx context.Context, arguments string) (string, error) {
+		var params T
+		if err := json.Unmarshal([]byte(arguments), &params); err != nil {
+			return "", fmt.Errorf("invalid arguments: %w", err)
+		}
+
+		return fn(ctx, params)
+	}
+}
+
+// ToolHandler receives the raw JSON arguments chosen by the model and returns
+// the content of the "tool" message sent back to it.
+type ToolHandler func(ctx context.Context, arguments string) (string, error)
+
+type Toolbox struct {
+	tools    []Tool
+	handlers map[string]ToolHandler
+}
+
+func NewToolbox() *Toolbox {
+	return &Toolbox{
+		handlers: map[string]ToolHandler{},
+	}
+}
+
+func (t *Toolbox) Register(function Function, handler ToolHandler) {
+	t.tools = append(t.tools, Tool{Type: "function", Function: function})
+	t.handlers[function.Name] = handler
+}
+
+func (t *Toolbox) Tools() []Tool {
+	return t.tools
+}
+
+func (t *Toolbox) Call(ctx context.Context, call ToolCall) Message {
+	message := Message{
+		Role:       "tool",
+		ToolCallId: call.Id,
+	}
+
+	handler, ok := t.handlers[call.Function.Name]
+	if !ok {
+		message.Content = fmt.Sprintf("error: unknown function %s", call.Function.Name)
+		return message
+	}
+
+	content, err := handler(ctx, call.Function.Arguments)
+	if err != nil {
+		message.Content = fmt.Sprintf("error: %v", err)
+		return message
+	}
+	message.Content = content
+
+	return message
+}
+
+// RunTools calls the model, dispatches the requested functions to the toolbox
+// and feeds the results back until the model answers without tool calls.
+// The returned messages hold the whole conversation, including the answer.
+func (o *OpenAI) RunTools(ctx context.Context, request CompletionRequest, toolbox *Toolbox, maxIterations int) (CompletionResponse, []Message, error) {
+	if len(request.Tools) == 0 {
+		request.Tools = toolbox.Tools()
+	}
+	messages := append([]Message{}, request.Messages...)
+
+	var response CompletionResponse
+	for i := 0; i < maxIterations; i++ {
+		request.Messages = messages
+
+		var err error
+		response, err = o.GetCompletion(ctx, request)
+		if err != nil {
+			return response, messages, err
+		}
+		if len(response.Choices) == 0 {
+			return response, messages, errors.New("no choices in response")
+		}
+
+		message := response.Choices[0].Message
+		messages = append(messages, message)
+		if len(message.ToolCalls) == 0 {
+			return response, messages, nil
+		}
+
+		for _, call := range message.ToolCalls {
+			messages = append(messages, toolbox.Call(ctx, call))
+		}
+
+		if choice, ok := request.ToolChoice.(ToolChoice); ok && choice.Type == "function" {
+			request.ToolChoice = ToolChoiceAuto
+		}
+	}
+
+	return response, messages, ErrMaxIterations
+}
@@ -0,0 +1,386 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"woyteck.pl/ai_devs3/internal/audio"
+	"woyteck.pl/ai_devs3/internal/retry"
+)
+
+const (
+	TranscriptionFormatJSON        = "json"
+	TranscriptionFormatVerboseJSON = "verbose_json"
+	TranscriptionFormatText        = "text"
+	TranscriptionFormatSRT         = "srt"
+	TranscriptionFormatVTT         = "vtt"
+)
+
+const (
+	TimestampGranularitySegment = "segment"
+	TimestampGranularityWord    = "word"
+)
+
+type TranscriptionRequest struct {
+	File []byte
+	// FileName tells the API the audio format by its extension, e.g. "a.mp3".
+	FileName string
+	Model    string
+	// Language is the ISO-639-1 code of the spoken language, e.g. "pl".
+	Language string
+	// Prompt carries spelling hints or the text preceding this audio.
+	Prompt         string
+	Temperature    *float64
+	ResponseFormat string
+	// TimestampGranularities needs the verbose_json response format.
+	TimestampGranularities []string
+}
+
+type TranscriptionSegment struct {
+	Id               int     `json:"id"`
+	Seek             int     `json:"seek"`
+	Start            float64 `json:"start"`
+	End              float64 `json:"end"`
+	Text             string  `json:"text"`
+	Tokens           []int   `json:"tokens"`
+	Temperature      float64 `json:"temperature"`
+	AvgLogprob       float64 `json:"avg_logprob"`
+	CompressionRatio float64 `json:"compression_ratio"`
+	NoSpeechProb     float64 `json:"no_speech_prob"`
+}
+
+type TranscriptionWord struct {
+	Word  string  `json:"word"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// TranscriptionResponse holds the transcript in any response format. For
+// text, srt and vtt the body is kept as is in Text.
+type TranscriptionResponse struct {
+	Text     string                 `json:"text"`
+	Language string                 `json:"language,omitempty"`
+	Duration float64                `json:"duration,omitempty"`
+	Segments []TranscriptionSegment `json:"segments,omitempty"`
+	Words    []TranscriptionWord    `json:"words,omitempty"`
+	Usage    *TranscriptionUsage    `json:"usage,omitempty"`
+
+	format string
+}
+
+// TranscriptionUsage is billed either by audio duration ("duration") or by
+// tokens ("tokens"), depending on the model.
+type TranscriptionUsage struct {
+	Type         string  `json:"type"`
+	Seconds      float64 `json:"seconds,omitempty"`
+	InputTokens  int     `json:"input_tokens,omitempty"`
+	OutputTokens int     `json:"output_tokens,omitempty"`
+	TotalTokens  int     `json:"total_tokens,omitempty"`
+}
+
+func (r *TranscriptionResponse) decodeBody(body io.Reader) error {
+	switch r.format {
+	case TranscriptionFormatText, TranscriptionFormatSRT, TranscriptionFormatVTT:
+		text, err := io.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		r.Text = string(text)
+		if r.format != TranscriptionFormatText {
+			r.Duration = subtitlesDuration(r.Text)
+		}
+		return nil
+	}
+
+	return json.NewDecoder(body).Decode(r)
+}
+
+var subtitleTimestamp = regexp.MustCompile(`--> (\d+):(\d{2}):(\d{2})[,.](\d{3})`)
+
+// subtitlesDuration reads the end of the last cue, which is as close to the
+// billed audio length as the subtitle formats get.
+func subtitlesDuration(text string) float64 {
+	matches := subtitleTimestamp.FindAllStringSubmatch(text, -1)
+	if len(matches) == 0 {
+		return 0
+	}
+
+	last := matches[len(matches)-1]
+	parts := make([]float64, 4)
+	for i := range parts {
+		parts[i], _ = strconv.ParseFloat(last[i+1], 64)
+	}
+
+	return parts[0]*3600 + parts[1]*60 + parts[2] + parts[3]/1000
+}
+
+// Timestamp formats a position in the audio as mm:ss or h:mm:ss, for citing
+// segments in prompts.
+func Timestamp(seconds float64) string {
+	d := time.Duration(seconds * float64(time.Second)).Truncate(time.Second)
+	h, m, s := int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+
+	return fmt.Sprintf("%02d:%02d", m, s)
+}
+
+// GetTranscription transcribes audio in the given format (its file
+// extension) and returns the plain text. Long recordings are split, see
+// TranscribeChunked.
+func (o *OpenAI) GetTranscription(ctx context.Context, file []byte, model string, format string) (string, error) {
+	request := TranscriptionRequest{
+		File:     file,
+		FileName: "file." + format,
+		Model:    model,
+	}
+
+	result, err := o.TranscribeChunked(ctx, request, ChunkOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	return result.Text, nil
+}
+
+// Transcribe transcribes a single file. When costs are tracked, whisper is
+// asked for verbose_json in place of json or text, which don't report the
+// billed duration, and the result is trimmed back to the requested format.
+func (o *OpenAI) Transcribe(ctx context.Context, request TranscriptionRequest) (*TranscriptionResponse, error) {
+	if len(request.TimestampGranularities) > 0 && request.ResponseFormat != TranscriptionFormatVerboseJSON {
+		return nil, errors.New("timestamp granularities need the verbose_json response format")
+	}
+
+	format := request.ResponseFormat
+	metered := o.costs != nil && strings.HasPrefix(request.Model, "whisper") &&
+		(format == "" || format == TranscriptionFormatJSON || format == TranscriptionFormatText)
+	if metered {
+		format = TranscriptionFormatVerboseJSON
+	}
+
+	fields := [][2]string{
+		{"model", request.Model},
+		{"language", request.Language},
+		{"prompt", request.Prompt},
+		{"response_format", format},
+	}
+	if request.Temperature != nil {
+		fields = append(fields, [2]string{"temperature", strconv.FormatFloat(*request.Temperature, 'f', -1, 64)})
+	}
+	for _, granularity := range request.TimestampGranularities {
+		fields = append(fields, [2]string{"timestamp_granularities[]", granularity})
+	}
+
+	files := []formFile{{field: "file", name: request.FileName, data: request.File}}
+	result := &TranscriptionResponse{format: format}
+	if err := o.postMultipart(retry.Safe(ctx), o.endpoint("/audio/transcriptions"), files, fields, result, &meter{model: request.Model}); err != nil {
+		return nil, err
+	}
+	if metered {
+		result.format = request.ResponseFormat
+		result.Segments, result.Words = nil, nil
+	}
+
+	return result, nil
+}
+
+// MaxTranscriptionFileSize is the largest file the transcription endpoint
+// accepts.
+const MaxTranscriptionFileSize = 25 << 20
+
+type ChunkOptions struct {
+	// MaxBytes caps the size of every chunk; it defaults to 24 MiB.
+	MaxBytes int
+	// MaxDuration caps the length of every chunk, for models that limit it.
+	MaxDuration time.Duration
+	// Overlap is the audio shared by consecutive chunks; it defaults to two
+	// seconds.
+	Overlap time.Duration
+	// Concurrency is how many chunks are transcribed at once; it defaults
+	// to four.
+	Concurrency int
+}
+
+// TranscribeChunked transcribes audio of any length. Files over the limit
+// are split into overlapping mp3, m4a or wav chunks, transcribed
+// concurrently and stitched back together with timestamps shifted to the
+// whole recording.
+//
+// Whisper models are asked for verbose_json, and each overlap is cut at its
+// midpoint by segment start. Other models don't return segments, so their
+// chunks don't overlap and only the text is joined.
+func (o *OpenAI) TranscribeChunked(ctx context.Context, request TranscriptionRequest, opts ChunkOptions) (*TranscriptionResponse, error) {
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = MaxTranscriptionFileSize - 1<<20
+	}
+	if opts.Overlap <= 0 {
+		opts.Overlap = 2 * time.Second
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 4
+	}
+	timestamps := strings.HasPrefix(request.Model, "whisper")
+	if !timestamps {
+		opts.Overlap = 0
+	}
+
+	if len(request.File) <= opts.MaxBytes && opts.MaxDuration <= 0 {
+		return o.Transcribe(ctx, request)
+	}
+
+	chunks, format, err := audio.Split(request.File, audio.SplitOptions{
+		MaxBytes:    opts.MaxBytes,
+		MaxDuration: opts.MaxDuration,
+		Overlap:     opts.Overlap,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can not split %s: %w", request.FileName, err)
+	}
+	if len(chunks) == 1 {
+		return o.Transcribe(ctx, request)
+	}
+
+	chunkRequest := request
+	chunkRequest.ResponseFormat = TranscriptionFormatJSON
+	if timestamps {
+		chunkRequest.ResponseFormat = TranscriptionFormatVerboseJSON
+		if len(request.TimestampGranularities) > 0 && !slices.Contains(request.TimestampGranularities, TimestampGranularitySegment) {
+			chunkRequest.TimestampGranularities = append(slices.Clone(request.TimestampGranularities), TimestampGranularitySegment)
+		}
+	}
+
+	results, err := o.transcribeChunks(ctx, chunkRequest, chunks, format, opts.Concurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	result := stitchTranscriptions(chunks, results, timestamps)
+	switch request.ResponseFormat {
+	case TranscriptionFormatSRT:
+		result.Text = formatSubtitles(result.Segments, ",", "")
+	case TranscriptionFormatVTT:
+		result.Text = formatSubtitles(result.Segments, ".", "WEBVTT\n\n")
+	}
+	if request.ResponseFormat != TranscriptionFormatVerboseJSON {
+		result.Segments, result.Words = nil, nil
+	}
+
+	return result, nil
+}
+
+func (o *OpenAI) transcribeChunks(ctx context.Context, request TranscriptionRequest, chunks []audio.Chunk, format audio.Format, concurrency int) ([]*TranscriptionResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]*TranscriptionResponse, len(chunks))
+	errs := make([]error, len(chunks))
+	workers := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			workers <- struct{}{}
+			defer func() { <-workers }()
+
+			chunkRequest := request
+			chunkRequest.File = chunk.Data
+			chunkRequest.FileName = fmt.Sprintf("chunk-%d.%s", i, format.Extension())
+			results[i], errs[i] = o.Transcribe(ctx, chunkRequest)
+			if errs[i] != nil {
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return nil, fmt.Errorf("chunk %d: %w", i, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// stitchTranscriptions shifts every chunk's timestamps by its start and
+// keeps, from each overlap, what starts before its midpoint from the earlier
+// chunk and the rest from the later one.
+func stitchTranscriptions(chunks []audio.Chunk, results []*TranscriptionResponse, timestamps bool) *TranscriptionResponse {
+	stitched := &TranscriptionResponse{
+		Language: results[0].Language,
+		Duration: chunks[len(chunks)-1].End.Seconds(),
+	}
+
+	texts := []string{}
+	for i, result := range results {
+		if !timestamps {
+			texts = append(texts, strings.TrimSpace(result.Text))
+			continue
+		}
+
+		offset := chunks[i].Start.Seconds()
+		from, to := math.Inf(-1), math.Inf(1)
+		if i > 0 {
+			from = (chunks[i].Start + chunks[i-1].End).Seconds() / 2
+		}
+		if i+1 < len(chunks) {
+			to = (chunks[i+1].Start + chunks[i].End).Seconds() / 2
+		}
+
+		for _, segment := range result.Segments {
+			segment.Start += offset
+			segment.End += offset
+			if segment.Start < from || segment.Start >= to {
+				continue
+			}
+			segment.Id = len(stitched.Segments)
+			stitched.Segments = append(stitched.Segments, segment)
+			texts = append(texts, strings.TrimSpace(segment.Text))
+		}
+		for _, word := range result.Words {
+			word.Start += offset
+			word.End += offset
+			if word.Start >= from && word.Start < to {
+				stitched.Words = append(stitched.Words, word)
+			}
+		}
+	}
+	stitched.Text = strings.Join(texts, " ")
+
+	return stitched
+}
+
+// formatSubtitles renders segments as SRT cues, or WebVTT with "." as the
+// millisecond separator and its header.
+func formatSubtitles(segments []TranscriptionSegment, separator string, header string) string {
+	var b strings.Builder
+	b.WriteString(header)
+	for i, segment := range segments {
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", i+1,
+			subtitleTime(segment.Start, separator), subtitleTime(segment.End, separator), strings.TrimSpace(segment.Text))
+	}
+
+	return b.String()
+}
+
+func subtitleTime(seconds float64, separator string) string {
+	ms := int64(math.Round(seconds * 1000))
+
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, separator, ms%1000)
+}
@@ -0,0 +1,122 @@
+package openai
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"woyteck.pl/ai_devs3/internal/audio"
+)
+
+func TestStitchTranscriptions(t *testing.T) {
+	// Overlaps are 8s-10s and 16s-18s, so their midpoints are 9s and 17s.
+	chunks := []audio.Chunk{
+		{Start: 0, End: 10 * time.Second},
+		{Start: 8 * time.Second, End: 18 * time.Second},
+		{Start: 16 * time.Second, End: 20 * time.Second},
+	}
+	results := []*TranscriptionResponse{
+		{
+			Language: "polish",
+			Segments: []TranscriptionSegment{
+				{Start: 0, End: 3, Text: " one"},
+				{Start: 3, End: 7, Text: " two"},
+				{Start: 7.5, End: 9.5, Text: " three"},
+				{Start: 9, End: 10, Text: " four from the first chunk"},
+			},
+			Words: []TranscriptionWord{
+				{Word: "three", Start: 7.5, End: 8},
+				{Word: "four", Start: 9, End: 9.5},
+			},
+		},
+		{
+			Segments: []TranscriptionSegment{
+				{Start: 0.5, End: 1, Text: " three from the second chunk"},
+				{Start: 1, End: 3, Text: " four"},
+				{Start: 3, End: 8.5, Text: " five"},
+				{Start: 8.8, End: 10, Text: " six"},
+				{Start: 9.1, End: 10, Text: " seven from the second chunk"},
+			},
+			Words: []TranscriptionWord{
+				{Word: "three", Start: 0.5, End: 1},
+				{Word: "four", Start: 1, End: 1.5},
+			},
+		},
+		{
+			Segments: []TranscriptionSegment{
+				{Start: 0.8, End: 1, Text: " six from the third chunk"},
+				{Start: 1.1, End: 3, Text: " seven"},
+				{Start: 3, End: 4, Text: " eight"},
+			},
+		},
+	}
+
+	stitched := stitchTranscriptions(chunks, results, true)
+
+	if want := "one two three four five six seven eight"; stitched.Text != want {
+		t.Errorf("text = %q, want %q", stitched.Text, want)
+	}
+	if stitched.Language != "polish" || stitched.Duration != 20 {
+		t.Errorf("language %q and duration %v, want polish and 20", stitched.Language, stitched.Duration)
+	}
+
+	wantStarts := []float64{0, 3, 7.5, 9, 11, 16.8, 17.1, 19}
+	if len(stitched.Segments) != len(wantStarts) {
+		t.Fatalf("got %d segments, want %d", len(stitched.Segments), len(wantStarts))
+	}
+	for i, segment := range stitched.Segments {
+		if segment.Id != i || segment.Start != wantStarts[i] {
+			t.Errorf("segment %d has id %d and start %v, want start %v", i, segment.Id, segment.Start, wantStarts[i])
+		}
+	}
+	if last := stitched.Segments[len(stitched.Segments)-1]; last.End != 20 {
+		t.Errorf("last segment ends at %v, want 20", last.End)
+	}
+
+	// A word right at the midpoint belongs to the later chunk.
+	if len(stitched.Words) != 2 || stitched.Words[0].Start != 7.5 || stitched.Words[1].Start != 9 || stitched.Words[1].End != 9.5 {
+		t.Errorf("words = %+v", stitched.Words)
+	}
+}
+
+func TestStitchTranscriptionsWithoutTimestamps(t *testing.T) {
+	chunks := []audio.Chunk{
+		{Start: 0, End: 10 * time.Second},
+		{Start: 10 * time.Second, End: 15 * time.Second},
+	}
+	results := []*TranscriptionResponse{
+		{Text: " Hello there. ", Language: "english"},
+		{Text: "General Kenobi.\n"},
+	}
+
+	stitched := stitchTranscriptions(chunks, results, false)
+	if stitched.Text != "Hello there. General Kenobi." || stitched.Duration != 15 || len(stitched.Segments) != 0 {
+		t.Errorf("stitched = %+v", stitched)
+	}
+}
+
+func TestFormatSubtitles(t *testing.T) {
+	segments := []TranscriptionSegment{
+		{Start: 0, End: 1.9996, Text: " Hi"},
+		{Start: 3661.25, End: 3662, Text: "There \n"},
+	}
+
+	srt := formatSubtitles(segments, ",", "")
+	wantSRT := "1\n00:00:00,000 --> 00:00:02,000\nHi\n\n" +
+		"2\n01:01:01,250 --> 01:01:02,000\nThere\n\n"
+	if srt != wantSRT {
+		t.Errorf("srt:\n%s\nwant:\n%s", srt, wantSRT)
+	}
+
+	vtt := formatSubtitles(segments, ".", "WEBVTT\n\n")
+	wantVTT := "WEBVTT\n\n" + strings.ReplaceAll(wantSRT, ",", ".")
+	if vtt != wantVTT {
+		t.Errorf("vtt:\n%s\nwant:\n%s", vtt, wantVTT)
+	}
+
+	for _, text := range []string{srt, vtt} {
+		if duration := subtitlesDuration(text); duration != 3662 {
+			t.Errorf("subtitlesDuration = %v, want 3662", duration)
+		}
+	}
+}
@@ -2,14 +2,43 @@ package qdrant
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
+	"time"
+
+	"woyteck.pl/ai_devs3/internal/retry"
 )
 
+const DefaultTimeout = 30 * time.Second
+
 type Qdrant struct {
-	url string
+	url         string
+	timeout     time.Duration
+	client      *http.Client
+	retryPolicy retry.Policy
+}
+
+type Option func(*Qdrant)
+
+func WithTimeout(timeout time.Duration) Option {
+	return func(q *Qdrant) {
+		q.timeout = timeout
+	}
+}
+
+func WithHTTPClient(client *http.Client) Option {
+	return func(q *Qdrant) {
+		q.client = client
+	}
+}
+
+func WithRetryPolicy(policy retry.Policy) Option {
+	return func(q *Qdrant) {
+		q.retryPolicy = policy
+	}
 }
 
 type Point struct {
@@ -52,13 +81,22 @@ type SearchResponse struct {
 	Time   float64        `json:"time"`
 }
 
-func NewClient(url string) *Qdrant {
-	return &Qdrant{
-		url: url,
+func NewClient(url string, opts ...Option) *Qdrant {
+	q := &Qdrant{
+		url:     url,
+		timeout: DefaultTimeout,
+		client:  http.DefaultClient,
+	}
+	q.retryPolicy = retry.DefaultPolicy()
+	for _, opt := range opts {
+		opt(q)
 	}
+	q.client = retry.Client(q.client, q.retryPolicy)
+
+	return q
 }
 
-func (qdrant *Qdrant) UpsertPoints(collectionName string, vector []float64, id int, payload map[string]any) UpsertPointsResponse {
+func (qdrant *Qdrant) UpsertPoints(ctx context.Context, collectionName string, vector []float64, id int, payload map[string]any) (UpsertPointsResponse, error) {
 	url := fmt.Sprintf("%s/collections/%s/points?wait=true", qdrant.url, collectionName)
 
 	request := UpsertPointsRequest{
@@ -71,30 +109,13 @@ func (qdrant *Qdrant) UpsertPoints(collectionName string, vector []float64, id i
 		},
 	}
 
-	body, _ := json.Marshal(request)
-	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(body))
-	if err != nil {
-		log.Fatalf("Error occured %v", err)
-	}
-	req.Header.Add("Content-Type", "application/json")
-
-	response, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Fatalf("Error occured %v", err)
-	}
-
-	defer response.Body.Close()
-
 	var result UpsertPointsResponse
-	err = json.NewDecoder(response.Body).Decode(&result)
-	if err != nil {
-		log.Fatal("Can not unmarshall JSON")
-	}
+	err := qdrant.send(ctx, "PUT", url, request, &result)
 
-	return result
+	return result, err
 }
 
-func (qdrant *Qdrant) Search(collectionName string, vector []float64, resultsCount int) SearchResponse {
+func (qdrant *Qdrant) Search(ctx context.Context, collectionName string, vector []float64, resultsCount int) (SearchResponse, error) {
 	url := fmt.Sprintf("%s/collections/%s/points/search", qdrant.url, collectionName)
 
 	request := SearchRequest{
@@ -103,25 +124,45 @@ func (qdrant *Qdrant) Search(collectionName string, vector []float64, resultsCou
 		WithPayload: true,
 	}
 
-	body, _ := json.Marshal(request)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	var result SearchResponse
+	// Searching changes nothing, so it can be repeated.
+	err := qdrant.send(retry.Safe(ctx), "POST", url, request, &result)
+
+	return result, err
+}
+
+func (qdrant *Qdrant) send(ctx context.Context, method string, url string, request any, result any) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	if qdrant.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, qdrant.timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewBuffer(body))
 	if err != nil {
-		log.Fatalf("Error occured %v", err)
+		return err
 	}
 	req.Header.Add("Content-Type", "application/json")
 
-	response, err := http.DefaultClient.Do(req)
+	response, err := qdrant.client.Do(req)
 	if err != nil {
-		log.Fatalf("Error occured %v", err)
+		return err
 	}
 
 	defer response.Body.Close()
+	if response.StatusCode >= 400 {
+		body, _ := io.ReadAll(response.Body)
+		return fmt.Errorf("qdrant: status %d: %s", response.StatusCode, body)
+	}
 
-	var result SearchResponse
-	err = json.NewDecoder(response.Body).Decode(&result)
-	if err != nil {
-		log.Fatal("Can not unmarshall JSON")
+	if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+		return fmt.Errorf("can not unmarshal response: %w", err)
 	}
 
-	return result
+	return nil
 }
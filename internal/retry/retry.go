@@ -0,0 +1,266 @@
+package retry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Policy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	Jitter         float64
+	MaxElapsed     time.Duration
+
+	// RetryNonIdempotent allows repeating POST and PATCH requests after network
+	// errors and ambiguous server errors, when the request may already have
+	// been processed. Use it only for endpoints without side effects.
+	RetryNonIdempotent bool
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxAttempts:    5,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     30 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		MaxElapsed:     2 * time.Minute,
+	}
+}
+
+func NoRetry() Policy {
+	return Policy{MaxAttempts: 1}
+}
+
+type requestMode int
+
+const (
+	modeSafe requestMode = iota + 1
+	modeOnce
+)
+
+type modeKey struct{}
+
+// Safe marks requests made with ctx as free of side effects, so they are
+// repeated after network errors and ambiguous server errors whatever their
+// method, as if the policy allowed RetryNonIdempotent.
+func Safe(ctx context.Context) context.Context {
+	return context.WithValue(ctx, modeKey{}, modeSafe)
+}
+
+// Once keeps requests made with ctx from being repeated at all, for calls
+// that must not run twice, such as uploads or creating billed jobs.
+func Once(ctx context.Context) context.Context {
+	return context.WithValue(ctx, modeKey{}, modeOnce)
+}
+
+func modeOf(req *http.Request) requestMode {
+	mode, _ := req.Context().Value(modeKey{}).(requestMode)
+
+	return mode
+}
+
+type Transport struct {
+	Base   http.RoundTripper
+	Policy Policy
+}
+
+// Client returns a copy of client whose transport retries according to policy.
+func Client(client *http.Client, policy Policy) *http.Client {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	wrapped := *client
+	wrapped.Transport = &Transport{Base: client.Transport, Policy: policy}
+
+	return &wrapped
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base == nil {
+		return http.DefaultTransport
+	}
+
+	return t.Base
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if modeOf(req) == modeOnce {
+		return t.base().RoundTrip(req)
+	}
+
+	start := time.Now()
+	ctx := req.Context()
+
+	for attempt := 1; ; attempt++ {
+		attemptReq, err := rewind(req, attempt)
+		if err != nil {
+			return nil, err
+		}
+
+		response, err := t.base().RoundTrip(attemptReq)
+		retryable, wait := t.Policy.classify(req, response, err)
+		if !retryable || attempt >= t.Policy.MaxAttempts || (req.Body != nil && req.GetBody == nil) {
+			return response, err
+		}
+
+		delay := t.Policy.backoff(attempt)
+		if wait > delay {
+			delay = wait
+		}
+		if t.Policy.MaxElapsed > 0 && time.Since(start)+delay > t.Policy.MaxElapsed {
+			return response, err
+		}
+
+		if response != nil {
+			io.Copy(io.Discard, response.Body)
+			response.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func rewind(req *http.Request, attempt int) (*http.Request, error) {
+	if attempt == 1 || req.Body == nil || req.GetBody == nil {
+		return req, nil
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	clone := req.Clone(req.Context())
+	clone.Body = body
+
+	return clone, nil
+}
+
+func (p Policy) classify(req *http.Request, response *http.Response, err error) (bool, time.Duration) {
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return false, 0
+		}
+		return p.RetryNonIdempotent || isIdempotent(req), 0
+	}
+
+	switch response.StatusCode {
+	case http.StatusTooManyRequests:
+		if quotaExceeded(response) {
+			return false, 0
+		}
+		return true, retryAfter(response.Header)
+	case http.StatusServiceUnavailable:
+		return true, retryAfter(response.Header)
+	case http.StatusRequestTimeout, http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return p.RetryNonIdempotent || isIdempotent(req), retryAfter(response.Header)
+	}
+
+	return false, 0
+}
+
+func (p Policy) backoff(attempt int) time.Duration {
+	delay := float64(p.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		delay *= p.Multiplier
+	}
+	if p.MaxBackoff > 0 && delay > float64(p.MaxBackoff) {
+		delay = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		delay *= 1 + p.Jitter*(rand.Float64()*2-1)
+	}
+
+	return time.Duration(delay)
+}
+
+func isIdempotent(req *http.Request) bool {
+	if modeOf(req) == modeSafe {
+		return true
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+
+	return req.Header.Get("Idempotency-Key") != "" || req.Header.Get("X-Idempotency-Key") != ""
+}
+
+// quotaExceeded tells an exhausted billing quota, which waiting won't fix,
+// apart from a regular rate limit. The body is restored for the caller.
+func quotaExceeded(response *http.Response) bool {
+	body, err := io.ReadAll(io.LimitReader(response.Body, 64<<10))
+	response.Body.Close()
+	response.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+
+	return bytes.Contains(body, []byte("insufficient_quota"))
+}
+
+// retryAfter reads how long the server asked us to wait: Retry-After in
+// seconds or as a date, retry-after-ms, or the x-ratelimit-reset-* header of
+// whichever limit is exhausted.
+func retryAfter(header http.Header) time.Duration {
+	if value := header.Get("retry-after-ms"); value != "" {
+		if ms, err := strconv.ParseFloat(value, 64); err == nil {
+			return time.Duration(ms * float64(time.Millisecond))
+		}
+	}
+
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+			return time.Duration(seconds * float64(time.Second))
+		}
+		if date, err := http.ParseTime(value); err == nil {
+			return time.Until(date)
+		}
+	}
+
+	var wait time.Duration
+	for _, limit := range []string{"requests", "tokens"} {
+		if strings.TrimSpace(header.Get("x-ratelimit-remaining-"+limit)) != "0" {
+			continue
+		}
+		if reset := ParseReset(header.Get("x-ratelimit-reset-" + limit)); reset > wait {
+			wait = reset
+		}
+	}
+
+	return wait
+}
+
+// ParseReset parses x-ratelimit-reset-* values, which are Go-style durations
+// such as "1s" or "6m0s", or plain seconds.
+func ParseReset(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if duration, err := time.ParseDuration(value); err == nil {
+		return duration
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second))
+	}
+
+	return 0
+}
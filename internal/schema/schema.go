@@ -0,0 +1,439 @@
+package schema
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema is the subset of JSON Schema used for tool parameters and
+// structured outputs.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 any                `json:"type,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties any                `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Defs                 map[string]*Schema `json:"$defs,omitempty"`
+
+	// strict mode keeps string length limits out of the JSON sent to OpenAI
+	// but still enforces them in Validate.
+	minLength *int
+	maxLength *int
+}
+
+// Generate builds a schema from a Go value or reflect.Type. Fields are named
+// after their json tag and described by these tags:
+//
+//	description:"..."  enum:"a,b,c"  required:"true|false"
+//	min:"1" max:"10"   format:"date-time"  pattern:"^[a-z]+$"
+//
+// min/max apply to the value for numbers, the length for strings and the
+// item count for slices. Fields are required unless they are pointers or
+// marked omitempty; pointers are also nullable.
+func Generate(v any) (*Schema, error) {
+	return generate(v, false)
+}
+
+// GenerateStrict builds a schema accepted by OpenAI strict mode: every
+// property is required, optional fields become nullable and objects don't
+// allow additional properties.
+func GenerateStrict(v any) (*Schema, error) {
+	return generate(v, true)
+}
+
+func generate(v any, strict bool) (*Schema, error) {
+	t, ok := v.(reflect.Type)
+	if !ok {
+		t = reflect.TypeOf(v)
+	}
+	if t == nil {
+		return nil, fmt.Errorf("can not generate schema for nil")
+	}
+
+	g := &generator{
+		strict:     strict,
+		defs:       map[string]*Schema{},
+		inProgress: map[reflect.Type]bool{},
+		recursive:  map[reflect.Type]bool{},
+	}
+
+	root := derefType(t)
+	g.root = root
+	schema, err := g.typeSchema(root)
+	if err != nil {
+		return nil, err
+	}
+	if len(g.defs) > 0 {
+		schema.Defs = g.defs
+	}
+
+	return schema, nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+type generator struct {
+	strict     bool
+	root       reflect.Type
+	defs       map[string]*Schema
+	inProgress map[reflect.Type]bool
+	recursive  map[reflect.Type]bool
+}
+
+func derefType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	return t
+}
+
+func (g *generator) typeSchema(t reflect.Type) (*Schema, error) {
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.typeSchema(t.Elem())
+	case reflect.String:
+		return &Schema{Type: "string"}, nil
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}, nil
+	case reflect.Slice, reflect.Array:
+		items, err := g.typeSchema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "array", Items: items}, nil
+	case reflect.Map:
+		if g.strict {
+			return nil, fmt.Errorf("maps are not supported in strict mode: %s", t)
+		}
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("map keys must be strings: %s", t)
+		}
+		values, err := g.typeSchema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "object", AdditionalProperties: values}, nil
+	case reflect.Interface:
+		if g.strict {
+			return nil, fmt.Errorf("interfaces are not supported in strict mode: %s", t)
+		}
+		return &Schema{}, nil
+	case reflect.Struct:
+		return g.structSchema(t)
+	}
+
+	return nil, fmt.Errorf("unsupported type %s", t)
+}
+
+func (g *generator) structSchema(t reflect.Type) (*Schema, error) {
+	if g.inProgress[t] {
+		if t == g.root {
+			return &Schema{Ref: "#"}, nil
+		}
+		g.recursive[t] = true
+		return &Schema{Ref: "#/$defs/" + t.Name()}, nil
+	}
+	g.inProgress[t] = true
+	defer delete(g.inProgress, t)
+
+	schema := &Schema{
+		Type:       "object",
+		Properties: map[string]*Schema{},
+		Required:   []string{},
+	}
+	if g.strict {
+		schema.AdditionalProperties = false
+	}
+
+	for _, promoted := range jsonFields(t) {
+		field, name, optional := promoted.field, promoted.name, promoted.optional
+
+		property, err := g.typeSchema(field.Type)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", t.Name(), field.Name, err)
+		}
+		if err := g.applyTags(property, field); err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", t.Name(), field.Name, err)
+		}
+
+		if value, ok := field.Tag.Lookup("required"); ok {
+			optional = value == "false"
+		}
+
+		switch {
+		case g.strict:
+			if optional {
+				property = nullable(property)
+			}
+			schema.Required = append(schema.Required, name)
+		case field.Type.Kind() == reflect.Pointer:
+			property = nullable(property)
+		case !optional:
+			schema.Required = append(schema.Required, name)
+		}
+
+		schema.Properties[name] = property
+	}
+
+	if g.recursive[t] {
+		g.defs[t.Name()] = schema
+		return &Schema{Ref: "#/$defs/" + t.Name()}, nil
+	}
+
+	return schema, nil
+}
+
+func nullable(schema *Schema) *Schema {
+	if schema.Ref != "" {
+		return &Schema{
+			Description: schema.Description,
+			AnyOf:       []*Schema{{Ref: schema.Ref}, {Type: "null"}},
+		}
+	}
+
+	schema.Type = []any{schema.Type, "null"}
+	if schema.Enum != nil {
+		schema.Enum = append(schema.Enum, nil)
+	}
+
+	return schema
+}
+
+// jsonField is a field as encoding/json sees it, which may be promoted from
+// an embedded struct.
+type jsonField struct {
+	field    reflect.StructField
+	name     string
+	optional bool
+	tagged   bool
+	depth    int
+}
+
+// jsonFields lists the fields encoding/json encodes for t. The fields of
+// embedded structs without a json name are promoted, optional when embedded
+// through a pointer. Of fields sharing a name the shallowest wins, then the
+// only tagged one; any other tie drops them all.
+func jsonFields(t reflect.Type) []jsonField {
+	fields := []jsonField{}
+	collectFields(t, 0, false, map[reflect.Type]bool{}, &fields)
+
+	byName := map[string][]int{}
+	for i, field := range fields {
+		byName[field.name] = append(byName[field.name], i)
+	}
+
+	result := []jsonField{}
+	for i, field := range fields {
+		if dominantField(fields, byName[field.name]) == i {
+			result = append(result, field)
+		}
+	}
+
+	return result
+}
+
+func collectFields(t reflect.Type, depth int, optional bool, visited map[reflect.Type]bool, fields *[]jsonField) {
+	if visited[t] {
+		return
+	}
+	visited[t] = true
+	defer delete(visited, t)
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		embedded := derefType(field.Type)
+		if field.Anonymous && embedded.Kind() == reflect.Struct && embedded != timeType {
+			tagName, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if tagName == "" {
+				collectFields(embedded, depth+1, optional || field.Type.Kind() == reflect.Pointer, visited, fields)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		name, fieldOptional, skip := fieldName(field)
+		if skip {
+			continue
+		}
+		tagName, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		*fields = append(*fields, jsonField{
+			field:    field,
+			name:     name,
+			optional: optional || fieldOptional,
+			tagged:   tagName != "",
+			depth:    depth,
+		})
+	}
+}
+
+// dominantField returns which of the fields at indexes encoding/json keeps,
+// or -1 for none.
+func dominantField(fields []jsonField, indexes []int) int {
+	shallowest := []int{}
+	for _, i := range indexes {
+		switch {
+		case len(shallowest) == 0 || fields[i].depth < fields[shallowest[0]].depth:
+			shallowest = []int{i}
+		case fields[i].depth == fields[shallowest[0]].depth:
+			shallowest = append(shallowest, i)
+		}
+	}
+	if len(shallowest) == 1 {
+		return shallowest[0]
+	}
+
+	tagged := -1
+	for _, i := range shallowest {
+		if !fields[i].tagged {
+			continue
+		}
+		if tagged >= 0 {
+			return -1
+		}
+		tagged = i
+	}
+
+	return tagged
+}
+
+func fieldName(field reflect.StructField) (string, bool, bool) {
+	name := field.Name
+	optional := field.Type.Kind() == reflect.Pointer
+
+	tag, ok := field.Tag.Lookup("json")
+	if !ok {
+		return name, optional, false
+	}
+
+	tagName, options, _ := strings.Cut(tag, ",")
+	if tagName == "-" && options == "" {
+		return "", false, true
+	}
+	if tagName != "" {
+		name = tagName
+	}
+	for _, option := range strings.Split(options, ",") {
+		if option == "omitempty" || option == "omitzero" {
+			optional = true
+		}
+	}
+
+	return name, optional, false
+}
+
+func (g *generator) applyTags(schema *Schema, field reflect.StructField) error {
+	schema.Description = field.Tag.Get("description")
+	schema.Format = firstNonEmpty(field.Tag.Get("format"), schema.Format)
+	schema.Pattern = field.Tag.Get("pattern")
+
+	kind := derefType(field.Type).Kind()
+
+	if enum, ok := field.Tag.Lookup("enum"); ok {
+		for _, value := range strings.Split(enum, ",") {
+			parsed, err := parseValue(kind, strings.TrimSpace(value))
+			if err != nil {
+				return fmt.Errorf("invalid enum value %q: %w", value, err)
+			}
+			schema.Enum = append(schema.Enum, parsed)
+		}
+	}
+
+	for _, bound := range []string{"min", "max"} {
+		value, ok := field.Tag.Lookup(bound)
+		if !ok {
+			continue
+		}
+		if err := g.applyBound(schema, kind, bound, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (g *generator) applyBound(schema *Schema, kind reflect.Kind, bound string, value string) error {
+	switch kind {
+	case reflect.String, reflect.Slice, reflect.Array:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %w", bound, value, err)
+		}
+		switch {
+		case kind != reflect.String && bound == "min":
+			schema.MinItems = &n
+		case kind != reflect.String:
+			schema.MaxItems = &n
+		case bound == "min" && g.strict:
+			schema.minLength = &n
+		case bound == "min":
+			schema.MinLength = &n
+		case g.strict:
+			schema.maxLength = &n
+		default:
+			schema.MaxLength = &n
+		}
+	default:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %w", bound, value, err)
+		}
+		if bound == "min" {
+			schema.Minimum = &n
+		} else {
+			schema.Maximum = &n
+		}
+	}
+
+	return nil
+}
+
+func parseValue(kind reflect.Kind, value string) (any, error) {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.ParseInt(value, 10, 64)
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(value, 64)
+	case reflect.Bool:
+		return strconv.ParseBool(value)
+	}
+
+	return value, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
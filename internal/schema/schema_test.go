@@ -0,0 +1,168 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden schemas in testdata")
+
+type Address struct {
+	Street string `json:"street" description:"Street with the number"`
+	City   string `json:"city"`
+}
+
+type Nested struct {
+	Name      string    `json:"name"`
+	Home      Address   `json:"home"`
+	Previous  []Address `json:"previous"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type Optional struct {
+	Title   string `json:"title"`
+	Comment string `json:"comment,omitempty"`
+	Note    string `json:"note" required:"false"`
+	Score   int    `json:"score,omitempty" required:"true"`
+}
+
+type Pointers struct {
+	Parent *Address `json:"parent"`
+	Count  *int     `json:"count" min:"0"`
+	Label  *string  `json:"label" enum:"a,b"`
+}
+
+type Slices struct {
+	Tags   []string  `json:"tags" max:"5"`
+	Matrix [][]int   `json:"matrix"`
+	Values []float64 `json:"values,omitempty"`
+}
+
+type Base struct {
+	Id      string `json:"id"`
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+}
+
+type Audit struct {
+	Author string `json:"author"`
+}
+
+type Embedded struct {
+	Base
+	*Audit
+	// Name hides Base.Name, as it does for encoding/json.
+	Name  string `json:"name" description:"Shadows the embedded name"`
+	Owner Base   `json:"owner"`
+}
+
+type WithMap struct {
+	Labels map[string]string `json:"labels"`
+}
+
+func TestGenerateStrictGolden(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+	}{
+		{"nested", Nested{}},
+		{"optional", Optional{}},
+		{"pointer", Pointers{}},
+		{"slice", Slices{}},
+		{"embedded", Embedded{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schema, err := GenerateStrict(test.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := CheckStrict(schema); err != nil {
+				t.Fatalf("generated schema is not strict: %v", err)
+			}
+			assertGolden(t, filepath.Join("testdata", "strict_"+test.name+".json"), schema)
+		})
+	}
+}
+
+func TestGenerateGolden(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+	}{
+		{"optional", Optional{}},
+		{"pointer", Pointers{}},
+		{"embedded", Embedded{}},
+		{"map", WithMap{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schema, err := Generate(test.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertGolden(t, filepath.Join("testdata", test.name+".json"), schema)
+		})
+	}
+}
+
+func TestGenerateStrictRejectsMaps(t *testing.T) {
+	_, err := GenerateStrict(WithMap{})
+	if err == nil || !strings.Contains(err.Error(), "maps are not supported in strict mode") {
+		t.Fatalf("got %v, want an error about maps", err)
+	}
+}
+
+func TestEmbeddedFieldsValidate(t *testing.T) {
+	schema, err := GenerateStrict(Embedded{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := `{"id":"x","name":"y","version":1,"author":null,"owner":{"id":"o","name":"n","version":2}}`
+	if err := ValidateJSON(schema, []byte(valid)); err != nil {
+		t.Errorf("valid output rejected: %v", err)
+	}
+
+	var decoded Embedded
+	if err := json.Unmarshal([]byte(valid), &decoded); err != nil || decoded.Id != "x" || decoded.Name != "y" {
+		t.Errorf("encoding/json reads the output differently: %+v, %v", decoded, err)
+	}
+
+	if err := ValidateJSON(schema, []byte(`{"Base":{"id":"x"},"name":"y"}`)); err == nil {
+		t.Error("output with the embedded struct as a property accepted")
+	}
+}
+
+func assertGolden(t *testing.T, path string, schema *Schema) {
+	t.Helper()
+
+	got, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, '\n')
+
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("schema differs from %s:\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
@@ -0,0 +1,107 @@
+package schema
+
+import "fmt"
+
+// Limits of OpenAI structured outputs in strict mode.
+const (
+	MaxStrictDepth      = 10
+	MaxStrictProperties = 5000
+	MaxStrictEnumValues = 1000
+)
+
+// CheckStrict reports the first construct OpenAI rejects in strict mode:
+// a non-object root, objects allowing additional properties or leaving
+// properties optional, unsupported keywords and exceeded size limits.
+func CheckStrict(schema *Schema) error {
+	if schema.Type != "object" {
+		return fmt.Errorf("$: root must be an object, got %v", schema.Type)
+	}
+
+	properties := 0
+	if err := checkStrict(schema, "$", 1, &properties); err != nil {
+		return err
+	}
+	for name, def := range schema.Defs {
+		if err := checkStrict(def, "$defs."+name, 1, &properties); err != nil {
+			return err
+		}
+	}
+	if properties > MaxStrictProperties {
+		return fmt.Errorf("$: %d properties exceed the limit of %d", properties, MaxStrictProperties)
+	}
+
+	return nil
+}
+
+func checkStrict(schema *Schema, path string, depth int, properties *int) error {
+	if schema == nil {
+		return fmt.Errorf("%s: missing schema", path)
+	}
+	if depth > MaxStrictDepth {
+		return fmt.Errorf("%s: nesting deeper than %d levels", path, MaxStrictDepth)
+	}
+	if schema.Ref != "" {
+		return nil
+	}
+	if schema.MinLength != nil || schema.MaxLength != nil {
+		return fmt.Errorf("%s: minLength and maxLength are not supported", path)
+	}
+	if len(schema.Enum) > MaxStrictEnumValues {
+		return fmt.Errorf("%s: %d enum values exceed the limit of %d", path, len(schema.Enum), MaxStrictEnumValues)
+	}
+
+	for i, option := range schema.AnyOf {
+		if err := checkStrict(option, fmt.Sprintf("%s.anyOf[%d]", path, i), depth, properties); err != nil {
+			return err
+		}
+	}
+	if len(schema.AnyOf) > 0 {
+		return nil
+	}
+
+	if schema.Type == nil {
+		return fmt.Errorf("%s: type is required", path)
+	}
+
+	if hasType(schema.Type, "object") {
+		if schema.AdditionalProperties != false {
+			return fmt.Errorf("%s: additionalProperties must be false", path)
+		}
+		required := map[string]bool{}
+		for _, name := range schema.Required {
+			required[name] = true
+		}
+		for name, property := range schema.Properties {
+			if !required[name] {
+				return fmt.Errorf("%s.%s: every property must be required", path, name)
+			}
+			*properties++
+			if err := checkStrict(property, path+"."+name, depth+1, properties); err != nil {
+				return err
+			}
+		}
+	}
+
+	if hasType(schema.Type, "array") {
+		if err := checkStrict(schema.Items, path+"[]", depth+1, properties); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func hasType(schemaType any, name string) bool {
+	switch t := schemaType.(type) {
+	case string:
+		return t == name
+	case []any:
+		for _, option := range t {
+			if option == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,247 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ValidateJSON checks a raw JSON document against the schema.
+func ValidateJSON(schema *Schema, data []byte) error {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return &ValidationError{Path: "$", Message: err.Error()}
+	}
+
+	return Validate(schema, value)
+}
+
+// Validate checks a decoded JSON value (as produced by json.Unmarshal into
+// any) against the schema.
+func Validate(schema *Schema, value any) error {
+	return validate(schema, schema, value, "$")
+}
+
+func validate(root *Schema, schema *Schema, value any, path string) error {
+	if schema == nil {
+		return nil
+	}
+
+	if schema.Ref != "" {
+		target, err := resolve(root, schema.Ref)
+		if err != nil {
+			return &ValidationError{Path: path, Message: err.Error()}
+		}
+		return validate(root, target, value, path)
+	}
+
+	if len(schema.AnyOf) > 0 {
+		for _, option := range schema.AnyOf {
+			if validate(root, option, value, path) == nil {
+				return nil
+			}
+		}
+		return &ValidationError{Path: path, Message: "value does not match any allowed schema"}
+	}
+
+	if !matchesType(schema.Type, value) {
+		return &ValidationError{Path: path, Message: fmt.Sprintf("expected %v, got %s", schema.Type, jsonType(value))}
+	}
+
+	if len(schema.Enum) > 0 && !inEnum(schema.Enum, value) {
+		return &ValidationError{Path: path, Message: fmt.Sprintf("value %v is not one of %v", value, schema.Enum)}
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		return validateObject(root, schema, v, path)
+	case []any:
+		if schema.MinItems != nil && len(v) < *schema.MinItems {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("expected at least %d items", *schema.MinItems)}
+		}
+		if schema.MaxItems != nil && len(v) > *schema.MaxItems {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("expected at most %d items", *schema.MaxItems)}
+		}
+		for i, item := range v {
+			if err := validate(root, schema.Items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case string:
+		length := utf8.RuneCountInString(v)
+		if minLength := firstInt(schema.MinLength, schema.minLength); minLength != nil && length < *minLength {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("expected at least %d characters", *minLength)}
+		}
+		if maxLength := firstInt(schema.MaxLength, schema.maxLength); maxLength != nil && length > *maxLength {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("expected at most %d characters", *maxLength)}
+		}
+		if schema.Pattern != "" {
+			matched, err := regexp.MatchString(schema.Pattern, v)
+			if err != nil {
+				return &ValidationError{Path: path, Message: fmt.Sprintf("invalid pattern: %v", err)}
+			}
+			if !matched {
+				return &ValidationError{Path: path, Message: fmt.Sprintf("value does not match %s", schema.Pattern)}
+			}
+		}
+	case float64:
+		if schema.Minimum != nil && v < *schema.Minimum {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("value %v is below minimum %v", v, *schema.Minimum)}
+		}
+		if schema.Maximum != nil && v > *schema.Maximum {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("value %v is above maximum %v", v, *schema.Maximum)}
+		}
+	}
+
+	return nil
+}
+
+func validateObject(root *Schema, schema *Schema, value map[string]any, path string) error {
+	for _, name := range schema.Required {
+		if _, ok := value[name]; !ok {
+			return &ValidationError{Path: path + "." + name, Message: "property is required"}
+		}
+	}
+
+	for name, property := range value {
+		propertySchema, ok := schema.Properties[name]
+		if !ok {
+			switch additional := schema.AdditionalProperties.(type) {
+			case bool:
+				if !additional {
+					return &ValidationError{Path: path + "." + name, Message: "property is not allowed"}
+				}
+			case *Schema:
+				propertySchema = additional
+			}
+		}
+		if err := validate(root, propertySchema, property, path+"."+name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func resolve(root *Schema, ref string) (*Schema, error) {
+	if ref == "#" {
+		return root, nil
+	}
+
+	name, ok := strings.CutPrefix(ref, "#/$defs/")
+	if !ok {
+		return nil, fmt.Errorf("unsupported reference %s", ref)
+	}
+	target, ok := root.Defs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown reference %s", ref)
+	}
+
+	return target, nil
+}
+
+func matchesType(schemaType any, value any) bool {
+	switch t := schemaType.(type) {
+	case nil:
+		return true
+	case string:
+		return matchesSingleType(t, value)
+	case []any:
+		for _, option := range t {
+			if name, ok := option.(string); ok && matchesSingleType(name, value) {
+				return true
+			}
+		}
+	case []string:
+		for _, name := range t {
+			if matchesSingleType(name, value) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func matchesSingleType(name string, value any) bool {
+	actual := jsonType(value)
+	if name == "number" && actual == "integer" {
+		return true
+	}
+
+	return name == actual
+}
+
+func jsonType(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+
+	return reflect.TypeOf(value).String()
+}
+
+func inEnum(enum []any, value any) bool {
+	for _, allowed := range enum {
+		if allowed == value {
+			return true
+		}
+		if number, ok := value.(float64); ok && toFloat(allowed) == number {
+			return true
+		}
+	}
+
+	return false
+}
+
+func toFloat(value any) float64 {
+	switch v := value.(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	}
+
+	return math.NaN()
+}
+
+func firstInt(values ...*int) *int {
+	for _, value := range values {
+		if value != nil {
+			return value
+		}
+	}
+
+	return nil
+}
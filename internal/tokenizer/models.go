@@ -0,0 +1,63 @@
+package tokenizer
+
+import (
+	"fmt"
+	"strings"
+)
+
+var modelEncodings = map[string]string{
+	"o1":                     O200kBase,
+	"o3":                     O200kBase,
+	"o4-mini":                O200kBase,
+	"gpt-5":                  O200kBase,
+	"gpt-4.1":                O200kBase,
+	"gpt-4.5":                O200kBase,
+	"gpt-4o":                 O200kBase,
+	"chatgpt-4o-latest":      O200kBase,
+	"gpt-4":                  Cl100kBase,
+	"gpt-3.5-turbo":          Cl100kBase,
+	"gpt-35-turbo":           Cl100kBase,
+	"text-embedding-ada-002": Cl100kBase,
+	"text-embedding-3-small": Cl100kBase,
+	"text-embedding-3-large": Cl100kBase,
+	"davinci-002":            Cl100kBase,
+	"babbage-002":            Cl100kBase,
+}
+
+// Checked in order, so longer prefixes of the same family come first.
+var modelPrefixes = []struct {
+	prefix   string
+	encoding string
+}{
+	{"o1-", O200kBase},
+	{"o3-", O200kBase},
+	{"o4-mini-", O200kBase},
+	{"gpt-5-", O200kBase},
+	{"gpt-4.1-", O200kBase},
+	{"gpt-4.5-", O200kBase},
+	{"gpt-4o-", O200kBase},
+	{"chatgpt-4o-", O200kBase},
+	{"gpt-4-", Cl100kBase},
+	{"gpt-3.5-turbo-", Cl100kBase},
+	{"gpt-35-turbo-", Cl100kBase},
+}
+
+// EncodingName returns the name of the encoding used by model. Fine-tuned
+// models ("ft:gpt-4o-mini:org::id") use the encoding of their base model.
+func EncodingName(model string) (string, error) {
+	base := model
+	if rest, ok := strings.CutPrefix(model, "ft:"); ok {
+		base, _, _ = strings.Cut(rest, ":")
+	}
+
+	if name, ok := modelEncodings[base]; ok {
+		return name, nil
+	}
+	for _, p := range modelPrefixes {
+		if strings.HasPrefix(base, p.prefix) {
+			return p.encoding, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %q", ErrUnknownModel, model)
+}
@@ -0,0 +1,331 @@
+// Package tokenizer counts and trims text in the byte-pair encodings used by
+// OpenAI models. It is compatible with tiktoken's cl100k_base and o200k_base
+// and works offline: the merge tables are embedded in the binary.
+package tokenizer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	Cl100kBase = "cl100k_base"
+	O200kBase  = "o200k_base"
+)
+
+var ErrUnknownModel = errors.New("unknown model")
+
+//go:embed assets/*.tiktoken.gz
+var assets embed.FS
+
+// whitespace is Unicode White_Space; RE2's \s only covers ASCII.
+const whitespace = `\t\n\v\f\r\x{85}\p{Z}`
+
+// The patterns are tiktoken's with \s spelled out and the `\s+(?!\S)`
+// alternative, which RE2 can't express, folded into `\s+` and handled in
+// split.
+var (
+	cl100kPattern = strings.Join([]string{
+		`(?i:'s|'t|'re|'ve|'m|'ll|'d)`,
+		`[^\r\n\p{L}\p{N}]?\p{L}+`,
+		`\p{N}{1,3}`,
+		` ?[^` + whitespace + `\p{L}\p{N}]+[\r\n]*`,
+		`[` + whitespace + `]*[\r\n]+`,
+		`[` + whitespace + `]+`,
+	}, "|")
+
+	o200kPattern = strings.Join([]string{
+		`[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?`,
+		`[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?`,
+		`\p{N}{1,3}`,
+		` ?[^` + whitespace + `\p{L}\p{N}]+[\r\n/]*`,
+		`[` + whitespace + `]*[\r\n]+`,
+		`[` + whitespace + `]+`,
+	}, "|")
+)
+
+type definition struct {
+	file    string
+	pattern string
+	special map[string]int
+}
+
+var definitions = map[string]definition{
+	Cl100kBase: {
+		file:    "assets/cl100k_base.tiktoken.gz",
+		pattern: cl100kPattern,
+		special: map[string]int{
+			"<|endoftext|>":   100257,
+			"<|fim_prefix|>":  100258,
+			"<|fim_middle|>":  100259,
+			"<|fim_suffix|>":  100260,
+			"<|endofprompt|>": 100276,
+		},
+	},
+	O200kBase: {
+		file:    "assets/o200k_base.tiktoken.gz",
+		pattern: o200kPattern,
+		special: map[string]int{
+			"<|endoftext|>":   199999,
+			"<|endofprompt|>": 200018,
+		},
+	},
+}
+
+// Encoding is a loaded byte-pair encoding. It is safe for concurrent use.
+type Encoding struct {
+	name    string
+	pattern *regexp.Regexp
+	ranks   map[string]int
+	tokens  map[int][]byte
+}
+
+type loaded struct {
+	once     sync.Once
+	encoding *Encoding
+	err      error
+}
+
+var encodings = map[string]*loaded{
+	Cl100kBase: {},
+	O200kBase:  {},
+}
+
+// Get returns the named encoding, loading it on first use.
+func Get(name string) (*Encoding, error) {
+	l, ok := encodings[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown encoding %q", name)
+	}
+
+	l.once.Do(func() {
+		l.encoding, l.err = load(name, definitions[name])
+	})
+
+	return l.encoding, l.err
+}
+
+// ForModel returns the encoding used by model.
+func ForModel(model string) (*Encoding, error) {
+	name, err := EncodingName(model)
+	if err != nil {
+		return nil, err
+	}
+
+	return Get(name)
+}
+
+func load(name string, def definition) (*Encoding, error) {
+	file, err := assets.Open(def.file)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("can not read %s: %w", def.file, err)
+	}
+
+	e := &Encoding{
+		name:    name,
+		pattern: regexp.MustCompile(def.pattern),
+		ranks:   map[string]int{},
+		tokens:  map[int][]byte{},
+	}
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		encoded, value, ok := bytes.Cut(line, []byte{' '})
+		if !ok {
+			return nil, fmt.Errorf("invalid line in %s: %q", def.file, line)
+		}
+		token := make([]byte, base64.StdEncoding.DecodedLen(len(encoded)))
+		n, err := base64.StdEncoding.Decode(token, encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid token in %s: %w", def.file, err)
+		}
+		rank, err := strconv.Atoi(string(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid rank in %s: %w", def.file, err)
+		}
+
+		e.ranks[string(token[:n])] = rank
+		e.tokens[rank] = token[:n]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("can not read %s: %w", def.file, err)
+	}
+
+	for text, rank := range def.special {
+		e.tokens[rank] = []byte(text)
+	}
+
+	return e, nil
+}
+
+func (e *Encoding) Name() string {
+	return e.name
+}
+
+// Encode splits text into tokens. Special tokens such as <|endoftext|> are
+// encoded as ordinary text.
+func (e *Encoding) Encode(text string) []int {
+	tokens := []int{}
+	for _, piece := range e.split(text) {
+		if rank, ok := e.ranks[piece]; ok {
+			tokens = append(tokens, rank)
+			continue
+		}
+		tokens = append(tokens, e.merge([]byte(piece))...)
+	}
+
+	return tokens
+}
+
+// Count returns the number of tokens in text.
+func (e *Encoding) Count(text string) int {
+	count := 0
+	for _, piece := range e.split(text) {
+		if _, ok := e.ranks[piece]; ok {
+			count++
+			continue
+		}
+		count += len(e.merge([]byte(piece)))
+	}
+
+	return count
+}
+
+// Decode turns tokens back into text. Unknown tokens are skipped.
+func (e *Encoding) Decode(tokens []int) string {
+	return string(e.decodeBytes(tokens))
+}
+
+func (e *Encoding) decodeBytes(tokens []int) []byte {
+	var out []byte
+	for _, token := range tokens {
+		out = append(out, e.tokens[token]...)
+	}
+
+	return out
+}
+
+// Truncate keeps at most n tokens of text. A character split across the cut
+// is dropped rather than left half encoded.
+func (e *Encoding) Truncate(text string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+
+	tokens := e.Encode(text)
+	if len(tokens) <= n {
+		return text
+	}
+
+	out := e.decodeBytes(tokens[:n])
+	for i := 0; i < utf8.UTFMax && len(out) > 0; i++ {
+		r, size := utf8.DecodeLastRune(out)
+		if r != utf8.RuneError || size != 1 {
+			break
+		}
+		out = out[:len(out)-1]
+	}
+
+	return string(out)
+}
+
+// split cuts text into the pieces that are encoded independently. A run of
+// whitespace followed by a non-space gives up its last character to the next
+// piece, which is what `\s+(?!\S)` does in tiktoken.
+func (e *Encoding) split(text string) []string {
+	pieces := []string{}
+	for pos := 0; pos < len(text); {
+		loc := e.pattern.FindStringIndex(text[pos:])
+		if loc == nil {
+			break
+		}
+		start, end := pos+loc[0], pos+loc[1]
+		if start == end {
+			_, size := utf8.DecodeRuneInString(text[end:])
+			pos = end + size
+			continue
+		}
+
+		piece := text[start:end]
+		if end < len(text) && isSpaceRun(piece) && utf8.RuneCountInString(piece) > 1 {
+			next, _ := utf8.DecodeRuneInString(text[end:])
+			if !unicode.IsSpace(next) {
+				_, size := utf8.DecodeLastRuneInString(piece)
+				end -= size
+				piece = text[start:end]
+			}
+		}
+
+		pieces = append(pieces, piece)
+		pos = end
+	}
+
+	return pieces
+}
+
+// isSpaceRun reports whether piece came from the trailing whitespace
+// alternative: only whitespace and no line break at the end.
+func isSpaceRun(piece string) bool {
+	last, _ := utf8.DecodeLastRuneInString(piece)
+	if last == '\r' || last == '\n' {
+		return false
+	}
+
+	return strings.TrimFunc(piece, unicode.IsSpace) == ""
+}
+
+// merge applies byte-pair merges to piece, lowest rank first.
+func (e *Encoding) merge(piece []byte) []int {
+	if len(piece) == 0 {
+		return nil
+	}
+
+	// parts holds the start offsets of the current tokens.
+	parts := make([]int, len(piece)+1)
+	for i := range parts {
+		parts[i] = i
+	}
+
+	for len(parts) > 2 {
+		best, bestRank := -1, 0
+		for i := 0; i+2 < len(parts); i++ {
+			rank, ok := e.ranks[string(piece[parts[i]:parts[i+2]])]
+			if ok && (best < 0 || rank < bestRank) {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+		parts = append(parts[:best+1], parts[best+2:]...)
+	}
+
+	tokens := make([]int, 0, len(parts)-1)
+	for i := 0; i+1 < len(parts); i++ {
+		tokens = append(tokens, e.ranks[string(piece[parts[i]:parts[i+1]])])
+	}
+
+	return tokens
+}
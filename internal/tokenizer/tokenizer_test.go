@@ -0,0 +1,129 @@
+package tokenizer
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// The expected ids are what tiktoken's cl100k_base and o200k_base give,
+// with its own split patterns and lookahead.
+var encodeTests = []struct {
+	name   string
+	text   string
+	cl100k []int
+	o200k  []int
+}{
+	{"words", "hello world", []int{15339, 1917}, []int{24912, 2375}},
+	{"punctuation", "tiktoken is great!", []int{83, 1609, 5963, 374, 2294, 0}, []int{83, 8251, 2488, 382, 2212, 0}},
+	{"space run before a word", "Hello   world", []int{9906, 256, 1917}, []int{13225, 256, 2375}},
+	{"blank line", "line\n\nnext", []int{1074, 271, 3684}, []int{1137, 279, 7311}},
+	{"indent after newline", "a\n  b", []int{64, 198, 220, 293}, []int{64, 198, 220, 287}},
+	{"leading spaces and tab", "  indented\n\tcode", []int{220, 1280, 16243, 198, 44443}, []int{220, 1383, 23537, 198, 86873}},
+	{"trailing spaces", "trailing spaces   ", []int{376, 14612, 12908, 262}, []int{371, 24408, 18608, 271}},
+	{"trailing newline", "ends with newline\n", []int{1438, 449, 40127, 198}, []int{1847, 483, 95802, 198}},
+	{"contractions", "I'm sure they'll say it's fine, we've done it", []int{40, 2846, 2771, 814, 3358, 2019, 433, 596, 7060, 11, 584, 3077, 2884, 433}, []int{15390, 3239, 57956, 2891, 4275, 8975, 11, 24716, 4167, 480}},
+	{"upper case contraction", "DON'T SHOUT", []int{85741, 17773, 6570, 3740}, []int{134882, 51532, 10902, 5858}},
+	{"digits", "1234567 and 12", []int{4513, 10961, 22, 323, 220, 717}, []int{7633, 19354, 22, 326, 220, 899}},
+	{"polish", "Zażółć gęślą jaźń", []int{57, 61019, 48492, 7886, 342, 5267, 7545, 75, 5985, 12203, 40611, 19699}, []int{55302, 1777, 42107, 1187, 329, 1580, 87789, 1624, 3165, 13852, 6316}},
+	{"polish sentence", "Pchnąć w tę łódź jeża lub ośm skrzyń fig.", []int{47, 2174, 5985, 7886, 289, 259, 5267, 75527, 21151, 40611, 4864, 6077, 64, 28445, 297, 7545, 76, 1940, 89088, 19699, 4237, 13}, []int{47, 2311, 123575, 286, 172149, 61640, 16976, 13852, 1264, 59069, 14267, 293, 2259, 76, 27087, 3705, 6316, 5840, 13}},
+	{"emoji", "thumbs up 👍🏽 and 🚀!", []int{96490, 709, 62904, 235, 9468, 237, 121, 323, 11410, 248, 222, 0}, []int{42712, 82, 869, 160433, 52622, 121, 326, 169883, 222, 0}},
+	{"code", "func main() {\n\treturn x+1\n}\n", []int{2900, 1925, 368, 341, 862, 865, 10, 16, 198, 534}, []int{5652, 2758, 416, 405, 1393, 1215, 10, 16, 198, 739}},
+	{"path", "path/to/file.go", []int{2398, 33529, 24849, 18487}, []int{4189, 72231, 51766, 32812}},
+}
+
+func TestEncode(t *testing.T) {
+	for _, name := range []string{Cl100kBase, O200kBase} {
+		encoding, err := Get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, test := range encodeTests {
+			want := test.cl100k
+			if name == O200kBase {
+				want = test.o200k
+			}
+
+			t.Run(name+"/"+test.name, func(t *testing.T) {
+				got := encoding.Encode(test.text)
+				if !slices.Equal(got, want) {
+					t.Errorf("Encode(%q) = %v, want %v", test.text, got, want)
+				}
+				if count := encoding.Count(test.text); count != len(want) {
+					t.Errorf("Count(%q) = %d, want %d", test.text, count, len(want))
+				}
+				if decoded := encoding.Decode(got); decoded != test.text {
+					t.Errorf("Decode(Encode(%q)) = %q", test.text, decoded)
+				}
+			})
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	encoding, err := Get(Cl100kBase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The emoji and the diacritics take several byte tokens each, so most
+	// cuts land inside a character.
+	text := "Zażółć 👍🏽 gęślą 🚀 jaźń"
+	tokens := encoding.Encode(text)
+	for n := 0; n <= len(tokens); n++ {
+		got := encoding.Truncate(text, n)
+		if !utf8.ValidString(got) {
+			t.Errorf("Truncate(%d) = %q, not valid UTF-8", n, got)
+		}
+		if !strings.HasPrefix(text, got) {
+			t.Errorf("Truncate(%d) = %q, not a prefix of the text", n, got)
+		}
+		if count := encoding.Count(got); count > n {
+			t.Errorf("Truncate(%d) kept %d tokens", n, count)
+		}
+	}
+
+	if got := encoding.Truncate(text, len(tokens)); got != text {
+		t.Errorf("Truncate to the full length = %q, want the text", got)
+	}
+	if got := encoding.Truncate(text, -1); got != "" {
+		t.Errorf("Truncate(-1) = %q, want nothing", got)
+	}
+}
+
+func TestEncodingName(t *testing.T) {
+	tests := []struct {
+		model string
+		want  string
+	}{
+		{"gpt-4o", O200kBase},
+		{"gpt-4o-mini", O200kBase},
+		{"gpt-4o-2024-08-06", O200kBase},
+		{"chatgpt-4o-latest", O200kBase},
+		{"gpt-4.1-nano", O200kBase},
+		{"o1-preview", O200kBase},
+		{"o4-mini-2025-04-16", O200kBase},
+		{"ft:gpt-4o-mini:org::abc123", O200kBase},
+		{"gpt-4", Cl100kBase},
+		{"gpt-4-turbo", Cl100kBase},
+		{"gpt-4-0613", Cl100kBase},
+		{"gpt-3.5-turbo-0125", Cl100kBase},
+		{"ft:gpt-3.5-turbo-0125:org::abc123", Cl100kBase},
+		{"text-embedding-3-small", Cl100kBase},
+	}
+	for _, test := range tests {
+		got, err := EncodingName(test.model)
+		if err != nil || got != test.want {
+			t.Errorf("EncodingName(%q) = %q, %v, want %q", test.model, got, err, test.want)
+		}
+	}
+
+	for _, model := range []string{"llama3:8b", "gpt-4o2", "text-embedding-3"} {
+		if _, err := EncodingName(model); !errors.Is(err, ErrUnknownModel) {
+			t.Errorf("EncodingName(%q) err = %v, want %v", model, err, ErrUnknownModel)
+		}
+	}
+}